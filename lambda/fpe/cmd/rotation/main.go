@@ -0,0 +1,59 @@
+// Command rotation is the Secrets Manager rotation function of the FPE data encryption keyset,
+// see package rotation. It reads the same environment as the FPE function.
+package main
+
+import (
+	"encoding/hex"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/handlers"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/rotation"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/tenant"
+)
+
+func main() {
+	keyManager, err := handlers.KeyManagerFromEnv()
+	if err != nil {
+		log.Fatalf("Key manager: %v", err)
+	}
+
+	secretStore, err := handlers.SecretStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Secret store: %v", err)
+	}
+
+	versioned, ok := secretStore.(secretsmanager.VersionedStore)
+	if !ok {
+		log.Fatalf("Secret store does not keep versions")
+	}
+
+	tweak, err := hex.DecodeString(os.Getenv("FPE_TWEAK"))
+	if err != nil {
+		log.Fatalf("FPE_TWEAK: %v", err)
+	}
+
+	// [2022-05-05] New keys are bound to the encryption context the FPE function expects, and
+	// tested with the subkeys it derives for the tenant of the secret
+	context, err := kms.ParseEncryptionContext(os.Getenv("FPE_ENCRYPTION_CONTEXT"))
+	if err != nil {
+		log.Fatalf("FPE_ENCRYPTION_CONTEXT: %v", err)
+	}
+	base := os.Getenv("FPE_DEK_SECRET_NAME")
+
+	rotator := rotation.NewRotator(keyManager, versioned, os.Getenv("FPE_MASTER_KEY_ARN"), tweak, func(secretId string) rotation.Scope {
+		name := secretsmanager.NameOf(secretId)
+		t := tenant.FromSecretName(base, name)
+
+		return rotation.Scope{
+			EncryptionContext: handlers.ExpandEncryptionContext(context, name, t),
+			Tenant:            t,
+		}
+	})
+
+	lambda.Start(rotator.Rotate)
+}
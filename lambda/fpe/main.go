@@ -5,18 +5,80 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/blindindex"
 	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/handlers"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/marker"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/masking"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/scanner"
 )
 
 // Structure to hold parameter as JSON
 type FpeRequestParams struct {
-	Input string `json:"input"`
-	Radix int    `json:"radix"`
+	Input   string `json:"input"`
+	Radix   int    `json:"radix"`
+	Pattern string `json:"pattern,omitempty"`
+	Format  string `json:"format,omitempty"`
+
+	// [2022-02-14] "long" for block-chained encryption of long values.
+	Mode      string `json:"mode,omitempty"`
+	BlockSize int    `json:"blockSize,omitempty"`
+
+	// [2022-02-21] "ope" for order-preserving encryption over an "integer" or "date" domain.
+	Algorithm string `json:"algorithm,omitempty"`
+	Domain    string `json:"domain,omitempty"`
+
+	// [2022-02-24] Output encoding of /pseudonymize: "hex" (default), "base32" or "fpe".
+	Encoding string `json:"encoding,omitempty"`
+
+	// [2022-02-28] Return the existing token for a value on /tokenize.
+	Deterministic bool `json:"deterministic,omitempty"`
+
+	// [2022-03-03] Masking transform for /mask.
+	Mask *masking.Spec `json:"mask,omitempty"`
+
+	// [2022-03-07] Record mode: transform each field on its own.
+	Fields []handlers.Field `json:"fields,omitempty"`
+
+	// [2022-03-10] Subject identifier keying the offset of "dateshift" fields.
+	Subject string `json:"subject,omitempty"`
+
+	// [2022-03-14] Blind index options for /envelope-encrypt and /blind-index.
+	BlindIndex *blindindex.Options `json:"blindIndex,omitempty"`
+
+	// [2022-03-17] Token marker for /encrypt, /decrypt, /reencrypt and /translate, to detect already-pseudonymized values.
+	Marker *marker.Spec `json:"marker,omitempty"`
+
+	// [2022-03-21] Detectors for /scan-and-protect and /scan-and-restore; all built-in ones if empty.
+	Detectors []scanner.DetectorSpec `json:"detectors,omitempty"`
+
+	// Findings returned by /scan-and-protect, the tokens /scan-and-restore restores.
+	Findings []scanner.Finding `json:"findings,omitempty"`
+
+	// [2022-03-24] Number of rows /profile inspects.
+	SampleSize int `json:"sampleSize,omitempty"`
+
+	// [2022-03-28] Data class of the pseudonymization policy, instead of radix/pattern/format.
+	DataClass string `json:"dataClass,omitempty"`
+
+	// [2022-03-31] Registered type and its parameters, see /types.
+	Type   string          `json:"type,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+
+	// [2022-04-14] Key of the keyset for /decrypt, as returned by /encrypt.
+	KeyId string `json:"keyId,omitempty"`
+
+	// [2022-04-18] Tokens /reencrypt migrates to the primary key.
+	Tokens []handlers.ReencryptToken `json:"tokens,omitempty"`
+
+	// [2022-04-21] Pseudonymization domains /translate converts between, see the policy.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
 }
 
 func handler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
@@ -26,6 +88,42 @@ func handler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.AP
 	fmt.Println(req)
 	fmt.Println("--- Request[End] ---")
 
+	// [2022-03-31] Introspection takes no body.
+	if req.RequestContext.HTTP.Path == "/types" {
+		return handlers.Types(ctx, req)
+	}
+
+	// [2022-04-07] Neither do health checks; everything else needs the keys.
+	if req.RequestContext.HTTP.Path == "/health" {
+		if tenants != nil {
+			return tenants.Health(ctx, req)
+		}
+		return service.Health(ctx, req)
+	}
+
+	// [2022-04-28] Requests of a tenant are served with the keys of the tenant only.
+	if tenants != nil {
+		return serveTenant(ctx, req)
+	}
+	if err := service.Ready(ctx); err != nil {
+		return handlers.Unavailable()
+	}
+
+	return route(handlers.WithService(ctx, service), req)
+}
+
+// [2022-04-28] serveTenant routes req with the keys of its tenant.
+func serveTenant(ctx context.Context, req events.APIGatewayV2HTTPRequest) (resp events.APIGatewayV2HTTPResponse, err error) {
+	session, status, err := tenants.Begin(ctx, req)
+	if err != nil {
+		return handlers.HandleError(status, err)
+	}
+	defer func() { session.End(resp.StatusCode) }()
+
+	return route(session.Context(ctx), req)
+}
+
+func route(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
 	var params FpeRequestParams
 	if err := json.Unmarshal([]byte(req.Body), &params); err != nil {
 		return handlers.HandleError(http.StatusBadRequest, errors.New(handlers.ErrorInvalidBody))
@@ -38,22 +136,130 @@ func handler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.AP
 	path := req.RequestContext.HTTP.Path
 	switch path {
 	case "/encrypt":
-		return handlers.Encrypt(params.Input, params.Radix, ctx, req)
+		if params.DataClass != "" {
+			return handlers.ClassEncrypt(params.Input, params.DataClass, ctx, req)
+		}
+		if params.Type != "" {
+			return handlers.TypeEncrypt(params.Input, params.Type, params.Params, ctx, req)
+		}
+		if len(params.Fields) > 0 {
+			return handlers.EncryptFields(params.Fields, params.Subject, ctx, req)
+		}
+		if params.Pattern != "" {
+			return handlers.PatternEncrypt(params.Input, params.Pattern, ctx, req)
+		}
+		if params.Format != "" {
+			return handlers.FormatEncrypt(params.Input, params.Format, ctx, req)
+		}
+		if params.Mode == handlers.ModeLong {
+			return handlers.LongEncrypt(params.Input, params.Radix, params.BlockSize, ctx, req)
+		}
+		if params.Algorithm == handlers.AlgorithmOpe {
+			return handlers.OpeEncrypt(params.Input, params.Domain, ctx, req)
+		}
+		return handlers.Encrypt(params.Input, params.Radix, params.Marker, ctx, req)
 
 	case "/decrypt":
-		return handlers.Decrypt(params.Input, params.Radix, ctx, req)
+		if params.DataClass != "" {
+			return handlers.ClassDecrypt(params.Input, params.DataClass, ctx, req)
+		}
+		if params.Type != "" {
+			return handlers.TypeDecrypt(params.Input, params.Type, params.Params, ctx, req)
+		}
+		if len(params.Fields) > 0 {
+			return handlers.DecryptFields(params.Fields, params.Subject, ctx, req)
+		}
+		if params.Pattern != "" {
+			return handlers.PatternDecrypt(params.Input, params.Pattern, ctx, req)
+		}
+		if params.Format != "" {
+			return handlers.FormatDecrypt(params.Input, params.Format, ctx, req)
+		}
+		if params.Mode == handlers.ModeLong {
+			return handlers.LongDecrypt(params.Input, params.Radix, params.BlockSize, ctx, req)
+		}
+		if params.Algorithm == handlers.AlgorithmOpe {
+			return handlers.OpeDecrypt(params.Input, params.Domain, ctx, req)
+		}
+		return handlers.Decrypt(params.Input, params.Radix, params.KeyId, params.Marker, ctx, req)
 
 	case "/envelope-encrypt":
-		return handlers.EnvelopeEncrypt(params.Input, ctx, req)
+		return handlers.EnvelopeEncrypt(params.Input, params.BlindIndex, ctx, req)
 
 	case "/envelope-decrypt":
 		return handlers.EnvelopeDecrypt(params.Input, ctx, req)
 
+	case "/blind-index":
+		return handlers.BlindIndex(params.Input, params.BlindIndex, ctx, req)
+
+	case "/pseudonymize":
+		return handlers.Pseudonymize(params.Input, params.Encoding, ctx, req)
+
+	case "/tokenize":
+		return handlers.Tokenize(params.Input, params.Deterministic, ctx, req)
+
+	case "/detokenize":
+		return handlers.Detokenize(params.Input, ctx, req)
+
+	case "/mask":
+		return handlers.Mask(params.Input, params.Mask, ctx, req)
+
+	case "/scan-and-protect":
+		return handlers.ScanAndProtect(params.Input, params.Detectors, ctx, req)
+
+	case "/scan-and-restore":
+		return handlers.ScanAndRestore(params.Input, params.Detectors, params.Findings, ctx, req)
+
+	case "/profile":
+		return handlers.Profile(params.Input, params.SampleSize, ctx, req)
+
+	case "/reencrypt":
+		return handlers.Reencrypt(params.Tokens, params.Radix, params.Marker, ctx, req)
+
+	case "/translate":
+		return handlers.Translate(params.Input, params.Radix, params.From, params.To, params.KeyId, params.Marker, ctx, req)
+
 	default:
 		return handlers.UnhandledOperation()
 	}
 }
 
+// [2022-04-07] Keys are loaded by an explicit Service instead of at import time.
+var service *handlers.Service
+
+// [2022-04-28] Set if TENANTS_FILE lists the tenants sharing the deployment; each has a Service of its own.
+var tenants *handlers.Tenants
+
 func main() {
+	keyManager, err := handlers.KeyManagerFromEnv()
+	if err != nil {
+		log.Fatalf("Key manager: %v", err)
+	}
+
+	secretStore, err := handlers.SecretStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Secret store: %v", err)
+	}
+
+	config, err := handlers.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Configuration: %v", err)
+	}
+
+	tenants, err = handlers.TenantsFromEnv(keyManager, secretStore, config)
+	if err != nil {
+		log.Fatalf("Tenants: %v", err)
+	}
+
+	// Tenants load their keys on their first requests
+	if tenants == nil {
+		service = handlers.NewService(keyManager, secretStore, config)
+
+		// A failed start is retried by the first requests, see Service.Ready
+		if err := service.Start(context.Background()); err != nil {
+			fmt.Println("{Main} Service is unavailable:", err.Error())
+		}
+	}
+
 	lambda.Start(handler)
 }
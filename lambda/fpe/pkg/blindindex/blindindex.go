@@ -0,0 +1,136 @@
+// Package blindindex computes blind indexes: truncated, keyed digests of a normalized
+// value that are stored next to randomized ciphertext so the column can be queried
+// for equality without decrypting it.
+//
+// Truncation is deliberate. With few bits many values share an index, which hides
+// the exact value from someone holding the index column, at the cost of false
+// positives that the caller filters out after decrypting the candidate rows.
+package blindindex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+const (
+	// DefaultBits is used if Options.Bits is not set
+	DefaultBits = 32
+
+	// MaxBits is the full size of the digest
+	MaxBits = sha256.Size * 8
+)
+
+// Normalizations applied before hashing, in the order given
+const (
+	NormalizeLowercase = "lowercase"
+	NormalizeDigits    = "digits"
+	NormalizeTrim      = "trim"
+	NormalizeNoSpace   = "nospace"
+)
+
+var (
+	// ErrBitsInvalid is returned if the bit length is out of range
+	ErrBitsInvalid = errors.New("bits must be between 1 and 256, inclusive")
+
+	// ErrUnknownNormalization is returned for unknown normalizations
+	ErrUnknownNormalization = errors.New("unknown normalization, must be lowercase, digits, trim or nospace")
+)
+
+// Options control how an index is computed.
+// Indexes are only comparable if computed with identical options.
+type Options struct {
+	Bits      int      `json:"bits,omitempty"`
+	Normalize []string `json:"normalize,omitempty"`
+
+	// Context separates indexes of different columns, e.g. "customer.email"
+	Context string `json:"context,omitempty"`
+}
+
+// Validate checks the bit length and normalizations.
+func (o Options) Validate() error {
+	if o.Bits < 0 || o.Bits > MaxBits {
+		return ErrBitsInvalid
+	}
+
+	for _, n := range o.Normalize {
+		switch n {
+		case NormalizeLowercase, NormalizeDigits, NormalizeTrim, NormalizeNoSpace:
+		default:
+			return ErrUnknownNormalization
+		}
+	}
+
+	return nil
+}
+
+// An Indexer computes blind indexes under one key
+type Indexer struct {
+	key []byte
+}
+
+// New returns an Indexer keyed with key.
+func New(key []byte) (*Indexer, error) {
+	if len(key) < 16 {
+		return nil, errors.New("key must be at least 128 bits")
+	}
+
+	return &Indexer{key: append([]byte(nil), key...)}, nil
+}
+
+// Index returns the hex-encoded blind index of value. Bits beyond the requested
+// length in the last byte are zero.
+func (i *Indexer) Index(value string, o Options) (string, error) {
+	if err := o.Validate(); err != nil {
+		return "", err
+	}
+
+	bits := o.Bits
+	if bits == 0 {
+		bits = DefaultBits
+	}
+
+	mac := hmac.New(sha256.New, i.key)
+	mac.Write([]byte(o.Context))
+	mac.Write([]byte{0x00})
+	mac.Write([]byte(Normalize(value, o.Normalize)))
+	digest := mac.Sum(nil)
+
+	index := digest[:(bits+7)/8]
+	if rem := bits % 8; rem != 0 {
+		index[len(index)-1] &= byte(0xff << (8 - rem))
+	}
+
+	return hex.EncodeToString(index), nil
+}
+
+// Normalize applies the normalizations to value in order. Unknown names are ignored; see Validate.
+func Normalize(value string, normalizations []string) string {
+	for _, n := range normalizations {
+		switch n {
+		case NormalizeLowercase:
+			value = strings.ToLower(value)
+		case NormalizeDigits:
+			value = strings.Map(func(r rune) rune {
+				if unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, value)
+		case NormalizeTrim:
+			value = strings.TrimSpace(value)
+		case NormalizeNoSpace:
+			value = strings.Map(func(r rune) rune {
+				if unicode.IsSpace(r) {
+					return -1
+				}
+				return r
+			}, value)
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,76 @@
+package blindindex
+
+import "testing"
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestNormalization(t *testing.T) {
+	i, err := New(testKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	o := Options{Bits: 32, Normalize: []string{NormalizeTrim, NormalizeLowercase}, Context: "customer.email"}
+	a, _ := i.Index("Alice@Example.com ", o)
+	b, _ := i.Index("alice@example.com", o)
+	if a != b {
+		t.Fatalf("Normalized values have different indexes: %s != %s", a, b)
+	}
+
+	o = Options{Bits: 32, Normalize: []string{NormalizeDigits}}
+	a, _ = i.Index("010-1234-5678", o)
+	b, _ = i.Index("(010) 1234 5678", o)
+	if a != b {
+		t.Fatalf("Digit-only values have different indexes: %s != %s", a, b)
+	}
+}
+
+func TestBits(t *testing.T) {
+	i, _ := New(testKey)
+
+	tests := []struct {
+		bits   int
+		hexLen int
+	}{
+		{0, 8}, {1, 2}, {12, 4}, {32, 8}, {256, 64},
+	}
+
+	for _, test := range tests {
+		index, err := i.Index("value", Options{Bits: test.bits})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if len(index) != test.hexLen {
+			t.Fatalf("%d bits: expected %d hex characters, got %q", test.bits, test.hexLen, index)
+		}
+	}
+
+	// Unused low bits of the last byte are zero: 12 bits leave the last nibble empty
+	index, _ := i.Index("value", Options{Bits: 12})
+	if index[3] != '0' {
+		t.Fatalf("Truncated bits are not zero: %s", index)
+	}
+
+	if _, err := i.Index("value", Options{Bits: 257}); err != ErrBitsInvalid {
+		t.Fatalf("Expected ErrBitsInvalid, got %v", err)
+	}
+}
+
+func TestContextSeparation(t *testing.T) {
+	i, _ := New(testKey)
+
+	a, _ := i.Index("value", Options{Context: "a"})
+	b, _ := i.Index("value", Options{Context: "b"})
+	if a == b {
+		t.Fatalf("Different contexts share index %s", a)
+	}
+}
+
+func TestUnknownNormalization(t *testing.T) {
+	i, _ := New(testKey)
+
+	if _, err := i.Index("value", Options{Normalize: []string{"soundex"}}); err != ErrUnknownNormalization {
+		t.Fatalf("Expected ErrUnknownNormalization, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+// Package cyclewalk restricts an FF1 cipher to the subset of its domain that
+// satisfies a predicate, by re-encrypting until the output is back in the subset.
+//
+// Because FF1 is a permutation over all strings of a given length, following the
+// cycle of an input that satisfies the predicate is guaranteed to reach another
+// value that satisfies it. Decryption walks the same cycle backwards.
+package cyclewalk
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ff1"
+)
+
+// DefaultMaxIterations is used if no positive iteration bound is given.
+// It is generous for subsets holding at least a few percent of the domain.
+const DefaultMaxIterations = 1000
+
+// ErrInputNotInDomain is returned if the input itself does not satisfy the predicate
+var ErrInputNotInDomain = errors.New("input does not satisfy the domain predicate")
+
+// A Predicate reports whether a string belongs to the restricted domain.
+type Predicate func(string) bool
+
+// IterationLimitError is returned if no value satisfying the predicate was found
+// within the iteration bound.
+type IterationLimitError struct {
+	Limit int
+}
+
+func (e *IterationLimitError) Error() string {
+	return fmt.Sprintf("cycle-walking exceeded %d iterations", e.Limit)
+}
+
+// A Cipher is an FF1 cipher restricted to the values accepted by a predicate
+type Cipher struct {
+	ff1           ff1.Cipher
+	predicate     Predicate
+	maxIterations int
+}
+
+// NewCipher wraps an FF1 cipher so that it only maps values accepted by predicate
+// onto values accepted by predicate. A non-positive maxIterations selects DefaultMaxIterations.
+func NewCipher(c ff1.Cipher, predicate Predicate, maxIterations int) (Cipher, error) {
+	if predicate == nil {
+		return Cipher{}, errors.New("predicate must not be nil")
+	}
+
+	if maxIterations <= 0 {
+		maxIterations = DefaultMaxIterations
+	}
+
+	return Cipher{
+		ff1:           c,
+		predicate:     predicate,
+		maxIterations: maxIterations,
+	}, nil
+}
+
+// Encrypt encrypts X with the tweak of the underlying cipher
+func (c Cipher) Encrypt(X string) (string, error) {
+	return c.walk(X, c.ff1.Encrypt)
+}
+
+// EncryptWithTweak is the same as Encrypt except it overrides the tweak
+func (c Cipher) EncryptWithTweak(X string, tweak []byte) (string, error) {
+	return c.walk(X, func(s string) (string, error) {
+		return c.ff1.EncryptWithTweak(s, tweak)
+	})
+}
+
+// Decrypt decrypts X with the tweak of the underlying cipher
+func (c Cipher) Decrypt(X string) (string, error) {
+	return c.walk(X, c.ff1.Decrypt)
+}
+
+// DecryptWithTweak is the same as Decrypt except it overrides the tweak
+func (c Cipher) DecryptWithTweak(X string, tweak []byte) (string, error) {
+	return c.walk(X, func(s string) (string, error) {
+		return c.ff1.DecryptWithTweak(s, tweak)
+	})
+}
+
+func (c Cipher) walk(X string, f func(string) (string, error)) (string, error) {
+	if !c.predicate(X) {
+		return "", ErrInputNotInDomain
+	}
+
+	value := X
+	for i := 0; i < c.maxIterations; i++ {
+		var err error
+		value, err = f(value)
+		if err != nil {
+			return "", err
+		}
+
+		if c.predicate(value) {
+			return value, nil
+		}
+	}
+
+	return "", &IterationLimitError{Limit: c.maxIterations}
+}
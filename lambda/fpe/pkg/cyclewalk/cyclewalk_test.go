@@ -0,0 +1,118 @@
+package cyclewalk
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ff1"
+)
+
+func newTestFF1(t *testing.T, radix int) ff1.Cipher {
+	key, err := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3C")
+	if err != nil {
+		t.Fatalf("Unable to decode hex key: %v", err)
+	}
+
+	tweak, err := hex.DecodeString("D8E7920AFA330A73")
+	if err != nil {
+		t.Fatalf("Unable to decode tweak: %v", err)
+	}
+
+	c, err := ff1.NewCipher(radix, 8, key, tweak)
+	if err != nil {
+		t.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	return c
+}
+
+func TestLuhnRoundTrip(t *testing.T) {
+	c, err := NewCipher(newTestFF1(t, 10), Luhn, 0)
+	if err != nil {
+		t.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	for _, plaintext := range []string{"4111111111111111", "5500005555555559", "79927398713"} {
+		ciphertext, err := c.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", plaintext, err)
+		}
+
+		if !Luhn(ciphertext) {
+			t.Fatalf("Encrypt(%q) = %q is not Luhn-valid", plaintext, ciphertext)
+		}
+
+		decrypted, err := c.Decrypt(ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt(%q): %v", ciphertext, err)
+		}
+
+		if decrypted != plaintext {
+			t.Fatalf("Round trip failed.\nExpected: %v\nGot: %v", plaintext, decrypted)
+		}
+	}
+}
+
+func TestWithTweak(t *testing.T) {
+	c, err := NewCipher(newTestFF1(t, 10), Luhn, 0)
+	if err != nil {
+		t.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	plaintext := "4111111111111111"
+	tweak := []byte("tweak")
+
+	ciphertext, err := c.EncryptWithTweak(plaintext, tweak)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	decrypted, err := c.DecryptWithTweak(ciphertext, tweak)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if decrypted != plaintext {
+		t.Fatalf("Round trip failed.\nExpected: %v\nGot: %v", plaintext, decrypted)
+	}
+}
+
+func TestInputNotInDomain(t *testing.T) {
+	c, err := NewCipher(newTestFF1(t, 10), Luhn, 0)
+	if err != nil {
+		t.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	if _, err := c.Encrypt("4111111111111112"); err != ErrInputNotInDomain {
+		t.Fatalf("Expected ErrInputNotInDomain, got %v", err)
+	}
+}
+
+func TestIterationLimit(t *testing.T) {
+	// Only the input itself satisfies the predicate, so the walk cannot succeed quickly
+	plaintext := "0123456789"
+	c, err := NewCipher(newTestFF1(t, 10), func(s string) bool { return s == plaintext }, 5)
+	if err != nil {
+		t.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	_, err = c.Encrypt(plaintext)
+	limitErr, ok := err.(*IterationLimitError)
+	if !ok {
+		t.Fatalf("Expected *IterationLimitError, got %v", err)
+	}
+
+	if limitErr.Limit != 5 {
+		t.Fatalf("Expected limit 5, got %d", limitErr.Limit)
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	if _, ok := LookupFormat("luhn"); !ok {
+		t.Fatalf("Built-in luhn format is not registered")
+	}
+
+	if err := RegisterFormat("luhn", Format{Radix: 10, Predicate: Luhn}); err != ErrFormatExists {
+		t.Fatalf("Expected ErrFormatExists, got %v", err)
+	}
+}
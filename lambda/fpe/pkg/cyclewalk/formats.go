@@ -0,0 +1,99 @@
+package cyclewalk
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// A Format describes a named, predicate-restricted domain that can be encrypted
+// without writing new cipher code.
+type Format struct {
+	Radix         int
+	Predicate     Predicate
+	MaxIterations int
+	Description   string
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]Format{}
+
+	// ErrFormatExists is returned if a format is registered twice under the same name
+	ErrFormatExists = errors.New("format is already registered")
+)
+
+func init() {
+	RegisterFormat("luhn", Format{
+		Radix:       10,
+		Predicate:   Luhn,
+		Description: "Digit strings with a valid Luhn check digit (e.g. card numbers)",
+	})
+}
+
+// RegisterFormat makes a format available by name.
+func RegisterFormat(name string, f Format) error {
+	if f.Predicate == nil {
+		return errors.New("format predicate must not be nil")
+	}
+
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	if _, ok := formats[name]; ok {
+		return ErrFormatExists
+	}
+	formats[name] = f
+
+	return nil
+}
+
+// LookupFormat returns the format registered under name.
+func LookupFormat(name string) (Format, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	f, ok := formats[name]
+	return f, ok
+}
+
+// Formats returns the names of all registered formats in sorted order.
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Luhn reports whether s is a non-empty digit string with a valid Luhn check digit.
+func Luhn(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+
+		d := int(s[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return sum%10 == 0
+}
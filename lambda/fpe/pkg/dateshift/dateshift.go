@@ -0,0 +1,104 @@
+// Package dateshift shifts all dates of a subject by the same secret offset.
+//
+// The offset is derived from a keyed PRF (HMAC-SHA256) of the subject identifier and
+// bounded to [-maxDays, maxDays] without zero, so intervals between the dates of one
+// subject stay intact while the absolute dates are hidden. Shifting is reversible by
+// anyone holding the key.
+package dateshift
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultMaxDays bounds the offset if no positive bound is given
+	DefaultMaxDays = 365
+
+	// DefaultLayout is used if no layout is given
+	DefaultLayout = "2006-01-02"
+)
+
+// ErrEmptySubject is returned if no subject identifier is given
+var ErrEmptySubject = errors.New("subject identifier must not be empty")
+
+// A Shifter derives per-subject offsets from a key
+type Shifter struct {
+	key     []byte
+	maxDays int
+}
+
+// New returns a Shifter keyed with key, with offsets bounded by maxDays.
+func New(key []byte, maxDays int) (*Shifter, error) {
+	if len(key) < 16 {
+		return nil, errors.New("key must be at least 128 bits")
+	}
+
+	if maxDays <= 0 {
+		maxDays = DefaultMaxDays
+	}
+
+	return &Shifter{key: append([]byte(nil), key...), maxDays: maxDays}, nil
+}
+
+// Offset returns the subject's offset in days, in [-maxDays, -1] or [1, maxDays].
+func (s *Shifter) Offset(subject string) (int, error) {
+	if subject == "" {
+		return 0, ErrEmptySubject
+	}
+
+	n := uint64(2 * s.maxDays)
+	limit := ^uint64(0) - ^uint64(0)%n
+
+	// Rejection sampling over a counter-mode PRF stream keeps the offset unbiased
+	for counter := uint32(0); ; counter++ {
+		mac := hmac.New(sha256.New, s.key)
+		mac.Write([]byte(subject))
+
+		var c [4]byte
+		binary.BigEndian.PutUint32(c[:], counter)
+		mac.Write(c[:])
+
+		v := binary.BigEndian.Uint64(mac.Sum(nil)[:8])
+		if v >= limit {
+			continue
+		}
+
+		offset := int(v%n) - s.maxDays
+		if offset >= 0 {
+			offset++
+		}
+		return offset, nil
+	}
+}
+
+// Shift moves date (in layout, DefaultLayout if empty) by the subject's offset.
+func (s *Shifter) Shift(subject string, date string, layout string) (string, error) {
+	return s.move(subject, date, layout, 1)
+}
+
+// Unshift reverses Shift.
+func (s *Shifter) Unshift(subject string, date string, layout string) (string, error) {
+	return s.move(subject, date, layout, -1)
+}
+
+func (s *Shifter) move(subject string, date string, layout string, direction int) (string, error) {
+	if layout == "" {
+		layout = DefaultLayout
+	}
+
+	offset, err := s.Offset(subject)
+	if err != nil {
+		return "", err
+	}
+
+	t, err := time.Parse(layout, date)
+	if err != nil {
+		return "", err
+	}
+
+	return t.AddDate(0, 0, direction*offset).Format(layout), nil
+}
@@ -0,0 +1,82 @@
+package dateshift
+
+import (
+	"testing"
+	"time"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestIntervalsPreserved(t *testing.T) {
+	s, err := New(testKey, 0)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	admission, _ := s.Shift("patient-42", "2021-12-28", "")
+	discharge, _ := s.Shift("patient-42", "2022-01-04", "")
+
+	a, _ := time.Parse(DefaultLayout, admission)
+	d, _ := time.Parse(DefaultLayout, discharge)
+
+	if days := d.Sub(a).Hours() / 24; days != 7 {
+		t.Fatalf("Interval changed: %v days between %s and %s", days, admission, discharge)
+	}
+
+	if admission == "2021-12-28" {
+		t.Fatalf("Date was not shifted")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	s, _ := New(testKey, 30)
+
+	for _, layout := range []string{"", time.RFC3339, "20060102"} {
+		date := "2022-03-10"
+		switch layout {
+		case time.RFC3339:
+			date = "2022-03-10T08:30:00+09:00"
+		case "20060102":
+			date = "20220310"
+		}
+
+		shifted, err := s.Shift("subject", date, layout)
+		if err != nil {
+			t.Fatalf("Shift: %v", err)
+		}
+
+		restored, err := s.Unshift("subject", shifted, layout)
+		if err != nil {
+			t.Fatalf("Unshift: %v", err)
+		}
+
+		if restored != date {
+			t.Fatalf("Round trip failed.\nExpected: %v\nGot: %v", date, restored)
+		}
+	}
+}
+
+func TestOffsetBounds(t *testing.T) {
+	s, _ := New(testKey, 3)
+
+	seen := map[int]bool{}
+	for i := 0; i < 500; i++ {
+		offset, err := s.Offset(string(rune('a'+i%26)) + string(rune('a'+i/26)))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+
+		if offset == 0 || offset < -3 || offset > 3 {
+			t.Fatalf("Offset %d out of bounds", offset)
+		}
+		seen[offset] = true
+	}
+
+	if len(seen) != 6 {
+		t.Fatalf("Expected all 6 offsets to occur, saw %v", seen)
+	}
+
+	if _, err := s.Offset(""); err != ErrEmptySubject {
+		t.Fatalf("Expected ErrEmptySubject, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+// Package generalization implements generalization transforms for de-identification:
+// values are replaced by a coarser value that is still useful for analysis, such as a
+// birth date reduced to its year or an age reduced to a 10-year band.
+//
+// Unlike pseudonymization, generalization is irreversible and applies to quasi-identifiers.
+package generalization
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// A Kind selects the data kind and with it the typed configuration
+type Kind string
+
+const (
+	KindDate   Kind = "date"
+	KindPostal Kind = "postal"
+	KindBucket Kind = "bucket"
+	KindRound  Kind = "round"
+)
+
+// Date granularities
+const (
+	GranularityYear    = "year"
+	GranularityQuarter = "quarter"
+	GranularityMonth   = "month"
+	GranularityDecade  = "decade"
+
+	// DefaultDateLayout is used if DateConfig.Layout is empty
+	DefaultDateLayout = "2006-01-02"
+)
+
+// Rounding modes
+const (
+	RoundNearest = "nearest"
+	RoundDown    = "down"
+	RoundUp      = "up"
+)
+
+var (
+	// ErrUnknownKind is returned for kinds other than date, postal, bucket and round
+	ErrUnknownKind = errors.New("unknown generalization kind, must be date, postal, bucket or round")
+
+	// ErrMissingConfig is returned if the configuration for the kind is missing
+	ErrMissingConfig = errors.New("missing configuration for generalization kind")
+
+	// ErrInvalidConfig is returned for inconsistent parameters
+	ErrInvalidConfig = errors.New("invalid generalization configuration")
+)
+
+// DateConfig reduces a date to a year, quarter, month or decade.
+type DateConfig struct {
+	Granularity string `json:"granularity"`
+	Layout      string `json:"layout,omitempty"`
+}
+
+// PostalConfig keeps the first Keep characters of a postal code (the region).
+// The rest is dropped, or replaced with PadChar if set so the length is preserved.
+type PostalConfig struct {
+	Keep    int    `json:"keep"`
+	PadChar string `json:"padChar,omitempty"`
+}
+
+// BucketConfig places an integer into a band of Width, e.g. 37 into "30-39".
+// Values of at least TopCode, if set, are reported as "<TopCode>+".
+type BucketConfig struct {
+	Width   int  `json:"width"`
+	TopCode *int `json:"topCode,omitempty"`
+}
+
+// RoundConfig rounds a decimal number to a multiple of Multiple.
+type RoundConfig struct {
+	Multiple float64 `json:"multiple"`
+	Mode     string  `json:"mode,omitempty"`
+}
+
+// Config selects one kind and carries its typed configuration
+type Config struct {
+	Kind   Kind          `json:"kind"`
+	Date   *DateConfig   `json:"date,omitempty"`
+	Postal *PostalConfig `json:"postal,omitempty"`
+	Bucket *BucketConfig `json:"bucket,omitempty"`
+	Round  *RoundConfig  `json:"round,omitempty"`
+}
+
+// Validate checks that the configuration for the kind is present and consistent.
+func (c Config) Validate() error {
+	switch c.Kind {
+	case KindDate:
+		if c.Date == nil {
+			return ErrMissingConfig
+		}
+		switch c.Date.Granularity {
+		case GranularityYear, GranularityQuarter, GranularityMonth, GranularityDecade:
+		default:
+			return ErrInvalidConfig
+		}
+
+	case KindPostal:
+		if c.Postal == nil {
+			return ErrMissingConfig
+		}
+		if c.Postal.Keep < 0 || utf8.RuneCountInString(c.Postal.PadChar) > 1 {
+			return ErrInvalidConfig
+		}
+
+	case KindBucket:
+		if c.Bucket == nil {
+			return ErrMissingConfig
+		}
+		if c.Bucket.Width <= 0 {
+			return ErrInvalidConfig
+		}
+
+	case KindRound:
+		if c.Round == nil {
+			return ErrMissingConfig
+		}
+		if c.Round.Multiple <= 0 {
+			return ErrInvalidConfig
+		}
+		switch c.Round.Mode {
+		case "", RoundNearest, RoundDown, RoundUp:
+		default:
+			return ErrInvalidConfig
+		}
+
+	default:
+		return ErrUnknownKind
+	}
+
+	return nil
+}
+
+// Apply generalizes value according to the configuration.
+func (c Config) Apply(value string) (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+
+	switch c.Kind {
+	case KindDate:
+		return c.Date.apply(value)
+	case KindPostal:
+		return c.Postal.apply(value), nil
+	case KindBucket:
+		return c.Bucket.apply(value)
+	default:
+		return c.Round.apply(value)
+	}
+}
+
+func (c *DateConfig) apply(value string) (string, error) {
+	layout := c.Layout
+	if layout == "" {
+		layout = DefaultDateLayout
+	}
+
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return "", err
+	}
+
+	switch c.Granularity {
+	case GranularityDecade:
+		return fmt.Sprintf("%ds", t.Year()-t.Year()%10), nil
+	case GranularityQuarter:
+		return fmt.Sprintf("%04d-Q%d", t.Year(), (int(t.Month())-1)/3+1), nil
+	case GranularityMonth:
+		return t.Format("2006-01"), nil
+	default:
+		return t.Format("2006"), nil
+	}
+}
+
+func (c *PostalConfig) apply(value string) string {
+	runes := []rune(value)
+	if len(runes) <= c.Keep {
+		return value
+	}
+
+	if c.PadChar == "" {
+		return string(runes[:c.Keep])
+	}
+
+	return string(runes[:c.Keep]) + strings.Repeat(c.PadChar, len(runes)-c.Keep)
+}
+
+func (c *BucketConfig) apply(value string) (string, error) {
+	v, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return "", err
+	}
+
+	if c.TopCode != nil && v >= *c.TopCode {
+		return fmt.Sprintf("%d+", *c.TopCode), nil
+	}
+
+	// Floor division so that negative values land in the right band
+	lo := v / c.Width * c.Width
+	if v < 0 && v%c.Width != 0 {
+		lo -= c.Width
+	}
+
+	return fmt.Sprintf("%d-%d", lo, lo+c.Width-1), nil
+}
+
+func (c *RoundConfig) apply(value string) (string, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return "", err
+	}
+
+	q := v / c.Multiple
+	switch c.Mode {
+	case RoundDown:
+		q = math.Floor(q)
+	case RoundUp:
+		q = math.Ceil(q)
+	default:
+		q = math.Round(q)
+	}
+
+	// Format with the precision of the multiple to avoid artifacts such as 0.30000000000000004
+	decimals := 0
+	if m := strconv.FormatFloat(c.Multiple, 'f', -1, 64); strings.Contains(m, ".") {
+		decimals = len(m) - strings.Index(m, ".") - 1
+	}
+
+	return strconv.FormatFloat(q*c.Multiple, 'f', decimals, 64), nil
+}
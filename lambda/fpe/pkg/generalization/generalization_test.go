@@ -0,0 +1,66 @@
+package generalization
+
+import "testing"
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		config   Config
+		input    string
+		expected string
+	}{
+		{Config{Kind: KindDate, Date: &DateConfig{Granularity: GranularityYear}}, "1980-05-17", "1980"},
+		{Config{Kind: KindDate, Date: &DateConfig{Granularity: GranularityMonth}}, "1980-05-17", "1980-05"},
+		{Config{Kind: KindDate, Date: &DateConfig{Granularity: GranularityQuarter}}, "1980-05-17", "1980-Q2"},
+		{Config{Kind: KindDate, Date: &DateConfig{Granularity: GranularityDecade, Layout: "20060102"}}, "19870517", "1980s"},
+		{Config{Kind: KindPostal, Postal: &PostalConfig{Keep: 3}}, "06236", "062"},
+		{Config{Kind: KindPostal, Postal: &PostalConfig{Keep: 2, PadChar: "*"}}, "06236", "06***"},
+		{Config{Kind: KindPostal, Postal: &PostalConfig{Keep: 5}}, "123", "123"},
+		{Config{Kind: KindBucket, Bucket: &BucketConfig{Width: 10}}, "37", "30-39"},
+		{Config{Kind: KindBucket, Bucket: &BucketConfig{Width: 10}}, "-3", "-10--1"},
+		{Config{Kind: KindBucket, Bucket: &BucketConfig{Width: 10, TopCode: intPtr(90)}}, "97", "90+"},
+		{Config{Kind: KindRound, Round: &RoundConfig{Multiple: 1000}}, "123456", "123000"},
+		{Config{Kind: KindRound, Round: &RoundConfig{Multiple: 1000, Mode: RoundUp}}, "123456", "124000"},
+		{Config{Kind: KindRound, Round: &RoundConfig{Multiple: 0.1}}, "12.345", "12.3"},
+	}
+
+	for _, test := range tests {
+		got, err := test.config.Apply(test.input)
+		if err != nil {
+			t.Fatalf("%s(%q): %v", test.config.Kind, test.input, err)
+		}
+
+		if got != test.expected {
+			t.Fatalf("%s(%q):\nExpected: %v\nGot: %v", test.config.Kind, test.input, test.expected, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		config Config
+		err    error
+	}{
+		{Config{Kind: "shuffle"}, ErrUnknownKind},
+		{Config{Kind: KindDate}, ErrMissingConfig},
+		{Config{Kind: KindDate, Date: &DateConfig{Granularity: "week"}}, ErrInvalidConfig},
+		{Config{Kind: KindBucket, Bucket: &BucketConfig{}}, ErrInvalidConfig},
+		{Config{Kind: KindRound, Round: &RoundConfig{Multiple: 10, Mode: "banker"}}, ErrInvalidConfig},
+	}
+
+	for _, test := range tests {
+		if err := test.config.Validate(); err != test.err {
+			t.Fatalf("%+v: expected %v, got %v", test.config, test.err, err)
+		}
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	c := Config{Kind: KindBucket, Bucket: &BucketConfig{Width: 10}}
+	if _, err := c.Apply("forty"); err == nil {
+		t.Fatalf("Expected an error for a non-numeric bucket input")
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+)
+
+// [2022-04-04] Backends for keys and secrets; "local", "file" and "memory" run without AWS.
+const (
+	BackendAWS    = "aws"
+	BackendLocal  = "local"
+	BackendFile   = "file"
+	BackendMemory = "memory"
+
+	defaultSecretStorePath = "/tmp/fpe-secrets.json"
+)
+
+// KeyManagerFromEnv returns the key manager selected by KEY_MANAGER:
+//   - "aws" (default): AWS KMS
+//   - "local": AES-GCM under the master key in LOCAL_MASTER_KEY_FILE, or derived from LOCAL_MASTER_PASSPHRASE
+//   - "memory": AES-GCM under a random master key, lost when the process exits
+func KeyManagerFromEnv() (kms.KeyManager, error) {
+	switch os.Getenv("KEY_MANAGER") {
+	case "", BackendAWS:
+		return kms.NewKmsClient(), nil
+
+	case BackendLocal:
+		var masterKey []byte
+		var err error
+		if path := os.Getenv("LOCAL_MASTER_KEY_FILE"); path != "" {
+			masterKey, err = kms.MasterKeyFromFile(path)
+		} else {
+			masterKey, err = kms.MasterKeyFromPassphrase(os.Getenv("LOCAL_MASTER_PASSPHRASE"), nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return kms.NewLocalKeyManager(masterKey)
+
+	case BackendMemory:
+		return kms.NewMemoryKeyManager(), nil
+	}
+
+	return nil, errors.New("unknown KEY_MANAGER: " + os.Getenv("KEY_MANAGER"))
+}
+
+// SecretStoreFromEnv returns the secret store selected by SECRET_STORE:
+//   - "aws" (default): AWS Secrets Manager
+//   - "file": JSON document in SECRET_STORE_PATH, /tmp/fpe-secrets.json by default
+//   - "memory": lost when the process exits
+func SecretStoreFromEnv() (secretsmanager.SecretStore, error) {
+	switch os.Getenv("SECRET_STORE") {
+	case "", BackendAWS:
+		return secretsmanager.NewSecretsManagerClient(), nil
+
+	case BackendFile:
+		path := os.Getenv("SECRET_STORE_PATH")
+		if path == "" {
+			path = defaultSecretStorePath
+		}
+		return secretsmanager.NewFileStore(path)
+
+	case BackendMemory:
+		return secretsmanager.NewMemoryStore(), nil
+	}
+
+	return nil, errors.New("unknown SECRET_STORE: " + os.Getenv("SECRET_STORE"))
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/blindindex"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kdf"
+)
+
+// [2022-03-14] Lookup value for querying envelope-encrypted columns by equality.
+func BlindIndex(
+	input string,
+	options *blindindex.Options,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	var resp FpeResponse
+
+	kc := keysOf(ctx)
+
+	if options == nil {
+		options = &blindindex.Options{}
+	}
+
+	index, err := kc.computeBlindIndex(input, *options)
+	if errors.Is(err, kdf.ErrRevoked) {
+		return keyError(err)
+	}
+	if err != nil {
+		return HandleError(http.StatusBadRequest, err)
+	}
+
+	// Set response.
+	resp.Operation = "Blind-Index"
+	resp.Plaintext = input
+	resp.Radix = -1 // Unused
+	resp.BlindIndex = index
+	resp.KeyLabel = kc.scoped(kdf.Label{Purpose: KeyPurposeBlindIndex}).String()
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
+
+func (kc *keyContext) computeBlindIndex(input string, options blindindex.Options) (string, error) {
+	if err := options.Validate(); err != nil {
+		return "", err
+	}
+
+	key, err := kc.subkey(kdf.Label{Purpose: KeyPurposeBlindIndex})
+	if err != nil {
+		return "", err
+	}
+
+	indexer, err := blindindex.New(key)
+	if err != nil {
+		return "", err
+	}
+
+	return indexer.Index(input, options)
+}
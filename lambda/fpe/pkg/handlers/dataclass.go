@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kdf"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/policy"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/pseudonym"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// JWT claim holding the Cognito groups of the caller, matched against ReversibleBy.
+const groupsClaim = "cognito:groups"
+
+// The policy of a single-tenant deployment, see keyContext.policy.
+var loadedPolicy = policyCache{retryInterval: defaultRetryInterval, maxRetryInterval: defaultMaxRetryInterval}
+
+// [2022-05-09] policyCache keeps a policy once it is loaded. A failed load is retried on a later
+// request, with the exponential backoff a Service retries its keys with, so a Secrets Manager
+// outage at cold start does not leave the instance without a policy for its lifetime.
+type policyCache struct {
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+
+	mu          sync.Mutex
+	doc         *policy.Policy
+	err         error
+	nextAttempt time.Time
+	backoff     time.Duration
+}
+
+// get returns the cached policy, or calls load unless the last failed load is within the backoff.
+func (c *policyCache) get(load func() (*policy.Policy, error)) (*policy.Policy, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.doc != nil {
+		return c.doc, nil
+	}
+
+	if c.err != nil && time.Now().Before(c.nextAttempt) {
+		return nil, c.err
+	}
+
+	doc, err := load()
+	if err != nil {
+		if c.backoff == 0 {
+			c.backoff = c.retryInterval
+		}
+
+		c.err = err
+		c.nextAttempt = time.Now().Add(c.backoff)
+		if c.backoff *= 2; c.backoff > c.maxRetryInterval {
+			c.backoff = c.maxRetryInterval
+		}
+
+		return nil, err
+	}
+
+	c.doc, c.err = doc, nil
+
+	return doc, nil
+}
+
+// [2022-03-28] Encrypt input as defined for its data class by the pseudonymization policy.
+func ClassEncrypt(
+	input string,
+	dataClass string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest,
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return classOperation(keysOf(ctx), "Encrypt", input, dataClass, req)
+}
+
+// [2022-03-28] Decrypt input of a data class; only callers in one of the class's reversibleBy groups may do so.
+func ClassDecrypt(
+	input string,
+	dataClass string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest,
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return classOperation(keysOf(ctx), "Decrypt", input, dataClass, req)
+}
+
+func classOperation(kc *keyContext, operation string, input string, dataClass string, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	var resp FpeResponse
+
+	p, err := kc.policy()
+	if err != nil {
+		return HandleError(http.StatusInternalServerError, errors.New(ErrorPolicyUnavailable+": "+err.Error()))
+	}
+
+	c, err := p.Lookup(dataClass)
+	if err != nil {
+		return HandleError(http.StatusBadRequest, errors.New(err.Error()+": "+dataClass))
+	}
+
+	if operation == "Decrypt" {
+		if !c.Reversible() {
+			return HandleError(http.StatusBadRequest, errors.New(ErrorIrreversibleTransform+": "+c.Transform))
+		}
+		if !c.MayReverse(callerGroups(req)) {
+			return HandleError(http.StatusForbidden, errors.New(ErrorNotPermitted))
+		}
+	}
+
+	var output string
+	if operation == "Encrypt" {
+		output, err = kc.protectClass(dataClass, c, input)
+	} else {
+		output, err = kc.revealClass(dataClass, c, input)
+	}
+	if err != nil {
+		return transformError(err)
+	}
+
+	// Set response.
+	resp.Operation = "Class-" + operation
+	if operation == "Encrypt" {
+		resp.Plaintext = input
+		resp.Ciphertext = output
+	} else {
+		resp.Plaintext = output
+		resp.Ciphertext = input
+	}
+	resp.Radix = -1 // Unused
+	if c.Fpe != nil && c.Fpe.Radix != 0 {
+		resp.Radix = c.Fpe.Radix
+	}
+	resp.DataClass = dataClass
+	resp.PolicyVersion = p.Version
+	if c.Transform == policy.TransformFpe || c.Transform == policy.TransformHash {
+		resp.KeyLabel = kc.scoped(classKeyLabel(dataClass, c)).String()
+	}
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
+
+// protectClass applies the transform of class c. A "null" mask yields the empty string.
+func (kc *keyContext) protectClass(name string, c policy.DataClass, input string) (string, error) {
+	switch c.Transform {
+	case policy.TransformFpe:
+		return kc.classFpe(name, c, input, true)
+
+	case policy.TransformMask:
+		return classTransform("Encrypt", transform.TypeMask, *c.Mask, &serviceKeys{kc: kc}, input)
+
+	case policy.TransformHash:
+		key, err := kc.subkey(classKeyLabel(name, c))
+		if err != nil {
+			return "", err
+		}
+		h, err := pseudonym.New(key)
+		if err != nil {
+			return "", err
+		}
+		encoding := pseudonym.EncodingHex
+		if c.Hash != nil && c.Hash.Encoding != "" {
+			encoding = pseudonym.Encoding(c.Hash.Encoding)
+		}
+		token, err := h.Pseudonymize(input, encoding)
+		return token, transform.InvalidInput(err)
+
+	case policy.TransformGeneralize:
+		return classTransform("Encrypt", transform.TypeGeneralize, *c.Generalize, &serviceKeys{kc: kc}, input)
+	}
+
+	return "", errors.New(ErrorUnknownTransform + ": " + c.Transform)
+}
+
+func (kc *keyContext) revealClass(name string, c policy.DataClass, input string) (string, error) {
+	return kc.classFpe(name, c, input, false)
+}
+
+// classFpe encrypts or decrypts with the FF1 domain, key and tweak of class c.
+func (kc *keyContext) classFpe(name string, c policy.DataClass, input string, encrypt bool) (string, error) {
+	key, err := kc.subkey(classKeyLabel(name, c))
+	if err != nil {
+		return "", err
+	}
+
+	global, err := fpeTweak()
+	if err != nil {
+		return "", err
+	}
+
+	tweak, err := c.TweakFor(name, global)
+	if err != nil {
+		return "", err
+	}
+
+	keys := &serviceKeys{kc: kc, key: key, tweak: tweak}
+
+	operation := "Decrypt"
+	if encrypt {
+		operation = "Encrypt"
+	}
+
+	switch {
+	case c.Fpe.Pattern != "":
+		return classTransform(operation, transform.TypePattern, transform.PatternParams{Pattern: c.Fpe.Pattern}, keys, input)
+	case c.Fpe.Format != "":
+		return classTransform(operation, transform.TypeFormat, transform.FormatParams{Format: c.Fpe.Format}, keys, input)
+	}
+	return classTransform(operation, transform.TypeFpe, transform.FpeParams{Radix: c.Fpe.Radix}, keys, input)
+}
+
+// classTransform applies the registered type typ with the parameters of a data class.
+func classTransform(operation string, typ string, params interface{}, keys *serviceKeys, input string) (string, error) {
+	t, parsed, err := registered(typ, params)
+	if err != nil {
+		return "", err
+	}
+	return applyTransform(operation, t, keys, parsed, input)
+}
+
+// [2022-04-25] classKeyLabel returns the label of the key of class c. A key reference names a
+// key shared by the classes referencing it; without one, an FPE class gets a subkey of its own,
+// "fpe/class/<name>/fpe" as before the hierarchy. Hash classes keep sharing the pseudonym key
+// of /pseudonymize, so their pseudonyms stay joinable with the ones already stored.
+func classKeyLabel(name string, c policy.DataClass) kdf.Label {
+	if c.Key != "" {
+		return kdf.Label{Purpose: KeyPurposePolicy + "/" + c.Key}
+	}
+
+	if c.Transform == policy.TransformHash {
+		return kdf.Label{Purpose: KeyPurposePseudonym}
+	}
+
+	return kdf.Label{DataClass: name, Purpose: KeyPurposeFpe}
+}
+
+// policy lazily loads the pseudonymization policy from the file in POLICY_FILE or,
+// if that is not set, from the Secrets Manager secret named by POLICY_SECRET_NAME.
+func (kc *keyContext) policy() (*policy.Policy, error) {
+	// [2022-04-28] Each tenant has a policy of its own
+	if kc.tenant != "" {
+		return kc.service.tenantPolicy()
+	}
+
+	return loadedPolicy.get(func() (*policy.Policy, error) {
+		if path := os.Getenv("POLICY_FILE"); path != "" {
+			return policy.LoadFile(path)
+		}
+
+		name := os.Getenv("POLICY_SECRET_NAME")
+		if name == "" {
+			return nil, errors.New("neither POLICY_FILE nor POLICY_SECRET_NAME is set")
+		}
+
+		secrets, err := currentSecrets()
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := secrets.GetSecret(name)
+		if err != nil {
+			return nil, errors.New("policy secret " + name + ": " + err.Error())
+		}
+
+		return policy.Parse([]byte(value))
+	})
+}
+
+// callerGroups returns the Cognito groups of the caller. API Gateway renders list claims
+// as "[a b]", so brackets are trimmed and the value is split on spaces and commas.
+func callerGroups(req events.APIGatewayV2HTTPRequest) []string {
+	if req.RequestContext.Authorizer == nil || req.RequestContext.Authorizer.JWT == nil {
+		return nil
+	}
+
+	claim := strings.Trim(req.RequestContext.Authorizer.JWT.Claims[groupsClaim], "[]")
+
+	return strings.FieldsFunc(claim, func(r rune) bool { return r == ' ' || r == ',' })
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/policy"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+)
+
+const classKeysPolicy = `
+version: "2022-04-25"
+dataClasses:
+  customer.phone:
+    transform: fpe
+    fpe: {radix: 10}
+    reversibleBy: [support]
+  employee.phone:
+    transform: fpe
+    fpe: {radix: 10}
+  customer.mobile:
+    transform: fpe
+    fpe: {radix: 10}
+    key: customer
+  customer.email:
+    transform: hash
+`
+
+func TestClassKeys(t *testing.T) {
+	t.Setenv("FPE_TWEAK", "D8E7920AFA330A73")
+
+	p, err := policy.Parse([]byte(classKeysPolicy))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	loadedPolicy.doc = p
+
+	ctx := context.Background()
+	req := events.APIGatewayV2HTTPRequest{}
+	s := NewService(kms.NewMemoryKeyManager(), secretsmanager.NewMemoryStore(), Config{
+		SecretName:       "/secret/fpe/dek",
+		RevokedKeyLabels: []string{"fpe/class/employee.phone/fpe"},
+	})
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	const plaintext = "01012345678"
+	phone := decodeFpeResponse(t, mustResponse(ClassEncrypt(plaintext, "customer.phone", ctx, req)))
+	if phone.KeyLabel != "fpe/class/customer.phone/fpe" {
+		t.Fatalf("Unexpected key label %q", phone.KeyLabel)
+	}
+
+	mobile := decodeFpeResponse(t, mustResponse(ClassEncrypt(plaintext, "customer.mobile", ctx, req)))
+	if mobile.KeyLabel != "fpe/policy/customer" {
+		t.Fatalf("Unexpected key label %q", mobile.KeyLabel)
+	}
+
+	// The class subkey is not the data encryption key of the default /encrypt
+	plain := decodeFpeResponse(t, mustResponse(Encrypt(plaintext, 10, nil, ctx, req)))
+	if phone.Ciphertext == plain.Ciphertext || phone.Ciphertext == mobile.Ciphertext {
+		t.Fatalf("Data classes must be encrypted under different keys")
+	}
+
+	support := events.APIGatewayV2HTTPRequest{}
+	support.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+			Claims: map[string]string{groupsClaim: "[support]"},
+		},
+	}
+	dec := decodeFpeResponse(t, mustResponse(ClassDecrypt(phone.Ciphertext, "customer.phone", ctx, support)))
+	if dec.Plaintext != plaintext {
+		t.Fatalf("Decrypt: got %q", dec.Plaintext)
+	}
+
+	// Hash classes share the key of /pseudonymize, as before subkeys
+	email := decodeFpeResponse(t, mustResponse(ClassEncrypt("someone@example.com", "customer.email", ctx, req)))
+	pseudonym := decodeFpeResponse(t, mustResponse(Pseudonymize("someone@example.com", "", ctx, req)))
+	if email.KeyLabel != "fpe/pseudonym" || email.Ciphertext != pseudonym.Token {
+		t.Fatalf("Expected the pseudonym of /pseudonymize, got %+v and %+v", email, pseudonym)
+	}
+
+	// A revoked subkey fails without affecting the others
+	resp, _ := ClassEncrypt(plaintext, "employee.phone", ctx, req)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status %d for a revoked key, got %d: %s", http.StatusForbidden, resp.StatusCode, resp.Body)
+	}
+}
+
+func TestPolicyLoadRetried(t *testing.T) {
+	store := secretsmanager.NewMemoryStore()
+	s := NewService(kms.NewMemoryKeyManager(), store, Config{
+		Tenant:           "acme",
+		PolicySecretName: "/secret/fpe/policy/acme",
+		RetryInterval:    50 * time.Millisecond,
+	})
+
+	if _, err := s.tenantPolicy(); err == nil {
+		t.Fatalf("Expected an error before the policy secret exists")
+	}
+
+	if err := store.CreateSecret("/secret/fpe/policy/acme", classKeysPolicy, ""); err != nil {
+		t.Fatalf("CreateSecret: %v", err)
+	}
+
+	// Within the backoff the failure is answered without reading the secret again
+	if _, err := s.tenantPolicy(); err == nil {
+		t.Fatalf("Expected the failed load to be retried only after the backoff")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	p, err := s.tenantPolicy()
+	if err != nil {
+		t.Fatalf("Expected the policy after the backoff, got %v", err)
+	}
+	if _, ok := p.DataClasses["customer.phone"]; !ok {
+		t.Fatalf("Loaded policy misses customer.phone: %v", p.DataClasses)
+	}
+
+	// A loaded policy is kept
+	if err := store.PutSecretVersion("/secret/fpe/policy/acme", "v2", "not a policy", []string{secretsmanager.StageCurrent}); err != nil {
+		t.Fatalf("PutSecretVersion: %v", err)
+	}
+	if again, err := s.tenantPolicy(); err != nil || again != p {
+		t.Fatalf("Expected the cached policy, got %v, %v", again, err)
+	}
+}
@@ -1,8 +1,32 @@
 package handlers
 
 var (
-	ErrorInvalidBody        = "invalid body data in request"
-	ErrorUnhandledOperation = "unhandled operation"
+	ErrorInvalidBody           = "invalid body data in request"
+	ErrorUnhandledOperation    = "unhandled operation"
+	ErrorInvalidPattern        = "invalid pattern"
+	ErrorUnknownFormat         = "unknown format"
+	ErrorMissingMask           = "missing mask specification"
+	ErrorUnknownTransform      = "unknown transform"
+	ErrorIrreversibleTransform = "transform is irreversible"
+	ErrorInvalidMarker         = "invalid marker specification"
+	ErrorAlreadyProtected      = "input is already a token"
+	ErrorNotAToken             = "input is not a token"
+	ErrorInvalidDetector       = "invalid detector"
+	ErrorInvalidFindings       = "invalid findings, give those returned by /scan-and-protect"
+	ErrorInvalidTable          = "invalid CSV data"
+	ErrorPolicyUnavailable     = "pseudonymization policy is not available"
+	ErrorNotPermitted          = "caller may not reverse this data class"
+	ErrorUnknownType           = "unknown type"
+	ErrorInvalidParams         = "invalid parameters"
+	ErrorKeysUnavailable       = "data encryption key is not available, retry later"
+	ErrorUnknownKey            = "unknown key ID"
+	ErrorKeyRetired            = "key is retired"
+	ErrorKeyIdRequired         = "keyId is required, more than one key may have encrypted the input"
+	ErrorInvalidBatch          = "invalid token batch"
+	ErrorUnknownTokenType      = "unknown token type"
+	ErrorTranslationNotAllowed = "translation is not allowed by the policy"
+	ErrorUnknownTenant         = "caller does not belong to a known tenant"
+	ErrorQuotaExceeded         = "tenant quota exceeded"
 )
 
 // Generic type for error body
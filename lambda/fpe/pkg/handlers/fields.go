@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/dateshift"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/generalization"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/masking"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// [2022-03-07] Transforms applicable to a single field of a record.
+const (
+	TransformFpe        = transform.TypeFpe
+	TransformMask       = transform.TypeMask
+	TransformGeneralize = transform.TypeGeneralize
+	TransformDateShift  = "dateshift"
+)
+
+// Field is one value of a record and the transform to apply to it.
+// This lets one request mix FPE on identifiers with generalization on quasi-identifiers.
+type Field struct {
+	Name       string                 `json:"name"`
+	Input      string                 `json:"input"`
+	Transform  string                 `json:"transform"`
+	Radix      int                    `json:"radix,omitempty"`
+	Mask       *masking.Spec          `json:"mask,omitempty"`
+	Generalize *generalization.Config `json:"generalize,omitempty"`
+
+	// [2022-03-10] Layout of a "dateshift" field, 2006-01-02 by default.
+	DateLayout string `json:"dateLayout,omitempty"`
+}
+
+// Every "dateshift" field is shifted by the offset of subject, so intervals within a record stay intact.
+func EncryptFields(
+	fields []Field,
+	subject string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return fieldsOperation(keysOf(ctx), "Encrypt", fields, subject)
+}
+
+func DecryptFields(
+	fields []Field,
+	subject string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return fieldsOperation(keysOf(ctx), "Decrypt", fields, subject)
+}
+
+func fieldsOperation(kc *keyContext, operation string, fields []Field, subject string) (events.APIGatewayV2HTTPResponse, error) {
+	var resp FieldsResponse
+
+	for _, field := range fields {
+		var output *string
+		var err error
+		if operation == "Encrypt" {
+			output, err = kc.protectField(field, subject)
+		} else {
+			output, err = kc.revealField(field, subject)
+		}
+		if err != nil {
+			return HandleError(http.StatusBadRequest, errors.New(field.Name+": "+err.Error()))
+		}
+
+		resp.Fields = append(resp.Fields, FieldResult{
+			Name:      field.Name,
+			Transform: field.Transform,
+			Output:    output,
+		})
+	}
+
+	// Set response.
+	resp.Operation = "Fields-" + operation
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
+
+// protectField applies the field's transform. A nil output stands for a nulled value.
+func (kc *keyContext) protectField(field Field, subject string) (*string, error) {
+	var output string
+
+	switch field.Transform {
+	case TransformFpe, TransformMask, TransformGeneralize:
+		t, params, err := fieldTransformer(field)
+		if err != nil {
+			return nil, err
+		}
+		output, err = applyTransform("Encrypt", t, &serviceKeys{kc: kc}, params, field.Input)
+		if err != nil {
+			return nil, err
+		}
+		if field.Transform == TransformMask && field.Mask.Nulls() {
+			return nil, nil
+		}
+
+	case TransformDateShift:
+		shifter, err := kc.dateShifter()
+		if err != nil {
+			return nil, err
+		}
+		output, err = shifter.Shift(subject, field.Input, field.DateLayout)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, errors.New(ErrorUnknownTransform + ": " + field.Transform)
+	}
+
+	return &output, nil
+}
+
+// revealField reverses the field's transform if it is reversible.
+func (kc *keyContext) revealField(field Field, subject string) (*string, error) {
+	switch field.Transform {
+	case TransformFpe:
+		t, params, err := fieldTransformer(field)
+		if err != nil {
+			return nil, err
+		}
+		output, err := applyTransform("Decrypt", t, &serviceKeys{kc: kc}, params, field.Input)
+		if err != nil {
+			return nil, err
+		}
+		return &output, nil
+
+	case TransformDateShift:
+		shifter, err := kc.dateShifter()
+		if err != nil {
+			return nil, err
+		}
+		output, err := shifter.Unshift(subject, field.Input, field.DateLayout)
+		if err != nil {
+			return nil, err
+		}
+		return &output, nil
+
+	case TransformMask, TransformGeneralize:
+		return nil, errors.New(ErrorIrreversibleTransform + ": " + field.Transform)
+
+	default:
+		return nil, errors.New(ErrorUnknownTransform + ": " + field.Transform)
+	}
+}
+
+// fieldTransformer returns the registered transformer and parameters of an fpe, mask or generalize field.
+func fieldTransformer(field Field) (transform.Transformer, transform.Params, error) {
+	switch field.Transform {
+	case TransformMask:
+		if field.Mask == nil {
+			return nil, nil, errors.New(ErrorMissingMask)
+		}
+		return registered(field.Transform, *field.Mask)
+
+	case TransformGeneralize:
+		if field.Generalize == nil {
+			return nil, nil, generalization.ErrMissingConfig
+		}
+		return registered(field.Transform, *field.Generalize)
+	}
+
+	return registered(field.Transform, transform.FpeParams{Radix: field.Radix})
+}
+
+// Date shifter keyed from the data encryption key; DATE_SHIFT_MAX_DAYS bounds the offset (365 by default).
+func (kc *keyContext) dateShifter() (*dateshift.Shifter, error) {
+	key, err := kc.deriveKey(KeyPurposeDateShift)
+	if err != nil {
+		return nil, err
+	}
+
+	maxDays, _ := strconv.Atoi(os.Getenv("DATE_SHIFT_MAX_DAYS"))
+
+	return dateshift.New(key, maxDays)
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/cyclewalk"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// [2022-02-09] Encrypt inputs of a named, predicate-restricted format (see cyclewalk.RegisterFormat).
+func FormatEncrypt(
+	input string,
+	format string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return formatOperation(keysOf(ctx), "Encrypt", input, format)
+}
+
+func FormatDecrypt(
+	input string,
+	format string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return formatOperation(keysOf(ctx), "Decrypt", input, format)
+}
+
+func formatOperation(kc *keyContext, operation string, input string, format string) (events.APIGatewayV2HTTPResponse, error) {
+	var resp FpeResponse
+
+	f, ok := cyclewalk.LookupFormat(format)
+	if !ok {
+		return HandleError(http.StatusBadRequest, errors.New(ErrorUnknownFormat+": "+format))
+	}
+
+	t, params, err := registered(transform.TypeFormat, transform.FormatParams{Format: format})
+	if err != nil {
+		return HandleError(http.StatusBadRequest, errors.New(ErrorUnknownFormat+": "+format))
+	}
+
+	keys := serviceKeys{kc: kc}
+	var plaintext, ciphertext string
+	if operation == "Encrypt" {
+		plaintext = input
+		ciphertext, err = applyTransform(operation, t, &keys, params, plaintext)
+	} else {
+		ciphertext = input
+		plaintext, err = applyTransform(operation, t, &keys, params, ciphertext)
+	}
+	if err != nil {
+		return transformError(err)
+	}
+
+	// Set response.
+	resp.Operation = "Format-" + operation
+	resp.Plaintext = plaintext
+	resp.Ciphertext = ciphertext
+	resp.Radix = f.Radix
+	resp.Format = format
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
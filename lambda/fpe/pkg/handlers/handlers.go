@@ -20,7 +20,6 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
-	"encoding/binary"
 	"encoding/gob"
 	"encoding/hex"
 	"errors"
@@ -31,9 +30,10 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ff1"
-	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
-	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/blindindex"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/keyset"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/marker"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
 	"golang.org/x/crypto/nacl/secretbox"
 )
 
@@ -43,45 +43,11 @@ type KmsPayload struct {
 	Message          []byte
 }
 
-var (
-	kmsClient            = kms.NewKmsClient()
-	secretsManagerClient = secretsmanager.NewSecretsManagerClient()
-
-	// FPE encryption/decryption key bytes as plain in global state.
-	// NOTE: This is only for faster operation, and have to be encrypted form instead if this concerns you.
-	dekBlob         []byte
-	dekEnvelopeBlob []byte
-)
-
-func init() {
-	fmt.Println("{Handlers} Initializing to acquire FPE data encryption key.")
-
-	// var dekEnvelopeBlob []byte
-	exist, secretValue := secretsManagerClient.CheckIfSecretValueExist(os.Getenv("FPE_DEK_SECRET_NAME"))
-	if exist {
-		// FPE data encryption key sucessfully retrieved from Secrets Manager.
-		// Parse it as byte array.
-		fmt.Println("Encrypted FPE Data Encryption Key: ", *secretValue)
-
-		// [2021-11-20] Decrypt FPE data encryption key.
-		dekEnvelopeBlob, _ = hex.DecodeString(*secretValue)
-	} else {
-		// Secret value for FPE data encryption key does not exist, create a new one
-		dekEnvelopeBlob = kmsClient.GenerateDEK(os.Getenv("FPE_MASTER_KEY_ARN"))
-		secretsManagerClient.CreateSecretWithValue(
-			os.Getenv("FPE_DEK_SECRET_NAME"),
-			hex.EncodeToString(dekEnvelopeBlob),
-			"FPE data enryption key protected by KMS CMK.",
-		)
-	}
-
-	dekBlob = kmsClient.DecryptDEK(dekEnvelopeBlob)
-}
-
 func Encrypt(
 	input string,
 	radix int,
-	ctx context.Context, // Reserved.
+	markerSpec *marker.Spec, // [2022-03-17] Optional token marker.
+	ctx context.Context,
 	req events.APIGatewayV2HTTPRequest, // Reserved.
 ) (
 	events.APIGatewayV2HTTPResponse,
@@ -89,25 +55,54 @@ func Encrypt(
 ) {
 	var resp FpeResponse
 
-	// Key and tweak should be byte arrays. Put your key and tweak here.
-	key := dekBlob
-	tweak, err := hex.DecodeString(os.Getenv("FPE_TWEAK"))
+	kc := keysOf(ctx)
+
+	// [2022-04-14] New data is always encrypted with the primary key of the keyset.
+	primary, key, err := kc.primaryKey()
 	if err != nil {
-		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+		return HandleError(http.StatusServiceUnavailable, errors.New(ErrorKeysUnavailable))
 	}
 
-	// Create a new FF1 cipher "object"
-	FF1, err := ff1.NewCipher(radix, binary.Size(tweak), key, tweak)
+	t, params, err := registered(transform.TypeFpe, transform.FpeParams{Radix: radix})
 	if err != nil {
-		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+		return HandleError(http.StatusBadRequest, errors.New(err.Error()))
+	}
+
+	scheme, err := tokenMarker(markerSpec, radix, key)
+	if err != nil {
+		return HandleError(http.StatusBadRequest, errors.New(ErrorInvalidMarker))
+	}
+
+	// [2022-03-17] Never encrypt our own tokens twice, e.g. when an ETL job is re-run.
+	if scheme != nil {
+		if _, ok := scheme.Unmark(input); ok {
+			if markerSpec.Rejects() {
+				return HandleError(http.StatusConflict, errors.New(ErrorAlreadyProtected))
+			}
+
+			resp.Operation = "Encrypt"
+			resp.Ciphertext = input
+			resp.Radix = radix
+			resp.AlreadyProtected = true
+
+			return apiResponse(
+				http.StatusOK,
+				&resp,
+			)
+		}
 	}
 
 	plaintext := input
 
-	// Call the encryption function on a plaintext
-	ciphertext, err := FF1.Encrypt(plaintext)
+	// Call the encryption function on a plaintext, under the FF1 subkey of the primary key
+	keys := &serviceKeys{kc: kc, source: primary, dek: key}
+	ciphertext, err := applyTransform("Encrypt", t, keys, params, plaintext)
 	if err != nil {
-		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+		return transformError(err)
+	}
+
+	if scheme != nil {
+		ciphertext = scheme.Mark(ciphertext)
 	}
 
 	// WARNING) For debugging only
@@ -119,6 +114,8 @@ func Encrypt(
 	resp.Plaintext = plaintext
 	resp.Ciphertext = ciphertext
 	resp.Radix = radix
+	resp.KeyId = primary.ID
+	resp.KeyLabel = keys.label
 
 	return apiResponse(
 		http.StatusOK,
@@ -129,7 +126,9 @@ func Encrypt(
 func Decrypt(
 	input string,
 	radix int,
-	ctx context.Context, // Reserved.
+	keyId string, // [2022-04-14] Optional key of the keyset the input was encrypted with.
+	markerSpec *marker.Spec, // [2022-03-17] Optional token marker.
+	ctx context.Context,
 	req events.APIGatewayV2HTTPRequest, // Reserved.
 ) (
 	events.APIGatewayV2HTTPResponse,
@@ -137,24 +136,24 @@ func Decrypt(
 ) {
 	var resp FpeResponse
 
-	// Key and tweak should be byte arrays. Put your key and tweak here.
-	key := dekBlob
-	tweak, err := hex.DecodeString("D8E7920AFA330A73")
+	t, params, err := registered(transform.TypeFpe, transform.FpeParams{Radix: radix})
 	if err != nil {
-		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+		return HandleError(http.StatusBadRequest, errors.New(err.Error()))
 	}
 
-	FF1, err := ff1.NewCipher(radix, 8, key, tweak)
+	kc := keysOf(ctx)
+
+	k, key, ciphertext, status, err := kc.fpeTokenKey(input, radix, keyId, markerSpec)
 	if err != nil {
-		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+		return HandleError(status, err)
 	}
+	resp.KeyId = k.ID
 
-	ciphertext := input
-
-	// Call the encryption function on an example SSN
-	plaintext, err := FF1.Decrypt(ciphertext)
+	// Call the decryption function with the subkey and the tweak, FPE_TWEAK, Encrypt used
+	keys := &serviceKeys{kc: kc, source: k, dek: key}
+	plaintext, err := applyTransform("Decrypt", t, keys, params, ciphertext)
 	if err != nil {
-		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+		return transformError(err)
 	}
 
 	// WARNING) For debugging only
@@ -164,8 +163,9 @@ func Decrypt(
 	// Set response.
 	resp.Operation = "Decrypt"
 	resp.Plaintext = plaintext
-	resp.Ciphertext = ciphertext
+	resp.Ciphertext = input
 	resp.Radix = radix
+	resp.KeyLabel = keys.label
 
 	return apiResponse(
 		http.StatusOK,
@@ -175,7 +175,8 @@ func Decrypt(
 
 func EnvelopeEncrypt(
 	input string,
-	ctx context.Context, // Reserved.
+	blindIndexOptions *blindindex.Options, // [2022-03-14] Optional blind index returned alongside the ciphertext.
+	ctx context.Context,
 	req events.APIGatewayV2HTTPRequest, // Reserved.
 ) (
 	events.APIGatewayV2HTTPResponse,
@@ -186,40 +187,15 @@ func EnvelopeEncrypt(
 	// fmt.Println("[EnvelopeEncrypt] dekEnvelopeBlob: ", hex.EncodeToString(dekEnvelopeBlob))
 	// fmt.Println("[EnvelopeEncrypt] dekBlob: ", hex.EncodeToString(dekBlob))
 
-	// Initialize payload.
-	payload := &KmsPayload{
-		EncryptedDataKey: dekEnvelopeBlob,
-		Nonce:            &[24]byte{},
-	}
-
-	// Generate nonce.
-	_, err := io.ReadFull(rand.Reader, payload.Nonce[:])
-	if err != nil {
-		return HandleError(http.StatusInternalServerError, errors.New("failed to generate random nonce: "+err.Error()))
-	}
-
 	plaintext := input
-	plainbytes := []byte(plaintext)
-
-	var dataKey [32]byte
-	copy(dataKey[:], dekBlob)
-
-	// Encrypt message.
-	payload.Message = secretbox.Seal(
-		payload.Message,
-		plainbytes,
-		payload.Nonce,
-		// (*[32]byte)(unsafe.Pointer(&dekBlob)),
-		&dataKey,
-	)
+	kc := keysOf(ctx)
 
-	buffer := &bytes.Buffer{}
-	if err := gob.NewEncoder(buffer).Encode(payload); err != nil {
-		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+	// [2022-04-14] New data is always encrypted with the primary key of the keyset.
+	ciphertext, primary, status, err := kc.sealEnvelope([]byte(plaintext))
+	if err != nil {
+		return HandleError(status, err)
 	}
 
-	ciphertext := encode(buffer.Bytes())
-
 	// WARNING) For debugging only
 	fmt.Println("Plaintext:", plaintext)
 	fmt.Println("Ciphertext:", ciphertext)
@@ -229,6 +205,14 @@ func EnvelopeEncrypt(
 	resp.Plaintext = plaintext
 	resp.Ciphertext = ciphertext
 	resp.Radix = -1 // Unused
+	resp.KeyId = primary.ID
+
+	if blindIndexOptions != nil {
+		resp.BlindIndex, err = kc.computeBlindIndex(plaintext, *blindIndexOptions)
+		if err != nil {
+			return HandleError(http.StatusBadRequest, err)
+		}
+	}
 
 	return apiResponse(
 		http.StatusOK,
@@ -238,7 +222,7 @@ func EnvelopeEncrypt(
 
 func EnvelopeDecrypt(
 	input string,
-	ctx context.Context, // Reserved.
+	ctx context.Context,
 	req events.APIGatewayV2HTTPRequest, // Reserved.
 ) (
 	events.APIGatewayV2HTTPResponse,
@@ -249,36 +233,11 @@ func EnvelopeDecrypt(
 	// fmt.Println("[EnvelopeDecrypt] dekEnvelopeBlob: ", hex.EncodeToString(dekEnvelopeBlob))
 	// fmt.Println("[EnvelopeDecrypt] dekBlob: ", hex.EncodeToString(dekBlob))
 
-	// Extract payload.
-	// encrypted, err := decode(input[8 : len(input)-1])
-	encrypted, err := decode(input)
+	plainbytes, keyId, status, err := keysOf(ctx).openEnvelope(input)
 	if err != nil {
-		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
-	}
-
-	// Decode payload structure.
-	var payload KmsPayload
-	gob.NewDecoder(bytes.NewReader(encrypted)).Decode(&payload)
-
-	// // [2021-12-28] Decrypt key.
-	// dataKey := kmsClient.DecryptDEK(payload.EncryptedDataKey)
-	// fmt.Println("[EnvelopeDecrypt] decrypted data key: ", hex.EncodeToString(dataKey))
-
-	var dataKey [32]byte
-	copy(dataKey[:], dekBlob)
-
-	// Decrypt message.
-	var plainbytes []byte
-	plainbytes, ok := secretbox.Open(
-		plainbytes,
-		payload.Message,
-		payload.Nonce,
-		// (*[32]byte)(unsafe.Pointer(&dekBlob)),
-		&dataKey,
-	)
-	if !ok {
-		return HandleError(http.StatusInternalServerError, errors.New("failed to open secretbox"))
+		return HandleError(status, err)
 	}
+	resp.KeyId = keyId
 
 	ciphertext := input
 	plaintext := string(plainbytes)
@@ -299,6 +258,136 @@ func EnvelopeDecrypt(
 	)
 }
 
+// [2022-04-18] sealEnvelope encrypts plainbytes with the primary key into an encoded KmsPayload.
+// It returns the HTTP status to answer with on error.
+func (kc *keyContext) sealEnvelope(plainbytes []byte) (string, keyset.Key, int, error) {
+	primary, key, err := kc.primaryKey()
+	if err != nil {
+		return "", keyset.Key{}, http.StatusServiceUnavailable, errors.New(ErrorKeysUnavailable)
+	}
+
+	ciphertext, err := SealEnvelope(primary, key, plainbytes)
+	if err != nil {
+		return "", keyset.Key{}, http.StatusInternalServerError, err
+	}
+
+	return ciphertext, primary, http.StatusOK, nil
+}
+
+// [2022-05-09] SealEnvelope encrypts plainbytes with k, unwrapped to key, into an encoded KmsPayload.
+// The rotation function seals with a pending key to test it before it is promoted.
+func SealEnvelope(k keyset.Key, key []byte, plainbytes []byte) (string, error) {
+	// Initialize payload.
+	payload := &KmsPayload{
+		EncryptedDataKey: k.Wrapped(),
+		Nonce:            &[24]byte{},
+	}
+
+	// Generate nonce.
+	if _, err := io.ReadFull(rand.Reader, payload.Nonce[:]); err != nil {
+		return "", errors.New("failed to generate random nonce: " + err.Error())
+	}
+
+	var dataKey [32]byte
+	copy(dataKey[:], key)
+
+	// Encrypt message.
+	payload.Message = secretbox.Seal(
+		payload.Message,
+		plainbytes,
+		payload.Nonce,
+		// (*[32]byte)(unsafe.Pointer(&dekBlob)),
+		&dataKey,
+	)
+
+	buffer := &bytes.Buffer{}
+	if err := gob.NewEncoder(buffer).Encode(payload); err != nil {
+		return "", errors.New(err.Error())
+	}
+
+	return encode(buffer.Bytes()), nil
+}
+
+// [2022-04-18] openEnvelope decrypts an encoded KmsPayload and returns the ID of the key that opened it.
+// It returns the HTTP status to answer with on error.
+func (kc *keyContext) openEnvelope(input string) ([]byte, string, int, error) {
+	payload, status, err := decodeEnvelope(input)
+	if err != nil {
+		return nil, "", status, err
+	}
+
+	// [2022-04-14] The payload carries the wrapped key it was sealed with; payloads whose
+	// key is not recognized are tried against every usable key.
+	candidates := kc.usableKeys()
+	for _, k := range candidates {
+		if bytes.Equal(k.Wrapped(), payload.EncryptedDataKey) {
+			candidates = []keyset.Key{k}
+			break
+		}
+	}
+
+	for _, k := range candidates {
+		_, dek, err := kc.keyById(k.ID)
+		if err != nil {
+			continue
+		}
+
+		if plainbytes, ok := openPayload(payload, dek); ok {
+			return plainbytes, k.ID, http.StatusOK, nil
+		}
+	}
+
+	return nil, "", http.StatusInternalServerError, errors.New("failed to open secretbox")
+}
+
+// [2022-05-09] OpenEnvelope decrypts an encoded KmsPayload with key, see SealEnvelope.
+func OpenEnvelope(input string, key []byte) ([]byte, error) {
+	payload, _, err := decodeEnvelope(input)
+	if err != nil {
+		return nil, err
+	}
+
+	plainbytes, ok := openPayload(payload, key)
+	if !ok {
+		return nil, errors.New("failed to open secretbox")
+	}
+
+	return plainbytes, nil
+}
+
+// decodeEnvelope decodes an encoded KmsPayload. It returns the HTTP status to answer with on error.
+func decodeEnvelope(input string) (KmsPayload, int, error) {
+	// Extract payload.
+	// encrypted, err := decode(input[8 : len(input)-1])
+	encrypted, err := decode(input)
+	if err != nil {
+		return KmsPayload{}, http.StatusInternalServerError, errors.New(err.Error())
+	}
+
+	// Decode payload structure.
+	var payload KmsPayload
+	gob.NewDecoder(bytes.NewReader(encrypted)).Decode(&payload)
+	if payload.Nonce == nil {
+		return KmsPayload{}, http.StatusBadRequest, errors.New("failed to decode envelope payload")
+	}
+
+	return payload, http.StatusOK, nil
+}
+
+// openPayload decrypts the message of payload with key.
+func openPayload(payload KmsPayload, key []byte) ([]byte, bool) {
+	var dataKey [32]byte
+	copy(dataKey[:], key)
+
+	// Decrypt message.
+	return secretbox.Open(nil, payload.Message, payload.Nonce, &dataKey)
+}
+
+// Tweak shared by all FPE operations, hex-encoded in the environment.
+func fpeTweak() ([]byte, error) {
+	return hex.DecodeString(os.Getenv("FPE_TWEAK"))
+}
+
 func UnhandledOperation() (events.APIGatewayV2HTTPResponse, error) {
 	return apiResponse(http.StatusMethodNotAllowed, ErrorUnhandledOperation)
 }
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kdf"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/keyset"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/marker"
+)
+
+// [2022-04-14] The unwrapped keys of the keyset loaded by a Service.
+//
+// /encrypt and /envelope-encrypt use the primary key and return its ID; /decrypt and
+// /envelope-decrypt find the key by ID or by verification. All other operations, and the
+// keys derived in keys.go, use the primary key too but return no key ID: tokens,
+// pseudonyms and blind indexes made with them must be made again after a rotation.
+type keyring struct {
+	keyset *keyset.Keyset
+	keys   map[string][]byte
+}
+
+// loadKeyring unwraps every key of ks and verifies it against its check value.
+//
+// [2022-05-02] Keys are unwrapped with the encryption context stored with them, which must be
+// the expected one if that is set. Keys stored without a context predate contexts and are
+// unwrapped without one: they stay in the keyset, as active keys, for the data encrypted with
+// them. If a context is expected, the primary key must carry it, so new data is never encrypted
+// under a key without one; the first rotation after the context is configured binds the new
+// primary key to it, see package rotation.
+//
+// [2022-05-09] A keyset without a primary key is refused: new data would have no key.
+func loadKeyring(ks *keyset.Keyset, keyManager kms.KeyManager, expected kms.EncryptionContext) (*keyring, error) {
+	if ks.Primary().ID == "" {
+		return nil, errors.New("keyset has no primary key")
+	}
+
+	r := &keyring{keyset: ks, keys: map[string][]byte{}}
+
+	for _, k := range ks.Keys {
+		context := kms.EncryptionContext(k.EncryptionContext)
+		if len(expected) > 0 && len(context) > 0 && !context.Equal(expected) {
+			return nil, fmt.Errorf("key %s: encryption context %v does not match the configured %v", k.ID, context, expected)
+		}
+
+		if len(expected) > 0 && len(context) == 0 && k.Status == keyset.StatusPrimary {
+			return nil, fmt.Errorf("primary key %s has no encryption context, rotate the keyset to bind a new one to %v", k.ID, expected)
+		}
+
+		dek, err := keyManager.DecryptDataKey(k.Wrapped(), context)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", k.ID, err)
+		}
+
+		if len(dek) != kms.DataKeyBytes {
+			return nil, fmt.Errorf("key %s: data encryption key must be %d bytes, got %d", k.ID, kms.DataKeyBytes, len(dek))
+		}
+
+		if err := k.Verify(dek); err != nil {
+			return nil, fmt.Errorf("key %s: %v", k.ID, err)
+		}
+
+		r.keys[k.ID] = dek
+	}
+
+	return r, nil
+}
+
+// root returns the primary key, the one all operations without a key ID use.
+func (r *keyring) root() (keyset.Key, []byte) {
+	k := r.keyset.Primary()
+	return k, r.keys[k.ID]
+}
+
+// primaryKey returns the key new data is encrypted with.
+func (kc *keyContext) primaryKey() (keyset.Key, []byte, error) {
+	if kc.ring == nil {
+		return keyset.Key{}, nil, errors.New("data encryption key is not available")
+	}
+
+	k := kc.ring.keyset.Primary()
+	if k.ID == "" {
+		return keyset.Key{}, nil, errors.New("keyset has no primary key")
+	}
+
+	return k, kc.ring.keys[k.ID], nil
+}
+
+// keyById returns a key that may decrypt.
+func (kc *keyContext) keyById(id string) (keyset.Key, []byte, error) {
+	if kc.ring == nil {
+		return keyset.Key{}, nil, errors.New("data encryption key is not available")
+	}
+
+	k, err := kc.ring.keyset.Lookup(id)
+	if err != nil {
+		return keyset.Key{}, nil, err
+	}
+
+	switch k.Status {
+	case keyset.StatusRetired:
+		return keyset.Key{}, nil, keyset.ErrKeyRetired
+	case keyset.StatusPending:
+		// [2022-04-18] Not usable before rotation promotes it.
+		return keyset.Key{}, nil, keyset.ErrUnknownKey
+	}
+
+	return k, kc.ring.keys[k.ID], nil
+}
+
+// usableKeys returns the keys that may decrypt, the primary key first.
+func (kc *keyContext) usableKeys() []keyset.Key {
+	if kc.ring == nil {
+		return nil
+	}
+	return kc.ring.keyset.Usable()
+}
+
+// [2022-04-18] fpeTokenKey finds the key an FF1 token was made with, by keyId or, without one,
+// by the check marker, and strips the marker. It returns the HTTP status to answer with on error.
+func (kc *keyContext) fpeTokenKey(input string, radix int, keyId string, markerSpec *marker.Spec) (keyset.Key, []byte, string, int, error) {
+	candidates := kc.usableKeys()
+	if keyId != "" {
+		k, _, err := kc.keyById(keyId)
+		switch err {
+		case nil:
+			candidates = []keyset.Key{k}
+		case keyset.ErrKeyRetired:
+			return keyset.Key{}, nil, "", http.StatusBadRequest, errors.New(ErrorKeyRetired + ": " + keyId)
+		default:
+			return keyset.Key{}, nil, "", http.StatusBadRequest, errors.New(ErrorUnknownKey + ": " + keyId)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return keyset.Key{}, nil, "", http.StatusServiceUnavailable, errors.New(ErrorKeysUnavailable)
+	}
+
+	verifiable := markerSpec != nil && markerSpec.Scheme == marker.SchemeCheck
+	if len(candidates) > 1 && !verifiable {
+		return keyset.Key{}, nil, "", http.StatusBadRequest, errors.New(ErrorKeyIdRequired)
+	}
+
+	// Check characters verify under a wrong key with probability radix^-CheckLength, so a
+	// token that verifies under more than one key is ambiguous rather than decrypted wrongly.
+	var match keyset.Key
+	var matchKey []byte
+	var ciphertext string
+
+	for _, k := range candidates {
+		_, dek, err := kc.keyById(k.ID)
+		if err != nil {
+			return keyset.Key{}, nil, "", http.StatusInternalServerError, err
+		}
+
+		scheme, err := tokenMarker(markerSpec, radix, dek)
+		if err != nil {
+			return keyset.Key{}, nil, "", http.StatusBadRequest, errors.New(ErrorInvalidMarker)
+		}
+
+		if scheme == nil {
+			return k, dek, input, http.StatusOK, nil
+		}
+
+		// [2022-03-17] Refuse to "decrypt" values that are not tokens.
+		unmarked, ok := scheme.Unmark(input)
+		if !ok {
+			continue
+		}
+		if matchKey != nil {
+			return keyset.Key{}, nil, "", http.StatusBadRequest, errors.New(ErrorKeyIdRequired)
+		}
+		match, matchKey, ciphertext = k, dek, unmarked
+	}
+
+	if matchKey == nil {
+		return keyset.Key{}, nil, "", http.StatusBadRequest, errors.New(ErrorNotAToken)
+	}
+
+	return match, matchKey, ciphertext, http.StatusOK, nil
+}
+
+// deriveKeyFrom derives a 256-bit key for purpose from dek, like deriveKey does from the root key.
+func deriveKeyFrom(dek []byte, purpose string) ([]byte, error) {
+	return kdf.Derive(dek, kdf.Label{Purpose: purpose})
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kdf"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// [2022-02-21] Purposes of keys derived from the FPE data encryption key.
+// Each purpose gets an independent key so that one scheme's outputs never leak into another's.
+const (
+	KeyPurposeOpe        = transform.PurposeOpe
+	KeyPurposePseudonym  = transform.PurposePseudonym
+	KeyPurposeTokenVault = "token-vault"
+	KeyPurposeDateShift  = "date-shift"
+	KeyPurposeBlindIndex = "blind-index"
+	KeyPurposeMarker     = "marker"
+
+	// [2022-03-28] Prefix of the keys referenced by policy data classes, e.g. "policy/customer".
+	KeyPurposePolicy = "policy"
+
+	// [2022-04-21] Prefix of the keys of pseudonymization domains, e.g. "domain/partner-a".
+	KeyPurposeDomain = "domain"
+
+	// [2022-04-25] FF1 under the subkey of a data class.
+	KeyPurposeFpe = "fpe"
+
+	// [2022-05-05] FF1 of the values /scan-and-protect finds.
+	KeyPurposeScan = "scan"
+)
+
+// [2022-05-09] keyContext holds the keys of the service a request runs with, see WithService.
+// Handlers read it once per request, so a request never sees the keys of another tenant, nor
+// a keyset refreshed halfway through.
+type keyContext struct {
+	service *Service
+	ring    *keyring
+
+	// [2022-04-25] Subkeys of the root data encryption key, see package kdf
+	deriver *kdf.Deriver
+
+	// [2022-04-28] Tenant of the keys; empty for a single-tenant deployment
+	tenant string
+}
+
+// keysOf returns the keys of the service of ctx.
+func keysOf(ctx context.Context) *keyContext {
+	s := serviceOf(ctx)
+	if s == nil {
+		return &keyContext{}
+	}
+	return s.keyContext()
+}
+
+// deriveKey derives a 256-bit key for purpose from the KMS-protected data encryption key with HKDF-SHA256.
+func (kc *keyContext) deriveKey(purpose string) ([]byte, error) {
+	return kc.subkey(kdf.Label{Purpose: purpose})
+}
+
+// [2022-04-25] subkey returns the key of label in the hierarchy under the data encryption key.
+func (kc *keyContext) subkey(label kdf.Label) ([]byte, error) {
+	if kc.deriver == nil {
+		return nil, errors.New("data encryption key is not available")
+	}
+
+	return kc.deriver.Key(kc.scoped(label))
+}
+
+// [2022-05-05] subkeyOf returns the key of label under dek, a key of the keyset other than the root one.
+func (kc *keyContext) subkeyOf(dek []byte, label kdf.Label) ([]byte, error) {
+	if kc.deriver == nil {
+		return nil, errors.New("data encryption key is not available")
+	}
+
+	return kc.deriver.KeyOf(dek, kc.scoped(label))
+}
+
+// [2022-04-28] scoped places label under the tenant of the keys.
+// Responses report labels scoped, so they name the key actually derived.
+func (kc *keyContext) scoped(label kdf.Label) kdf.Label {
+	if label.Tenant == "" {
+		label.Tenant = kc.tenant
+	}
+	return label
+}
+
+// [2022-04-25] keyError answers a failure to derive a key: 403 if it was revoked.
+func keyError(err error) (events.APIGatewayV2HTTPResponse, error) {
+	if errors.Is(err, kdf.ErrRevoked) {
+		return HandleError(http.StatusForbidden, errors.New(err.Error()))
+	}
+	return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+}
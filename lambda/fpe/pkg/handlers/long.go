@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/longfpe"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// [2022-02-14] Encrypt long values (notes, free text) block by block with chained tweaks.
+func LongEncrypt(
+	input string,
+	radix int,
+	blockSize int,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return longOperation(keysOf(ctx), "Encrypt", input, radix, blockSize)
+}
+
+func LongDecrypt(
+	input string,
+	radix int,
+	blockSize int,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return longOperation(keysOf(ctx), "Decrypt", input, radix, blockSize)
+}
+
+func longOperation(kc *keyContext, operation string, input string, radix int, blockSize int) (events.APIGatewayV2HTTPResponse, error) {
+	var resp FpeResponse
+
+	t, params, err := registered(transform.TypeLong, transform.LongParams{Radix: radix, BlockSize: blockSize})
+	if err != nil {
+		return HandleError(http.StatusBadRequest, errors.New(err.Error()))
+	}
+
+	keys := serviceKeys{kc: kc}
+	var plaintext, ciphertext string
+	if operation == "Encrypt" {
+		plaintext = input
+		ciphertext, err = applyTransform(operation, t, &keys, params, plaintext)
+	} else {
+		ciphertext = input
+		plaintext, err = applyTransform(operation, t, &keys, params, ciphertext)
+	}
+	if err != nil {
+		return transformError(err)
+	}
+
+	if blockSize <= 0 {
+		blockSize = longfpe.DefaultBlockSize
+	}
+
+	// Set response.
+	resp.Operation = "Long-" + operation
+	resp.Plaintext = plaintext
+	resp.Ciphertext = ciphertext
+	resp.Radix = radix
+	resp.Mode = ModeLong
+	resp.BlockSize = blockSize
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
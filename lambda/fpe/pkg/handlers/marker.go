@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/marker"
+)
+
+// tokenMarker returns the marker scheme for spec, or nil if no marker was requested.
+// The check scheme is keyed with a key derived from dek for markers only, so the check
+// characters also tell which data encryption key a token was made with.
+func tokenMarker(spec *marker.Spec, radix int, dek []byte) (marker.Scheme, error) {
+	if spec == nil {
+		return nil, nil
+	}
+
+	var key []byte
+	if spec.Scheme == marker.SchemeCheck {
+		var err error
+		if key, err = deriveKeyFrom(dek, KeyPurposeMarker); err != nil {
+			return nil, err
+		}
+	}
+
+	return marker.New(*spec, key, radix)
+}
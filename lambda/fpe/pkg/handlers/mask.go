@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/masking"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// [2022-03-03] Irreversible masking and redaction, e.g. ****-****-****-3456.
+func Mask(
+	input string,
+	spec *masking.Spec,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	var resp MaskResponse
+
+	if spec == nil {
+		return HandleError(http.StatusBadRequest, errors.New(ErrorMissingMask))
+	}
+
+	t, params, err := registered(transform.TypeMask, *spec)
+	if err != nil {
+		return HandleError(http.StatusBadRequest, err)
+	}
+
+	masked, err := applyTransform("Encrypt", t, &serviceKeys{kc: keysOf(ctx)}, params, input)
+	if err != nil {
+		return transformError(err)
+	}
+
+	// Set response.
+	resp.Operation = "Mask"
+	resp.Plaintext = input
+	if !spec.Nulls() {
+		resp.Masked = &masked
+	}
+	resp.Mask = *spec
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ope"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// [2022-02-21] Order-preserving encryption of integers and dates, for range queries on encrypted columns.
+func OpeEncrypt(
+	input string,
+	domain string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return opeOperation(keysOf(ctx), "Encrypt", input, domain)
+}
+
+func OpeDecrypt(
+	input string,
+	domain string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return opeOperation(keysOf(ctx), "Decrypt", input, domain)
+}
+
+func opeOperation(kc *keyContext, operation string, input string, domain string) (events.APIGatewayV2HTTPResponse, error) {
+	var resp FpeResponse
+
+	t, params, err := registered(transform.TypeOpe, transform.OpeParams{Domain: domain})
+	if err != nil {
+		return HandleError(http.StatusBadRequest, errors.New(err.Error()))
+	}
+	d, _ := ope.LookupDomain(params.(transform.OpeParams).Domain, ope.DefaultDomainBits)
+
+	keys := serviceKeys{kc: kc}
+	var plaintext, ciphertext string
+	if operation == "Encrypt" {
+		plaintext = input
+		ciphertext, err = applyTransform(operation, t, &keys, params, plaintext)
+	} else {
+		ciphertext = input
+		plaintext, err = applyTransform(operation, t, &keys, params, ciphertext)
+	}
+	if err != nil {
+		return transformError(err)
+	}
+
+	// Set response.
+	resp.Operation = "Ope-" + operation
+	resp.Plaintext = plaintext
+	resp.Ciphertext = ciphertext
+	resp.Radix = -1 // Unused
+	resp.Algorithm = AlgorithmOpe
+	resp.Domain = d.Name()
+	resp.KeyLabel = keys.label
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// [2022-02-07] Encrypt inputs whose domain is given by a regular expression rather than a radix.
+func PatternEncrypt(
+	input string,
+	pattern string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return patternOperation(keysOf(ctx), "Encrypt", input, pattern)
+}
+
+func PatternDecrypt(
+	input string,
+	pattern string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return patternOperation(keysOf(ctx), "Decrypt", input, pattern)
+}
+
+func patternOperation(kc *keyContext, operation string, input string, pattern string) (events.APIGatewayV2HTTPResponse, error) {
+	var resp FpeResponse
+
+	t, params, err := registered(transform.TypePattern, transform.PatternParams{Pattern: pattern})
+	if err != nil {
+		return HandleError(http.StatusBadRequest, errors.New(ErrorInvalidPattern+": "+err.Error()))
+	}
+
+	keys := serviceKeys{kc: kc}
+	var plaintext, ciphertext string
+	if operation == "Encrypt" {
+		plaintext = input
+		ciphertext, err = applyTransform(operation, t, &keys, params, plaintext)
+	} else {
+		ciphertext = input
+		plaintext, err = applyTransform(operation, t, &keys, params, ciphertext)
+	}
+	if err != nil {
+		return transformError(err)
+	}
+
+	// Set response.
+	resp.Operation = "Pattern-" + operation
+	resp.Plaintext = plaintext
+	resp.Ciphertext = ciphertext
+	resp.Radix = -1 // Unused
+	resp.Pattern = pattern
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/profiler"
+)
+
+// [2022-03-24] Classify the columns of a CSV extract (header row first) and suggest a policy for them.
+func Profile(
+	input string,
+	sampleSize int,
+	ctx context.Context, // Reserved.
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	var resp ProfileResponse
+
+	report, err := profiler.ProfileCSV(strings.NewReader(input), profiler.Options{SampleSize: sampleSize})
+	if err != nil {
+		return HandleError(http.StatusBadRequest, errors.New(ErrorInvalidTable+": "+err.Error()))
+	}
+
+	// Set response. Sampled values are not echoed.
+	resp.Operation = "Profile"
+	resp.Report = report
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
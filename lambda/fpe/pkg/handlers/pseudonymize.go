@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/pseudonym"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// [2022-02-24] Deterministic one-way pseudonyms; there is intentionally no reverse operation.
+func Pseudonymize(
+	input string,
+	encoding string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	var resp FpeResponse
+
+	if encoding == "" {
+		encoding = string(pseudonym.EncodingHex)
+	}
+
+	t, params, err := registered(transform.TypeHash, transform.HashParams{Encoding: encoding})
+	if err != nil {
+		return HandleError(http.StatusBadRequest, err)
+	}
+
+	keys := serviceKeys{kc: keysOf(ctx)}
+	token, err := applyTransform("Encrypt", t, &keys, params, input)
+	if err != nil {
+		return transformError(err)
+	}
+
+	// Set response.
+	resp.Operation = "Pseudonymize"
+	resp.Plaintext = input
+	resp.Token = token
+	resp.Radix = -1 // Unused
+	resp.Encoding = encoding
+	resp.KeyLabel = keys.label
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
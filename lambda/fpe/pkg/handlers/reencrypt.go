@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/marker"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// [2022-04-18] Token formats /reencrypt migrates.
+const (
+	TokenTypeFpe      = "fpe"
+	TokenTypeEnvelope = "envelope"
+
+	maxReencryptBatch = 1000
+)
+
+// ReencryptToken is a token to migrate to the primary key. Radix applies to FPE tokens
+// and defaults to the radix of the request; KeyId is optional for envelope payloads,
+// which name their key.
+type ReencryptToken struct {
+	Token string `json:"token"`
+	KeyId string `json:"keyId,omitempty"`
+	Type  string `json:"type"`
+	Radix int    `json:"radix,omitempty"`
+}
+
+// [2022-04-18] Re-encrypt a batch of tokens under the primary key after rotation.
+// Tokens are decrypted inside the service only; neither the response nor the logs carry plaintext.
+// A token that cannot be migrated gets an error of its own and leaves the rest of the batch alone.
+func Reencrypt(
+	tokens []ReencryptToken,
+	radix int,
+	markerSpec *marker.Spec, // Marker of the FPE tokens, applied again to the new tokens.
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	var resp ReencryptResponse
+
+	kc := keysOf(ctx)
+
+	if len(tokens) == 0 || len(tokens) > maxReencryptBatch {
+		return HandleError(http.StatusBadRequest, errors.New(ErrorInvalidBatch+": between 1 and "+strconv.Itoa(maxReencryptBatch)+" tokens"))
+	}
+
+	primary, _, err := kc.primaryKey()
+	if err != nil {
+		return HandleError(http.StatusServiceUnavailable, errors.New(ErrorKeysUnavailable))
+	}
+
+	failed := 0
+	resp.Results = make([]ReencryptResult, len(tokens))
+
+	for i, t := range tokens {
+		result := ReencryptResult{
+			Type:        t.Type,
+			SourceKeyId: t.KeyId,
+		}
+
+		var err error
+		switch t.Type {
+		case TokenTypeFpe:
+			if t.Radix == 0 {
+				t.Radix = radix
+			}
+			result.Token, result.SourceKeyId, err = kc.reencryptFpe(t, markerSpec)
+		case TokenTypeEnvelope:
+			result.Token, result.SourceKeyId, err = kc.reencryptEnvelope(t)
+		default:
+			err = errors.New(ErrorUnknownTokenType + ": " + t.Type)
+		}
+
+		if err != nil {
+			result.Token = ""
+			result.Error = err.Error()
+			failed++
+		}
+		resp.Results[i] = result
+	}
+
+	// Counts only, never tokens or values
+	fmt.Println("{Reencrypt} Re-encrypted", len(tokens)-failed, "of", len(tokens), "tokens under key", primary.ID)
+
+	resp.Operation = "Reencrypt"
+	resp.KeyId = primary.ID
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
+
+// reencryptFpe decrypts like Decrypt and encrypts like Encrypt, with the same tweak in both
+// directions, and returns the new token and the source key ID.
+func (kc *keyContext) reencryptFpe(t ReencryptToken, markerSpec *marker.Spec) (string, string, error) {
+	source, sourceKey, ciphertext, _, err := kc.fpeTokenKey(t.Token, t.Radix, t.KeyId, markerSpec)
+	if err != nil {
+		return "", "", err
+	}
+
+	fpe, params, err := registered(transform.TypeFpe, transform.FpeParams{Radix: t.Radix})
+	if err != nil {
+		return "", source.ID, err
+	}
+
+	plaintext, err := applyTransform("Decrypt", fpe, &serviceKeys{kc: kc, source: source, dek: sourceKey}, params, ciphertext)
+	if err != nil {
+		return "", source.ID, errors.New("failed to decrypt token")
+	}
+
+	primary, key, err := kc.primaryKey()
+	if err != nil {
+		return "", source.ID, err
+	}
+
+	token, err := applyTransform("Encrypt", fpe, &serviceKeys{kc: kc, source: primary, dek: key}, params, plaintext)
+	if err != nil {
+		return "", source.ID, errors.New("failed to encrypt token")
+	}
+
+	scheme, err := tokenMarker(markerSpec, t.Radix, key)
+	if err != nil {
+		return "", source.ID, errors.New(ErrorInvalidMarker)
+	}
+	if scheme != nil {
+		token = scheme.Mark(token)
+	}
+
+	return token, source.ID, nil
+}
+
+// reencryptEnvelope opens the payload with its own key and seals it with the primary key.
+func (kc *keyContext) reencryptEnvelope(t ReencryptToken) (string, string, error) {
+	plainbytes, keyId, _, err := kc.openEnvelope(t.Token)
+	if err != nil {
+		return "", t.KeyId, err
+	}
+
+	if t.KeyId != "" && t.KeyId != keyId {
+		return "", t.KeyId, errors.New("envelope was sealed with key " + keyId + ", not " + t.KeyId)
+	}
+
+	token, _, _, err := kc.sealEnvelope(plainbytes)
+	if err != nil {
+		return "", keyId, err
+	}
+
+	return token, keyId, nil
+}
@@ -4,6 +4,20 @@ import (
 	"encoding/json"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/masking"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/profiler"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/scanner"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// Modes selectable on /encrypt and /decrypt besides the default single-value FF1.
+const (
+	ModeLong = "long"
+)
+
+// Algorithms selectable on /encrypt and /decrypt besides the default FF1.
+const (
+	AlgorithmOpe = "ope"
 )
 
 type FpeResponse struct {
@@ -11,6 +25,105 @@ type FpeResponse struct {
 	Plaintext  string `json:"plaintext"`
 	Ciphertext string `json:"ciphertext"`
 	Radix      int    `json:"radix"`
+	Pattern    string `json:"pattern,omitempty"`
+	Format     string `json:"format,omitempty"`
+	Mode       string `json:"mode,omitempty"`
+	BlockSize  int    `json:"blockSize,omitempty"`
+	Algorithm  string `json:"algorithm,omitempty"`
+	Domain     string `json:"domain,omitempty"`
+	Token      string `json:"token,omitempty"`
+	Encoding   string `json:"encoding,omitempty"`
+	BlindIndex string `json:"blindIndex,omitempty"`
+
+	// [2022-03-17] Set if /encrypt returned an input that already was a token.
+	AlreadyProtected bool `json:"alreadyProtected,omitempty"`
+
+	// [2022-03-28] Data class and policy version applied to a /encrypt or /decrypt with dataClass.
+	DataClass     string `json:"dataClass,omitempty"`
+	PolicyVersion string `json:"policyVersion,omitempty"`
+
+	// [2022-03-31] Registered type applied to a /encrypt or /decrypt with type.
+	Type string `json:"type,omitempty"`
+
+	// [2022-04-14] Key of the keyset a default /encrypt, /decrypt or envelope operation used.
+	KeyId string `json:"keyId,omitempty"`
+
+	// [2022-04-25] Label of the derived key an operation used, see package kdf.
+	KeyLabel string `json:"keyLabel,omitempty"`
+}
+
+// Masked is null if the value was removed by a "null" mask.
+type MaskResponse struct {
+	Operation string       `json:"operation"`
+	Plaintext string       `json:"plaintext"`
+	Masked    *string      `json:"masked"`
+	Mask      masking.Spec `json:"mask"`
+}
+
+// Output is null if the field was removed by a "null" mask.
+type FieldResult struct {
+	Name      string  `json:"name"`
+	Transform string  `json:"transform"`
+	Output    *string `json:"output"`
+}
+
+// Findings are byte offsets into Text, which are the same as in the input.
+type ScanResponse struct {
+	Operation string            `json:"operation"`
+	Text      string            `json:"text"`
+	Findings  []scanner.Finding `json:"findings"`
+}
+
+type ProfileResponse struct {
+	Operation string `json:"operation"`
+	profiler.Report
+}
+
+type TypesResponse struct {
+	Operation string           `json:"operation"`
+	Types     []transform.Info `json:"types"`
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+
+	// [2022-04-28] Readiness of the keys of each tenant, see Tenants.
+	Tenants map[string]string `json:"tenants,omitempty"`
+}
+
+type FieldsResponse struct {
+	Operation string        `json:"operation"`
+	Fields    []FieldResult `json:"fields"`
+}
+
+// Results are in the order of the request; Token is the new token, empty if Error is set.
+type ReencryptResponse struct {
+	Operation string            `json:"operation"`
+	KeyId     string            `json:"keyId"`
+	Results   []ReencryptResult `json:"results"`
+}
+
+type ReencryptResult struct {
+	Token       string `json:"token,omitempty"`
+	Type        string `json:"type"`
+	SourceKeyId string `json:"sourceKeyId,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Input is the token in the From domain, Token the same value in the To domain.
+type TranslateResponse struct {
+	Operation     string `json:"operation"`
+	Input         string `json:"input"`
+	Token         string `json:"token"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	Radix         int    `json:"radix"`
+	KeyId         string `json:"keyId,omitempty"`
+	PolicyVersion string `json:"policyVersion,omitempty"`
+
+	// [2022-04-25] Labels of the derived keys of the From and To domains; empty for the default domain.
+	FromKeyLabel string `json:"fromKeyLabel,omitempty"`
+	ToKeyLabel   string `json:"toKeyLabel,omitempty"`
 }
 
 func apiResponse(status int, body interface{}) (events.APIGatewayV2HTTPResponse, error) {
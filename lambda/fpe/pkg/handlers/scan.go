@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/scanner"
+)
+
+// [2022-03-21] Find card numbers, emails, phone numbers and RRNs in free text and replace them with tokens.
+func ScanAndProtect(
+	input string,
+	detectors []scanner.DetectorSpec,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return scanOperation(keysOf(ctx), "Scan-Protect", input, detectors, nil)
+}
+
+// [2022-03-21] Reverse of ScanAndProtect; the same detectors and the findings it returned must be given.
+func ScanAndRestore(
+	input string,
+	detectors []scanner.DetectorSpec,
+	findings []scanner.Finding, // Tokens are not detected again, a card token may also look like an RRN.
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return scanOperation(keysOf(ctx), "Scan-Restore", input, detectors, findings)
+}
+
+func scanOperation(kc *keyContext, operation string, input string, detectors []scanner.DetectorSpec, findings []scanner.Finding) (events.APIGatewayV2HTTPResponse, error) {
+	var resp ScanResponse
+
+	tweak, err := fpeTweak()
+	if err != nil {
+		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+	}
+
+	// [2022-05-05] Found values are encrypted under a subkey of their own
+	key, err := (&serviceKeys{kc: kc}).Key(KeyPurposeScan)
+	if err != nil {
+		return keyError(err)
+	}
+
+	protector, err := scanner.NewProtector(detectors, key, tweak)
+	if err != nil {
+		return HandleError(http.StatusBadRequest, errors.New(ErrorInvalidDetector+": "+err.Error()))
+	}
+
+	var text string
+	if operation == "Scan-Protect" {
+		text, findings, err = protector.Protect(input)
+	} else {
+		text, err = protector.Restore(input, findings)
+	}
+	if err == scanner.ErrInvalidFindings {
+		return HandleError(http.StatusBadRequest, errors.New(ErrorInvalidFindings))
+	}
+	if err != nil {
+		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+	}
+
+	// Set response. The input is not echoed since it holds the personal data.
+	resp.Operation = operation
+	resp.Text = text
+	resp.Findings = findings
+	if resp.Findings == nil {
+		resp.Findings = []scanner.Finding{}
+	}
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
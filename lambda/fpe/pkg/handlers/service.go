@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kdf"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/keyset"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/policy"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+)
+
+// [2022-04-07] Readiness states of a Service.
+const (
+	StatusStarting    = "starting"
+	StatusReady       = "ready"
+	StatusUnavailable = "unavailable"
+
+	defaultRetryInterval    = 2 * time.Second
+	defaultMaxRetryInterval = time.Minute
+	defaultRefreshInterval  = 5 * time.Minute
+)
+
+// The started service; handlers that need secrets beyond the keys, such as the policy, use its store.
+var (
+	currentMu sync.RWMutex
+	current   *Service
+)
+
+// [2022-05-09] Key of the service of a request in its context, see WithService.
+type serviceContextKey struct{}
+
+// Config names the keys a Service loads.
+type Config struct {
+	// Secret holding the keyset of wrapped data encryption keys, see package keyset
+	SecretName string
+
+	// Master key generating the data encryption key if the secret does not exist yet
+	MasterKeyId string
+
+	// Wait before the first retry after a failed start; doubled up to MaxRetryInterval
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	// [2022-04-18] Age of the loaded keyset after which Ready reads it again, so warm
+	// instances pick up rotated keys; 5 minutes by default
+	RefreshInterval time.Duration
+
+	// [2022-04-25] Labels of derived keys that must no longer be used, e.g. "fpe/class/customer.phone/fpe"
+	RevokedKeyLabels []string
+
+	// [2022-04-28] Tenant the keys belong to, see Tenants; empty for a single-tenant deployment
+	Tenant string
+
+	// [2022-04-28] Secret holding the tenant's pseudonymization policy; see keyContext.policy otherwise
+	PolicySecretName string
+
+	// [2022-05-02] KMS encryption context of the keys; "{secretName}" and "{tenant}" in values stand for
+	// SecretName and Tenant, and pairs left empty by them are omitted
+	EncryptionContext kms.EncryptionContext
+}
+
+// ConfigFromEnv reads FPE_DEK_SECRET_NAME, FPE_MASTER_KEY_ARN, the comma-separated REVOKED_KEY_LABELS
+// and POLICY_SECRET_NAME, the latter only used to name the policy secrets of tenants, the
+// encryption context in FPE_ENCRYPTION_CONTEXT, e.g. "service=fpe,secretName={secretName}",
+// and the keyset refresh interval in FPE_KEYSET_REFRESH_INTERVAL, e.g. "5m".
+func ConfigFromEnv() (Config, error) {
+	context, err := kms.ParseEncryptionContext(os.Getenv("FPE_ENCRYPTION_CONTEXT"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	var refresh time.Duration
+	if value := os.Getenv("FPE_KEYSET_REFRESH_INTERVAL"); value != "" {
+		if refresh, err = time.ParseDuration(value); err != nil {
+			return Config{}, err
+		}
+	}
+
+	return Config{
+		SecretName:        os.Getenv("FPE_DEK_SECRET_NAME"),
+		RefreshInterval:   refresh,
+		MasterKeyId:       os.Getenv("FPE_MASTER_KEY_ARN"),
+		RevokedKeyLabels:  strings.FieldsFunc(os.Getenv("REVOKED_KEY_LABELS"), func(r rune) bool { return r == ',' || r == ' ' }),
+		PolicySecretName:  os.Getenv("POLICY_SECRET_NAME"),
+		EncryptionContext: context,
+	}, nil
+}
+
+// Service loads the data encryption key through an injected key manager and secret store.
+// A failed start is retried lazily by Ready, with exponential backoff, so a transient KMS or
+// Secrets Manager outage at cold start does not leave the instance broken for its lifetime.
+// Once loaded, the keyset is read again every RefreshInterval to pick up rotations.
+type Service struct {
+	keys    kms.KeyManager
+	secrets secretsmanager.SecretStore
+	config  Config
+
+	mu          sync.Mutex
+	status      string
+	err         error
+	nextAttempt time.Time
+	backoff     time.Duration
+	nextRefresh time.Time
+
+	// Keys loaded by the last successful attempt
+	ring    *keyring
+	deriver *kdf.Deriver
+
+	policy policyCache
+}
+
+// NewService returns a service that is not started yet.
+func NewService(keys kms.KeyManager, secrets secretsmanager.SecretStore, config Config) *Service {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaultRetryInterval
+	}
+	if config.MaxRetryInterval < config.RetryInterval {
+		config.MaxRetryInterval = defaultMaxRetryInterval
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaultRefreshInterval
+	}
+
+	return &Service{
+		keys:    keys,
+		secrets: secrets,
+		config:  config,
+		status:  StatusStarting,
+		backoff: config.RetryInterval,
+		policy:  policyCache{retryInterval: config.RetryInterval, maxRetryInterval: config.MaxRetryInterval},
+	}
+}
+
+// Start loads the keys and makes the service the one handlers use.
+// On error the service stays unavailable until a later Ready succeeds.
+func (s *Service) Start(ctx context.Context) error {
+	currentMu.Lock()
+	current = s
+	currentMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.attempt(ctx)
+}
+
+// Ready returns nil if the keys are loaded, reading the keyset again if it is older than
+// RefreshInterval. Otherwise it retries loading them once the backoff has passed, and
+// returns the last error.
+func (s *Service) Ready(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status == StatusReady {
+		if !time.Now().Before(s.nextRefresh) {
+			s.refresh(ctx)
+		}
+		return nil
+	}
+
+	if time.Now().Before(s.nextAttempt) {
+		return s.err
+	}
+
+	return s.attempt(ctx)
+}
+
+// Status returns the readiness state and the error of the last failed attempt.
+func (s *Service) Status() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status, s.err
+}
+
+// attempt must be called with s.mu held
+func (s *Service) attempt(ctx context.Context) error {
+	if s.status == StatusReady {
+		return nil
+	}
+
+	r, err := s.bootstrap(ctx)
+	if err != nil {
+		fmt.Println("{Service} Unable to load the FPE data encryption key:", err.Error())
+
+		s.status = StatusUnavailable
+		s.err = err
+		s.nextAttempt = time.Now().Add(s.backoff)
+		if s.backoff *= 2; s.backoff > s.config.MaxRetryInterval {
+			s.backoff = s.config.MaxRetryInterval
+		}
+
+		return err
+	}
+
+	s.load(r)
+
+	s.status = StatusReady
+	s.err = nil
+	s.backoff = s.config.RetryInterval
+
+	return nil
+}
+
+// refresh reads the keyset again; must be called with s.mu held. The loaded keys stay in
+// use if that fails, and a missing secret is never created again.
+func (s *Service) refresh(ctx context.Context) {
+	value, err := s.secrets.GetSecret(s.config.SecretName)
+
+	var r *keyring
+	if err == nil {
+		r, err = s.unwrap(value)
+	}
+	if err != nil {
+		fmt.Println("{Service} Unable to refresh the FPE data encryption keyset:", err.Error())
+		s.nextRefresh = time.Now().Add(s.config.RetryInterval)
+		return
+	}
+
+	s.load(r)
+}
+
+// load makes r the keys of s; must be called with s.mu held.
+func (s *Service) load(r *keyring) {
+	_, dek := r.root()
+	s.ring = r
+	s.deriver = kdf.NewDeriver(dek, s.config.RevokedKeyLabels...)
+	s.nextRefresh = time.Now().Add(s.config.RefreshInterval)
+}
+
+// [2022-05-09] WithService returns ctx for a request handled with the keys of s. Requests of
+// different tenants run concurrently, each with the keys of its own tenant's service.
+func WithService(ctx context.Context, s *Service) context.Context {
+	return context.WithValue(ctx, serviceContextKey{}, s)
+}
+
+// serviceOf returns the service of ctx or, without one, the started service.
+func serviceOf(ctx context.Context) *Service {
+	if s, ok := ctx.Value(serviceContextKey{}).(*Service); ok {
+		return s
+	}
+
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+
+	return current
+}
+
+// keyContext returns the keys currently loaded by s.
+func (s *Service) keyContext() *keyContext {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &keyContext{service: s, ring: s.ring, deriver: s.deriver, tenant: s.config.Tenant}
+}
+
+// [2022-04-28] tenantPolicy lazily loads the pseudonymization policy of the tenant of s from its secret.
+func (s *Service) tenantPolicy() (*policy.Policy, error) {
+	return s.policy.get(func() (*policy.Policy, error) {
+		if s.config.PolicySecretName == "" {
+			return nil, errors.New("policy secret of tenant " + s.config.Tenant + " is not configured")
+		}
+
+		value, err := s.secrets.GetSecret(s.config.PolicySecretName)
+		if err != nil {
+			return nil, errors.New("policy secret " + s.config.PolicySecretName + ": " + err.Error())
+		}
+
+		return policy.Parse([]byte(value))
+	})
+}
+
+// bootstrap reads the keyset from the secret store, creating it if it does not exist, and unwraps its keys.
+//
+// Concurrent cold starts may all find the secret missing and generate a key each; only one
+// CreateSecret succeeds. Every instance therefore serves the keys read back from the store,
+// never the one it generated, and checks them against the stored check values.
+//
+// A secret deployed as keyset.Uninitialized is left to rotation, which generates its first key;
+// until rotation has promoted it, bootstrap fails and the start is retried.
+func (s *Service) bootstrap(ctx context.Context) (*keyring, error) {
+	if s.keys == nil || s.secrets == nil {
+		return nil, errors.New("key manager and secret store must be configured")
+	}
+
+	if s.config.SecretName == "" {
+		return nil, errors.New("data encryption key secret name is not configured")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	value, err := s.secrets.GetSecret(s.config.SecretName)
+	if err == secretsmanager.ErrSecretNotFound {
+		// Secret value for FPE data encryption key does not exist, create a new one
+		if err := s.createKey(); err != nil {
+			return nil, err
+		}
+		value, err = s.readCreatedSecret(ctx)
+	}
+	if err != nil {
+		// Never generate a new key just because the existing one could not be read
+		return nil, err
+	}
+
+	return s.unwrap(value)
+}
+
+// unwrap parses the keyset in value and unwraps its keys.
+func (s *Service) unwrap(value string) (*keyring, error) {
+	ks, err := keyset.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadKeyring(ks, s.keys, s.encryptionContext())
+}
+
+// [2022-05-02] encryptionContext returns the configured encryption context with the placeholders replaced.
+func (s *Service) encryptionContext() kms.EncryptionContext {
+	return ExpandEncryptionContext(s.config.EncryptionContext, s.config.SecretName, s.config.Tenant)
+}
+
+// [2022-05-05] ExpandEncryptionContext replaces the {secretName} and {tenant} placeholders of a
+// configured encryption context and drops the pairs left empty. The rotation function binds the
+// keys it generates with it, so they get the context the service expects.
+func ExpandEncryptionContext(configured kms.EncryptionContext, secretName string, tenant string) kms.EncryptionContext {
+	if len(configured) == 0 {
+		return nil
+	}
+
+	replacer := strings.NewReplacer("{secretName}", secretName, "{tenant}", tenant)
+
+	context := kms.EncryptionContext{}
+	for k, v := range configured {
+		if v = replacer.Replace(v); v != "" {
+			context[k] = v
+		}
+	}
+
+	return context
+}
+
+// createKey generates a key and stores it, unless another instance has stored one meanwhile.
+func (s *Service) createKey() error {
+	context := s.encryptionContext()
+
+	envelope, err := s.keys.GenerateDataKey(s.config.MasterKeyId, context)
+	if err != nil {
+		return err
+	}
+
+	dek, err := s.keys.DecryptDataKey(envelope, context)
+	if err != nil {
+		return err
+	}
+
+	ks, err := keyset.New(envelope, dek, context)
+	if err != nil {
+		return err
+	}
+
+	value, err := ks.Encode()
+	if err != nil {
+		return err
+	}
+
+	err = s.secrets.CreateSecret(
+		s.config.SecretName,
+		value,
+		"FPE data enryption keyset protected by KMS CMK.",
+	)
+	if err == secretsmanager.ErrSecretExists {
+		fmt.Println("{Service} Data encryption key was created concurrently, using the stored one.")
+		return nil
+	}
+
+	return err
+}
+
+// readCreatedSecret reads the secret just created here or by another instance. The
+// store may briefly not return a secret created by someone else, so reads are retried.
+func (s *Service) readCreatedSecret(ctx context.Context) (string, error) {
+	wait := 50 * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		value, err := s.secrets.GetSecret(s.config.SecretName)
+		if err != secretsmanager.ErrSecretNotFound || attempt == 3 {
+			return value, err
+		}
+
+		select {
+		case <-time.After(wait):
+			wait *= 2
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// currentSecrets returns the secret store of the started service.
+func currentSecrets() (secretsmanager.SecretStore, error) {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+
+	if current == nil || current.secrets == nil {
+		return nil, errors.New("secret store is not available")
+	}
+
+	return current.secrets, nil
+}
+
+// [2022-04-07] Readiness of the service; 503 until the keys are loaded.
+func (s *Service) Health(
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	var resp HealthResponse
+
+	err := s.Ready(ctx)
+	resp.Status, _ = s.Status()
+
+	if err != nil {
+		return apiResponse(
+			http.StatusServiceUnavailable,
+			&resp,
+		)
+	}
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
+
+// Unavailable is the response to requests arriving while the keys are not loaded.
+func Unavailable() (events.APIGatewayV2HTTPResponse, error) {
+	return HandleError(http.StatusServiceUnavailable, errors.New(ErrorKeysUnavailable))
+}
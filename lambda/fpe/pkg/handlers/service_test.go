@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/keyset"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/marker"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+)
+
+// flakyStore fails the first reads, like Secrets Manager during a transient outage
+type flakyStore struct {
+	*secretsmanager.MemoryStore
+	failures int
+}
+
+func (f *flakyStore) GetSecret(name string) (string, error) {
+	if f.failures > 0 {
+		f.failures--
+		return "", errors.New("throttled")
+	}
+	return f.MemoryStore.GetSecret(name)
+}
+
+func TestServiceRetriesAfterFailure(t *testing.T) {
+	store := &flakyStore{MemoryStore: secretsmanager.NewMemoryStore(), failures: 1}
+	s := NewService(kms.NewMemoryKeyManager(), store, Config{
+		SecretName:    "/secret/fpe/dek",
+		RetryInterval: time.Millisecond,
+	})
+
+	if err := s.Start(context.Background()); err == nil {
+		t.Fatalf("Expected the first start to fail")
+	}
+
+	if status, _ := s.Status(); status != StatusUnavailable {
+		t.Fatalf("Expected status %s, got %s", StatusUnavailable, status)
+	}
+
+	resp, _ := Encrypt("0123456789", 10, nil, context.Background(), events.APIGatewayV2HTTPRequest{})
+	if resp.StatusCode == http.StatusOK {
+		t.Fatalf("Encrypt must not succeed without keys")
+	}
+
+	time.Sleep(2 * time.Millisecond)
+
+	if err := s.Ready(context.Background()); err != nil {
+		t.Fatalf("Ready after the outage: %v", err)
+	}
+
+	if _, dek, err := s.keyContext().primaryKey(); err != nil || len(dek) != kms.DataKeyBytes {
+		t.Fatalf("Data encryption key was not loaded")
+	}
+
+	// A second instance reads the key created by the first one
+	other := NewService(kms.NewMemoryKeyManager(), store, Config{SecretName: "/secret/fpe/dek"})
+	if err := other.Start(context.Background()); err == nil {
+		t.Fatalf("Expected a key manager with another master key to fail")
+	}
+}
+
+// racyStore hides the secret from the first reads, as if all instances started at once
+type racyStore struct {
+	*secretsmanager.MemoryStore
+	mu     sync.Mutex
+	hidden int
+}
+
+func (r *racyStore) GetSecret(name string) (string, error) {
+	r.mu.Lock()
+	if r.hidden > 0 {
+		r.hidden--
+		r.mu.Unlock()
+		return "", secretsmanager.ErrSecretNotFound
+	}
+	r.mu.Unlock()
+
+	return r.MemoryStore.GetSecret(name)
+}
+
+func TestConcurrentBootstrapConverges(t *testing.T) {
+	const instances = 8
+
+	keys := kms.NewMemoryKeyManager()
+	store := &racyStore{MemoryStore: secretsmanager.NewMemoryStore(), hidden: instances}
+
+	rings := make([]*keyring, instances)
+	errs := make([]error, instances)
+
+	var wg sync.WaitGroup
+	for i := 0; i < instances; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := NewService(keys, store, Config{SecretName: "/secret/fpe/dek"})
+			rings[i], errs[i] = s.bootstrap(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range rings {
+		if errs[i] != nil {
+			t.Fatalf("Instance %d: %v", i, errs[i])
+		}
+		_, dek := rings[i].root()
+		if _, first := rings[0].root(); !bytes.Equal(dek, first) {
+			t.Fatalf("Instance %d serves a divergent data encryption key", i)
+		}
+	}
+}
+
+func TestBootstrapVerifiesCheckValue(t *testing.T) {
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+
+	envelope, _ := keys.GenerateDataKey("", nil)
+	other, _ := keys.GenerateDataKey("", nil)
+	otherKey, _ := keys.DecryptDataKey(other, nil)
+
+	// Wrapped key and check value of different keys
+	ks, _ := keyset.New(envelope, otherKey, nil)
+	value, _ := ks.Encode()
+	store.CreateSecret("/secret/fpe/dek", value, "")
+
+	s := NewService(keys, store, Config{SecretName: "/secret/fpe/dek"})
+	if _, err := s.bootstrap(context.Background()); err == nil {
+		t.Fatalf("Expected a check value mismatch")
+	}
+
+	// Secrets holding only the hex-encoded wrapped key are still accepted
+	legacy := secretsmanager.NewMemoryStore()
+	legacy.CreateSecret("/secret/fpe/dek", hex.EncodeToString(envelope), "")
+
+	s = NewService(keys, legacy, Config{SecretName: "/secret/fpe/dek"})
+	if _, err := s.bootstrap(context.Background()); err != nil {
+		t.Fatalf("Legacy secret: %v", err)
+	}
+}
+
+func TestBootstrapEncryptionContext(t *testing.T) {
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+	config := Config{
+		SecretName:        "/secret/fpe/dek",
+		EncryptionContext: kms.EncryptionContext{"service": "fpe", "secretName": "{secretName}", "tenant": "{tenant}"},
+	}
+
+	if _, err := NewService(keys, store, config).bootstrap(context.Background()); err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+
+	// The context is stored with the key, without the pairs left empty
+	value, _ := store.GetSecret("/secret/fpe/dek")
+	ks, _ := keyset.Parse(value)
+	expected := kms.EncryptionContext{"service": "fpe", "secretName": "/secret/fpe/dek"}
+	if !expected.Equal(ks.Primary().EncryptionContext) {
+		t.Fatalf("Unexpected encryption context %v", ks.Primary().EncryptionContext)
+	}
+
+	// The key is unwrapped with the stored context only if it is the configured one
+	config.EncryptionContext = kms.EncryptionContext{"service": "other"}
+	if _, err := NewService(keys, store, config).bootstrap(context.Background()); err == nil {
+		t.Fatalf("Expected an encryption context mismatch")
+	}
+
+	config.EncryptionContext = nil
+	if _, err := NewService(keys, store, config).bootstrap(context.Background()); err != nil {
+		t.Fatalf("bootstrap with the stored context: %v", err)
+	}
+
+	// Removing the stored context does not unwrap the key
+	ks.Keys[0].EncryptionContext = nil
+	value, _ = ks.Encode()
+	stripped := secretsmanager.NewMemoryStore()
+	stripped.CreateSecret("/secret/fpe/dek", value, "")
+	if _, err := NewService(keys, stripped, config).bootstrap(context.Background()); err == nil {
+		t.Fatalf("Expected a key wrapped with a context to need it")
+	}
+
+	// With a context configured, keys without one are loaded as active keys only
+	config.EncryptionContext = expected
+	wrapped, _ := keys.GenerateDataKey("", nil)
+	dek, _ := keys.DecryptDataKey(wrapped, nil)
+	legacy, _ := keyset.New(wrapped, dek, nil)
+	value, _ = legacy.Encode()
+	unbound := secretsmanager.NewMemoryStore()
+	unbound.CreateSecret("/secret/fpe/dek", value, "")
+	if _, err := NewService(keys, unbound, config).bootstrap(context.Background()); err == nil {
+		t.Fatalf("Expected a primary key without the configured context to be refused")
+	}
+
+	wrapped, _ = keys.GenerateDataKey("", expected)
+	dek, _ = keys.DecryptDataKey(wrapped, expected)
+	legacy.Add(wrapped, dek, expected, keyset.StatusPrimary)
+	value, _ = legacy.Encode()
+	rotated := secretsmanager.NewMemoryStore()
+	rotated.CreateSecret("/secret/fpe/dek", value, "")
+	if _, err := NewService(keys, rotated, config).bootstrap(context.Background()); err != nil {
+		t.Fatalf("bootstrap after rotation: %v", err)
+	}
+}
+
+func decodeFpeResponse(t *testing.T, resp events.APIGatewayV2HTTPResponse) FpeResponse {
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, resp.StatusCode, resp.Body)
+	}
+
+	var out FpeResponse
+	if err := json.Unmarshal([]byte(resp.Body), &out); err != nil {
+		t.Fatalf("Unable to decode response: %v", err)
+	}
+	return out
+}
+
+func TestKeysetWithoutPrimary(t *testing.T) {
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+
+	// The first keyset rotation writes holds only a pending key
+	wrapped, _ := keys.GenerateDataKey("", nil)
+	dek, _ := keys.DecryptDataKey(wrapped, nil)
+	ks := &keyset.Keyset{}
+	if _, err := ks.Add(wrapped, dek, nil, keyset.StatusPending); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	value, _ := ks.Encode()
+	store.CreateSecret("/secret/fpe/dek", value, "")
+
+	s := NewService(keys, store, Config{SecretName: "/secret/fpe/dek"})
+	if _, err := s.bootstrap(context.Background()); err == nil {
+		t.Fatalf("Expected a keyset without a primary key to be refused")
+	}
+
+	kc := &keyContext{ring: &keyring{keyset: ks, keys: map[string][]byte{ks.Keys[0].ID: dek}}}
+	if _, key, err := kc.primaryKey(); err == nil {
+		t.Fatalf("Expected no primary key, got %x", key)
+	}
+}
+
+func TestRootIsPrimary(t *testing.T) {
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+
+	s := NewService(keys, store, Config{SecretName: "/secret/fpe/dek"})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	// The first key is retired by a later rotation
+	value, _ := store.GetSecret("/secret/fpe/dek")
+	ks, _ := keyset.Parse(value)
+	wrapped, _ := keys.GenerateDataKey("", nil)
+	dek, _ := keys.DecryptDataKey(wrapped, nil)
+	primary, err := ks.Add(wrapped, dek, nil, keyset.StatusPrimary)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	ks.Keys[0].Status = keyset.StatusRetired
+
+	r, err := loadKeyring(ks, keys, nil)
+	if err != nil {
+		t.Fatalf("loadKeyring: %v", err)
+	}
+	if root, key := r.root(); root.ID != primary.ID || !bytes.Equal(key, dek) {
+		t.Fatalf("Expected the primary key %s as root, got %s", primary.ID, root.ID)
+	}
+}
+
+func TestDecryptAfterRotation(t *testing.T) {
+	t.Setenv("FPE_TWEAK", "D8E7920AFA330A73")
+
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+	ctx := context.Background()
+	req := events.APIGatewayV2HTTPRequest{}
+	check := &marker.Spec{Scheme: marker.SchemeCheck, CheckLength: 6}
+
+	s := NewService(keys, store, Config{SecretName: "/secret/fpe/dek"})
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	old := decodeFpeResponse(t, mustResponse(Encrypt("0123456789", 10, check, ctx, req)))
+
+	rotateTestKeys(t, keys, store)
+
+	fresh := decodeFpeResponse(t, mustResponse(Encrypt("0123456789", 10, check, ctx, req)))
+	if fresh.KeyId == old.KeyId {
+		t.Fatalf("Encrypt must use the new primary key")
+	}
+
+	// The check marker tells the keys apart
+	for _, enc := range []FpeResponse{old, fresh} {
+		dec := decodeFpeResponse(t, mustResponse(Decrypt(enc.Ciphertext, 10, "", check, ctx, req)))
+		if dec.KeyId != enc.KeyId {
+			t.Fatalf("Decrypt used key %s, expected %s", dec.KeyId, enc.KeyId)
+		}
+	}
+
+	// Without a marker the key ID is required
+	resp, _ := Decrypt("0123456789", 10, "", nil, ctx, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status %d without keyId, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// Envelope payloads name their key
+	env := decodeFpeResponse(t, mustResponse(EnvelopeEncrypt("secret", nil, ctx, req)))
+	dec := decodeFpeResponse(t, mustResponse(EnvelopeDecrypt(env.Ciphertext, ctx, req)))
+	if dec.Plaintext != "secret" || dec.KeyId != env.KeyId {
+		t.Fatalf("Envelope round trip: %+v", dec)
+	}
+}
+
+func TestReadyRefreshesKeyset(t *testing.T) {
+	t.Setenv("FPE_TWEAK", "D8E7920AFA330A73")
+
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+	ctx := context.Background()
+	req := events.APIGatewayV2HTTPRequest{}
+
+	s := NewService(keys, store, Config{SecretName: "/secret/fpe/dek", RefreshInterval: time.Millisecond})
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	old := decodeFpeResponse(t, mustResponse(Encrypt("0123456789", 10, nil, ctx, req)))
+
+	// Rotated in place, as the rotation function does
+	value, _ := store.GetSecret("/secret/fpe/dek")
+	ks, _ := keyset.Parse(value)
+	wrapped, _ := keys.GenerateDataKey("", nil)
+	dek, _ := keys.DecryptDataKey(wrapped, nil)
+	if _, err := ks.Add(wrapped, dek, nil, keyset.StatusPrimary); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	value, _ = ks.Encode()
+	if err := store.PutSecretVersion("/secret/fpe/dek", "v2", value, []string{secretsmanager.StageCurrent}); err != nil {
+		t.Fatalf("PutSecretVersion: %v", err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if err := s.Ready(ctx); err != nil {
+		t.Fatalf("Ready: %v", err)
+	}
+
+	fresh := decodeFpeResponse(t, mustResponse(Encrypt("0123456789", 10, nil, ctx, req)))
+	if fresh.KeyId == old.KeyId {
+		t.Fatalf("Encrypt must use the rotated primary key after a refresh")
+	}
+
+	// A keyset that cannot be read leaves the loaded keys in use
+	store.PutSecretVersion("/secret/fpe/dek", "v3", "garbage", []string{secretsmanager.StageCurrent})
+	time.Sleep(2 * time.Millisecond)
+	if err := s.Ready(ctx); err != nil {
+		t.Fatalf("Ready after a failed refresh: %v", err)
+	}
+	if again := decodeFpeResponse(t, mustResponse(Encrypt("0123456789", 10, nil, ctx, req))); again.KeyId != fresh.KeyId {
+		t.Fatalf("Expected key %s after a failed refresh, got %s", fresh.KeyId, again.KeyId)
+	}
+}
+
+// rotateTestKeys adds a new primary key, keeping the old one active, and restarts the service
+func rotateTestKeys(t *testing.T, keys kms.KeyManager, store *secretsmanager.MemoryStore) {
+	value, _ := store.GetSecret("/secret/fpe/dek")
+	ks, _ := keyset.Parse(value)
+	wrapped, _ := keys.GenerateDataKey("", nil)
+	dek, _ := keys.DecryptDataKey(wrapped, nil)
+	if _, err := ks.Add(wrapped, dek, nil, keyset.StatusPrimary); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	value, _ = ks.Encode()
+	rotated := secretsmanager.NewMemoryStore()
+	rotated.CreateSecret("/secret/fpe/dek", value, "")
+
+	s := NewService(keys, rotated, Config{SecretName: "/secret/fpe/dek"})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start after rotation: %v", err)
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	// Not the former fixed tweak of /decrypt: every direction uses FPE_TWEAK
+	t.Setenv("FPE_TWEAK", "0011223344556677")
+
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+	ctx := context.Background()
+	req := events.APIGatewayV2HTTPRequest{}
+
+	if err := NewService(keys, store, Config{SecretName: "/secret/fpe/dek"}).Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	const plaintext = "4111111111111111"
+	fpe := decodeFpeResponse(t, mustResponse(Encrypt(plaintext, 10, nil, ctx, req)))
+	env := decodeFpeResponse(t, mustResponse(EnvelopeEncrypt(plaintext, nil, ctx, req)))
+
+	rotateTestKeys(t, keys, store)
+
+	resp := mustResponse(Reencrypt([]ReencryptToken{
+		{Token: fpe.Ciphertext, KeyId: fpe.KeyId, Type: TokenTypeFpe},
+		{Token: env.Ciphertext, Type: TokenTypeEnvelope},
+		{Token: fpe.Ciphertext, KeyId: "nope", Type: TokenTypeFpe},
+	}, 10, nil, ctx, req))
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, resp.StatusCode, resp.Body)
+	}
+
+	if bytes.Contains([]byte(resp.Body), []byte(plaintext)) {
+		t.Fatalf("Response must not contain plaintext: %s", resp.Body)
+	}
+
+	var out ReencryptResponse
+	if err := json.Unmarshal([]byte(resp.Body), &out); err != nil {
+		t.Fatalf("Unable to decode response: %v", err)
+	}
+
+	if out.KeyId == fpe.KeyId || len(out.Results) != 3 {
+		t.Fatalf("Unexpected response: %+v", out)
+	}
+
+	for _, r := range out.Results[:2] {
+		if r.Error != "" || r.SourceKeyId != fpe.KeyId {
+			t.Fatalf("Unexpected result: %+v", r)
+		}
+	}
+
+	if out.Results[2].Error == "" || out.Results[2].Token != "" {
+		t.Fatalf("Expected an error for an unknown key: %+v", out.Results[2])
+	}
+
+	dec := decodeFpeResponse(t, mustResponse(Decrypt(out.Results[0].Token, 10, out.KeyId, nil, ctx, req)))
+	if dec.Plaintext != plaintext {
+		t.Fatalf("Re-encrypted FPE token decrypts to %q", dec.Plaintext)
+	}
+
+	dec = decodeFpeResponse(t, mustResponse(EnvelopeDecrypt(out.Results[1].Token, ctx, req)))
+	if dec.Plaintext != plaintext || dec.KeyId != out.KeyId {
+		t.Fatalf("Re-encrypted envelope: %+v", dec)
+	}
+}
+
+func mustResponse(resp events.APIGatewayV2HTTPResponse, err error) events.APIGatewayV2HTTPResponse {
+	if err != nil {
+		panic(err)
+	}
+	return resp
+}
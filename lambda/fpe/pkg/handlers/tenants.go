@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/keyset"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/tenant"
+)
+
+// [2022-04-28] JWT claim naming the tenant of the caller, unless TENANT_CLAIM names another one.
+// The claim must be one callers cannot set themselves: the stack defines the "tenant" attribute
+// of the user pool and leaves it out of the write attributes of the client users sign up with,
+// so only administrators assign it. A claim named by TENANT_CLAIM needs the same care.
+const defaultTenantClaim = "custom:tenant"
+
+// [2022-04-28] Tenants serves several tenants from one deployment.
+//
+// Each tenant has a Service of its own, loading the tenant's keyset from a secret named after
+// the tenant, and its own policy, quotas and audit stream. A request runs between Begin and
+// End with the context of its Session, which carries only its tenant's keys, and keys of one
+// tenant are never shared with another, so tokens of one tenant cannot be decrypted in a
+// request of another. Requests of different tenants run concurrently.
+type Tenants struct {
+	keys     kms.KeyManager
+	secrets  secretsmanager.SecretStore
+	config   Config
+	claim    string
+	registry *tenant.Registry
+
+	mu       sync.Mutex
+	services map[string]*Service
+	limiters map[string]*tenant.Limiter
+	owners   map[string]string // Check value of a loaded key -> tenant
+}
+
+// NewTenants returns the tenants of registry. Their secret names are derived from those in config,
+// see tenant.SecretName; claim is the JWT claim holding the tenant ID.
+func NewTenants(keys kms.KeyManager, secrets secretsmanager.SecretStore, config Config, claim string, registry *tenant.Registry) *Tenants {
+	limiters := map[string]*tenant.Limiter{}
+	for _, id := range registry.IDs() {
+		limiters[id] = tenant.NewLimiter(registry.Tenants[id].Quota.RequestsPerMinute)
+	}
+
+	return &Tenants{
+		keys:     keys,
+		secrets:  secrets,
+		config:   config,
+		claim:    claim,
+		registry: registry,
+		services: map[string]*Service{},
+		limiters: limiters,
+		owners:   map[string]string{},
+	}
+}
+
+// TenantsFromEnv returns the tenants listed in TENANTS_FILE, identified by the claim in TENANT_CLAIM,
+// or nil for a single-tenant deployment if TENANTS_FILE is not set.
+func TenantsFromEnv(keys kms.KeyManager, secrets secretsmanager.SecretStore, config Config) (*Tenants, error) {
+	path := os.Getenv("TENANTS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	registry, err := tenant.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	claim := os.Getenv("TENANT_CLAIM")
+	if claim == "" {
+		claim = defaultTenantClaim
+	}
+
+	return NewTenants(keys, secrets, config, claim, registry), nil
+}
+
+// Session is a request of a tenant, from Begin to End.
+type Session struct {
+	Tenant  string
+	service *Service
+	stream  string
+	req     events.APIGatewayV2HTTPRequest
+}
+
+// Begin identifies the tenant of req, enforces its quotas and loads its keys.
+// On error the status is the one to answer with.
+func (t *Tenants) Begin(ctx context.Context, req events.APIGatewayV2HTTPRequest) (*Session, int, error) {
+	id := t.tenantOf(req)
+	cfg, err := t.registry.Lookup(id)
+	if err != nil {
+		return nil, http.StatusForbidden, errors.New(ErrorUnknownTenant)
+	}
+
+	if max := cfg.Quota.MaxBodyBytes; max > 0 && len(req.Body) > max {
+		audit(id, cfg.Stream(id), req, http.StatusRequestEntityTooLarge)
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("%s: request body exceeds %d bytes", ErrorQuotaExceeded, max)
+	}
+
+	if !t.limiters[id].Allow(time.Now()) {
+		audit(id, cfg.Stream(id), req, http.StatusTooManyRequests)
+		return nil, http.StatusTooManyRequests, fmt.Errorf("%s: more than %d requests per minute", ErrorQuotaExceeded, cfg.Quota.RequestsPerMinute)
+	}
+
+	s := t.service(id, cfg)
+
+	if err := s.Ready(ctx); err != nil {
+		return nil, http.StatusServiceUnavailable, errors.New(ErrorKeysUnavailable)
+	}
+
+	if err := t.own(id, s); err != nil {
+		fmt.Println("{Tenants}", err.Error())
+		return nil, http.StatusInternalServerError, errors.New(ErrorKeysUnavailable)
+	}
+
+	return &Session{Tenant: id, service: s, stream: cfg.Stream(id), req: req}, 0, nil
+}
+
+// Context returns ctx carrying the keys of the tenant, for the handlers of the request.
+func (s *Session) Context(ctx context.Context) context.Context {
+	return WithService(ctx, s.service)
+}
+
+// End writes the audit record of the request.
+func (s *Session) End(status int) {
+	audit(s.Tenant, s.stream, s.req, status)
+}
+
+// tenantOf returns the tenant ID claimed by the caller, empty if there is none.
+func (t *Tenants) tenantOf(req events.APIGatewayV2HTTPRequest) string {
+	if req.RequestContext.Authorizer == nil || req.RequestContext.Authorizer.JWT == nil {
+		return ""
+	}
+
+	return req.RequestContext.Authorizer.JWT.Claims[t.claim]
+}
+
+// service returns the service loading the keys of tenant id, creating it on first use.
+func (t *Tenants) service(id string, cfg tenant.Tenant) *Service {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.services[id]; ok {
+		return s
+	}
+
+	config := t.config
+	config.Tenant = id
+	config.SecretName = tenant.SecretName(t.config.SecretName, id)
+	if t.config.PolicySecretName != "" {
+		config.PolicySecretName = tenant.SecretName(t.config.PolicySecretName, id)
+	}
+	if cfg.MasterKeyId != "" {
+		config.MasterKeyId = cfg.MasterKeyId
+	}
+
+	s := NewService(t.keys, t.secrets, config)
+	t.services[id] = s
+
+	return s
+}
+
+// own records the keys of tenant id, failing if another tenant has loaded one of them,
+// e.g. because its keyset secret was copied.
+func (t *Tenants) own(id string, s *Service) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, dek := range s.keyContext().ring.keys {
+		check := keyset.CheckValue(dek)
+		if owner, ok := t.owners[check]; ok && owner != id {
+			return fmt.Errorf("keyset of tenant %s holds a key of tenant %s, refusing to use it", id, owner)
+		}
+		t.owners[check] = id
+	}
+
+	return nil
+}
+
+// Health reports the readiness of every tenant's keys without loading any.
+func (t *Tenants) Health(
+	ctx context.Context, // Reserved.
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	resp := HealthResponse{Status: StatusReady, Tenants: map[string]string{}}
+
+	t.mu.Lock()
+	for _, id := range t.registry.IDs() {
+		resp.Tenants[id] = StatusStarting
+		if s, ok := t.services[id]; ok {
+			resp.Tenants[id], _ = s.Status()
+		}
+	}
+	t.mu.Unlock()
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
+
+// auditRecord is written for every request of a tenant; it never carries inputs or outputs.
+type auditRecord struct {
+	Stream    string `json:"stream"`
+	Tenant    string `json:"tenant"`
+	Time      string `json:"time"`
+	RequestId string `json:"requestId,omitempty"`
+	Caller    string `json:"caller,omitempty"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+}
+
+// audit writes the record of a request to the log, tagged with the tenant's audit stream
+// so that a subscription filter per stream can route it.
+func audit(id string, stream string, req events.APIGatewayV2HTTPRequest, status int) {
+	record := auditRecord{
+		Stream:    stream,
+		Tenant:    id,
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		RequestId: req.RequestContext.RequestID,
+		Path:      req.RequestContext.HTTP.Path,
+		Status:    status,
+	}
+	if req.RequestContext.Authorizer != nil && req.RequestContext.Authorizer.JWT != nil {
+		record.Caller = req.RequestContext.Authorizer.JWT.Claims["sub"]
+	}
+
+	line, _ := json.Marshal(record)
+	fmt.Println("{Audit}", string(line))
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/tenant"
+)
+
+const testTenants = `
+tenants:
+  payments:
+    quota: {requestsPerMinute: 100}
+  marketing:
+    quota: {requestsPerMinute: 1}
+`
+
+func tenantRequest(id string) events.APIGatewayV2HTTPRequest {
+	req := events.APIGatewayV2HTTPRequest{}
+	req.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+			Claims: map[string]string{defaultTenantClaim: id},
+		},
+	}
+	return req
+}
+
+// serve runs op as a request of tenant id
+func serve(t *testing.T, tenants *Tenants, id string, op func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error)) events.APIGatewayV2HTTPResponse {
+	req := tenantRequest(id)
+	session, status, err := tenants.Begin(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Begin %s: %d %v", id, status, err)
+	}
+
+	resp := mustResponse(op(session.Context(context.Background()), req))
+	session.End(resp.StatusCode)
+
+	return resp
+}
+
+func TestTenantIsolation(t *testing.T) {
+	t.Setenv("FPE_TWEAK", "D8E7920AFA330A73")
+
+	registry, err := tenant.Parse([]byte(testTenants))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	ctx := context.Background()
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+	store.CreateSecret("/secret/fpe/policy/tenants/payments", classKeysPolicy, "")
+	tenants := NewTenants(keys, store, Config{
+		SecretName:       "/secret/fpe/dek",
+		PolicySecretName: "/secret/fpe/policy",
+	}, defaultTenantClaim, registry)
+
+	const plaintext = "4111111111111111"
+	enc := decodeFpeResponse(t, serve(t, tenants, "payments", func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return Encrypt(plaintext, 10, nil, ctx, req)
+	}))
+	env := decodeFpeResponse(t, serve(t, tenants, "payments", func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return EnvelopeEncrypt(plaintext, nil, ctx, req)
+	}))
+
+	if _, err := store.GetSecret("/secret/fpe/dek/tenants/payments"); err != nil {
+		t.Fatalf("Keyset of the tenant was not stored under its own name: %v", err)
+	}
+
+	// The other tenant's key ID is unknown, and its envelope key cannot be found
+	resp := serve(t, tenants, "marketing", func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return Decrypt(enc.Ciphertext, 10, enc.KeyId, nil, ctx, req)
+	})
+	if resp.StatusCode == http.StatusOK {
+		t.Fatalf("Decrypted a token of another tenant: %s", resp.Body)
+	}
+
+	tenants.limiters["marketing"] = tenant.NewLimiter(100)
+	resp = serve(t, tenants, "marketing", func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return EnvelopeDecrypt(env.Ciphertext, ctx, req)
+	})
+	if resp.StatusCode == http.StatusOK {
+		t.Fatalf("Decrypted an envelope of another tenant: %s", resp.Body)
+	}
+
+	dec := decodeFpeResponse(t, serve(t, tenants, "payments", func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return Decrypt(enc.Ciphertext, 10, enc.KeyId, nil, ctx, req)
+	}))
+	if dec.Plaintext != plaintext {
+		t.Fatalf("Decrypt: got %q", dec.Plaintext)
+	}
+
+	// Derived keys and the policy are the tenant's own
+	class := decodeFpeResponse(t, serve(t, tenants, "payments", func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return ClassEncrypt("01012345678", "customer.phone", ctx, req)
+	}))
+	if class.KeyLabel != "fpe/tenant/payments/class/customer.phone/fpe" {
+		t.Fatalf("Unexpected key label %q", class.KeyLabel)
+	}
+
+	// Callers without a known tenant, and tenants over quota, are refused
+	if _, status, err := tenants.Begin(ctx, tenantRequest("other")); err == nil || status != http.StatusForbidden {
+		t.Fatalf("Expected status %d for an unknown tenant, got %d", http.StatusForbidden, status)
+	}
+	tenants.limiters["marketing"] = tenant.NewLimiter(1)
+	serve(t, tenants, "marketing", func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return apiResponse(http.StatusOK, &HealthResponse{})
+	})
+	if _, status, err := tenants.Begin(ctx, tenantRequest("marketing")); err == nil || status != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d over quota, got %d", http.StatusTooManyRequests, status)
+	}
+}
+
+func TestTenantSharedKeysRefused(t *testing.T) {
+	registry, _ := tenant.Parse([]byte(testTenants))
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+	tenants := NewTenants(keys, store, Config{SecretName: "/secret/fpe/dek"}, defaultTenantClaim, registry)
+
+	serve(t, tenants, "payments", func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return apiResponse(http.StatusOK, &HealthResponse{})
+	})
+
+	// A keyset copied from one tenant to another is not used
+	value, _ := store.GetSecret("/secret/fpe/dek/tenants/payments")
+	store.CreateSecret("/secret/fpe/dek/tenants/marketing", value, "")
+
+	if _, status, err := tenants.Begin(context.Background(), tenantRequest("marketing")); err == nil || status != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d for a copied keyset, got %d", http.StatusInternalServerError, status)
+	}
+}
+
+func TestTenantsServedConcurrently(t *testing.T) {
+	t.Setenv("FPE_TWEAK", "D8E7920AFA330A73")
+
+	registry, _ := tenant.Parse([]byte(testTenants))
+	tenants := NewTenants(kms.NewMemoryKeyManager(), secretsmanager.NewMemoryStore(), Config{SecretName: "/secret/fpe/dek"}, defaultTenantClaim, registry)
+	tenants.limiters["marketing"] = tenant.NewLimiter(100)
+
+	// Both requests are in progress at once, each with the keys of its own tenant
+	ctx := context.Background()
+	sessions := map[string]*Session{}
+	for _, id := range []string{"payments", "marketing"} {
+		session, status, err := tenants.Begin(ctx, tenantRequest(id))
+		if err != nil {
+			t.Fatalf("Begin %s: %d %v", id, status, err)
+		}
+		sessions[id] = session
+	}
+
+	const plaintext = "4111111111111111"
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	responses := map[string]events.APIGatewayV2HTTPResponse{}
+	for id, session := range sessions {
+		wg.Add(1)
+		go func(id string, session *Session) {
+			defer wg.Done()
+			resp, _ := Encrypt(plaintext, 10, nil, session.Context(ctx), tenantRequest(id))
+
+			mu.Lock()
+			defer mu.Unlock()
+			responses[id] = resp
+		}(id, session)
+	}
+	wg.Wait()
+
+	tokens := map[string]FpeResponse{}
+	for id, resp := range responses {
+		tokens[id] = decodeFpeResponse(t, resp)
+	}
+
+	if tokens["payments"].KeyId == tokens["marketing"].KeyId {
+		t.Fatalf("Tenants encrypted with the same key %s", tokens["payments"].KeyId)
+	}
+
+	for id, session := range sessions {
+		for owner, token := range tokens {
+			dec := decodeFpeResponse(t, mustResponse(Decrypt(token.Ciphertext, 10, "", nil, session.Context(ctx), tenantRequest(id))))
+			if decrypted := dec.Plaintext == plaintext; decrypted != (owner == id) {
+				t.Fatalf("Decrypt of a token of %s in a request of %s: got %q", owner, id, dec.Plaintext)
+			}
+		}
+		session.End(http.StatusOK)
+	}
+}
+
+func TestTenantTokensNotFoundElsewhere(t *testing.T) {
+	registry, _ := tenant.Parse([]byte(testTenants))
+	tenants := NewTenants(kms.NewMemoryKeyManager(), secretsmanager.NewMemoryStore(), Config{SecretName: "/secret/fpe/dek"}, defaultTenantClaim, registry)
+	tenants.limiters["marketing"] = tenant.NewLimiter(100)
+
+	resp := serve(t, tenants, "payments", func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return Tokenize("4111-1111-1111-1111", false, ctx, req)
+	})
+	token := decodeFpeResponse(t, resp).Token
+
+	// The token of another tenant is answered like a token never issued
+	for _, input := range []string{token, "0000-0000-0000-0000"} {
+		resp = serve(t, tenants, "marketing", func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+			return Detokenize(input, ctx, req)
+		})
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("Expected status %d for %s, got %d: %s", http.StatusNotFound, input, resp.StatusCode, resp.Body)
+		}
+	}
+
+	resp = serve(t, tenants, "payments", func(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		return Detokenize(token, ctx, req)
+	})
+	if dec := decodeFpeResponse(t, resp); dec.Plaintext != "4111-1111-1111-1111" {
+		t.Fatalf("Detokenize: got %q", dec.Plaintext)
+	}
+}
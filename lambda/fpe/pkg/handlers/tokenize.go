@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/tokenization"
+)
+
+// [2022-02-28] Token store backends selectable with TOKEN_STORE.
+const (
+	TokenStoreMemory   = "memory"
+	TokenStoreBolt     = "bolt"
+	TokenStoreDynamoDB = "dynamodb"
+
+	defaultTokenStorePath = "/tmp/tokens.db"
+)
+
+var (
+	vaultOnce  sync.Once
+	tokenStore tokenization.TokenStore
+	vaultErr   error
+
+	// [2022-04-28] Vaults by tenant; each seals its tokens under a key of its tenant.
+	vaultsMu sync.Mutex
+	vaults   = map[string]*tokenization.Vault{}
+)
+
+// tokenVault lazily opens the token store configured in the environment:
+//   - TOKEN_STORE: "memory" (default for local runs, refused on Lambda where it would only last
+//     as long as the instance), "bolt" or "dynamodb"
+//   - TOKEN_STORE_PATH: BoltDB file, /tmp/tokens.db by default
+//   - TOKEN_STORE_TABLE: DynamoDB table with a string partition key "pk"
+//   - TOKEN_STORE_ENDPOINT: optional DynamoDB endpoint, e.g. DynamoDB Local
+func (kc *keyContext) tokenVault() (*tokenization.Vault, error) {
+	vaultOnce.Do(func() {
+		switch os.Getenv("TOKEN_STORE") {
+		case "", TokenStoreMemory:
+			if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+				vaultErr = errors.New("a persistent TOKEN_STORE is required on Lambda")
+				return
+			}
+			tokenStore = tokenization.NewMemoryStore()
+
+		case TokenStoreBolt:
+			path := os.Getenv("TOKEN_STORE_PATH")
+			if path == "" {
+				path = defaultTokenStorePath
+			}
+			tokenStore, vaultErr = tokenization.NewBoltStore(path)
+
+		case TokenStoreDynamoDB:
+			if os.Getenv("TOKEN_STORE_TABLE") == "" {
+				vaultErr = errors.New("TOKEN_STORE_TABLE is required with TOKEN_STORE=dynamodb")
+				return
+			}
+
+			config := aws.NewConfig()
+			if endpoint := os.Getenv("TOKEN_STORE_ENDPOINT"); endpoint != "" {
+				config = config.WithEndpoint(endpoint)
+			}
+
+			var sess *session.Session
+			sess, vaultErr = session.NewSessionWithOptions(
+				session.Options{
+					Config:            *config,
+					SharedConfigState: session.SharedConfigEnable,
+				},
+			)
+			if vaultErr == nil {
+				tokenStore = tokenization.NewDynamoDBStore(dynamodb.New(sess), os.Getenv("TOKEN_STORE_TABLE"))
+			}
+
+		default:
+			vaultErr = errors.New("unknown TOKEN_STORE: " + os.Getenv("TOKEN_STORE"))
+		}
+	})
+	if vaultErr != nil {
+		return nil, vaultErr
+	}
+
+	vaultsMu.Lock()
+	defer vaultsMu.Unlock()
+
+	if v, ok := vaults[kc.tenant]; ok {
+		return v, nil
+	}
+
+	key, err := kc.deriveKey(KeyPurposeTokenVault)
+	if err != nil {
+		return nil, err
+	}
+
+	// [2022-05-09] Tokens of a tenant are stored apart from those of other tenants, so a token
+	// of another tenant is not found rather than found and failing to open.
+	v, err := tokenization.NewVault(tokenization.Namespaced(tokenStore, kc.tenant), key, tokenization.Options{})
+	if err != nil {
+		return nil, err
+	}
+	vaults[kc.tenant] = v
+
+	return v, nil
+}
+
+func Tokenize(
+	input string,
+	deterministic bool,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	var resp FpeResponse
+
+	v, err := keysOf(ctx).tokenVault()
+	if err != nil {
+		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+	}
+
+	token, err := v.Tokenize(ctx, input, deterministic)
+	if err == tokenization.ErrNothingToTokenize {
+		return HandleError(http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+	}
+
+	// Set response.
+	resp.Operation = "Tokenize"
+	resp.Plaintext = input
+	resp.Token = token
+	resp.Radix = -1 // Unused
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
+
+func Detokenize(
+	input string,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	var resp FpeResponse
+
+	v, err := keysOf(ctx).tokenVault()
+	if err != nil {
+		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+	}
+
+	plaintext, err := v.Detokenize(ctx, input)
+	if err == tokenization.ErrNotFound {
+		return HandleError(http.StatusNotFound, err)
+	}
+	if err != nil {
+		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+	}
+
+	// Set response.
+	resp.Operation = "Detokenize"
+	resp.Plaintext = plaintext
+	resp.Token = input
+	resp.Radix = -1 // Unused
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ff1"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kdf"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/keyset"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/marker"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/policy"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// [2022-04-21] Convert a token from one pseudonymization domain to another, e.g. to share data
+// with a partner under tokens unlinkable to ours. The plaintext never leaves the service;
+// only the domain pairs allowed by the translations of the policy may be converted.
+func Translate(
+	input string,
+	radix int,
+	from string,
+	to string,
+	keyId string, // Optional key of the keyset, for tokens of the default domain.
+	markerSpec *marker.Spec, // Optional token marker, checked on the input and applied to the output like /decrypt and /encrypt do.
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	var resp TranslateResponse
+
+	kc := keysOf(ctx)
+
+	p, err := kc.policy()
+	if err != nil {
+		return HandleError(http.StatusInternalServerError, errors.New(ErrorPolicyUnavailable+": "+err.Error()))
+	}
+
+	for _, domain := range []string{from, to} {
+		if _, err := p.Domain(domain); err != nil {
+			return HandleError(http.StatusBadRequest, errors.New(err.Error()+": "+domain))
+		}
+	}
+
+	if !p.MayTranslate(from, to) {
+		return HandleError(http.StatusForbidden, errors.New(ErrorTranslationNotAllowed+": "+from+" to "+to))
+	}
+
+	source, ciphertext, status, err := kc.domainCipher(from, radix, input, keyId, markerSpec)
+	if err != nil {
+		return HandleError(status, err)
+	}
+
+	target, _, status, err := kc.domainCipher(to, radix, "", "", markerSpec)
+	if err != nil {
+		return HandleError(status, err)
+	}
+
+	plaintext, err := source.cipher.DecryptWithTweak(ciphertext, source.tweak)
+	if err != nil {
+		return HandleError(http.StatusBadRequest, errors.New(err.Error()))
+	}
+
+	token, err := target.cipher.EncryptWithTweak(plaintext, target.tweak)
+	if err != nil {
+		return HandleError(http.StatusInternalServerError, errors.New(err.Error()))
+	}
+
+	if target.marker != nil {
+		token = target.marker.Mark(token)
+	}
+
+	// Set response; unlike /encrypt and /decrypt, never the plaintext.
+	resp.Operation = "Translate"
+	resp.Input = input
+	resp.Token = token
+	resp.From = from
+	resp.To = to
+	resp.Radix = radix
+	resp.KeyId = target.keyId
+	resp.PolicyVersion = p.Version
+	if from != policy.DefaultDomain {
+		resp.FromKeyLabel = kc.scoped(domainKeyLabel(from)).String()
+	}
+	if to != policy.DefaultDomain {
+		resp.ToKeyLabel = kc.scoped(domainKeyLabel(to)).String()
+	}
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
+
+// domainKeys are the FF1 cipher, tweak and token marker of a domain, and the ID of the keyset key for the default domain.
+type domainKeys struct {
+	cipher ff1.Cipher
+	tweak  []byte
+	marker marker.Scheme
+	keyId  string
+}
+
+// domainCipher returns the keys of domain. Named domains use a key derived from their name;
+// the default domain uses the keyset like /encrypt and /decrypt: the key token was made with,
+// or the primary key if token is empty. Tokens of every domain carry the marker of markerSpec,
+// if given, under the key of their domain. It also returns token with its marker checked and
+// removed, and the HTTP status to answer with on error.
+func (kc *keyContext) domainCipher(domain string, radix int, token string, keyId string, markerSpec *marker.Spec) (domainKeys, string, int, error) {
+	var keys domainKeys
+	var key []byte
+	var err error
+
+	switch domain {
+	case policy.DefaultDomain:
+		if keys.tweak, err = fpeTweak(); err != nil {
+			return domainKeys{}, "", http.StatusInternalServerError, err
+		}
+
+		var k keyset.Key
+		var dek []byte
+		if token == "" {
+			if k, dek, err = kc.primaryKey(); err != nil {
+				return domainKeys{}, "", http.StatusServiceUnavailable, errors.New(ErrorKeysUnavailable)
+			}
+		} else {
+			// The marker is checked here, under every candidate key
+			var status int
+			if k, dek, token, status, err = kc.fpeTokenKey(token, radix, keyId, markerSpec); err != nil {
+				return domainKeys{}, "", status, err
+			}
+		}
+		keys.keyId = k.ID
+
+		// The marker is keyed by the keyset key, FF1 by its subkey, as for /encrypt
+		if keys.marker, err = tokenMarker(markerSpec, radix, dek); err != nil {
+			return domainKeys{}, "", http.StatusBadRequest, errors.New(ErrorInvalidMarker)
+		}
+		if key, err = (&serviceKeys{kc: kc, source: k, dek: dek}).Key(transform.TypeFpe); err != nil {
+			return domainKeys{}, "", domainKeyStatus(err), err
+		}
+
+	default:
+		if key, err = kc.subkey(domainKeyLabel(domain)); err != nil {
+			return domainKeys{}, "", domainKeyStatus(err), err
+		}
+		keys.tweak = policy.DomainTweak(domain)
+
+		if keys.marker, err = tokenMarker(markerSpec, radix, key); err != nil {
+			return domainKeys{}, "", http.StatusBadRequest, errors.New(ErrorInvalidMarker)
+		}
+	}
+
+	if domain != policy.DefaultDomain && token != "" && keys.marker != nil {
+		unmarked, ok := keys.marker.Unmark(token)
+		if !ok {
+			return domainKeys{}, "", http.StatusBadRequest, errors.New(ErrorNotAToken)
+		}
+		token = unmarked
+	}
+
+	if keys.cipher, err = ff1.NewCipher(radix, len(keys.tweak), key, nil); err != nil {
+		return domainKeys{}, "", http.StatusBadRequest, err
+	}
+
+	return keys, token, http.StatusOK, nil
+}
+
+// domainKeyStatus returns the HTTP status to answer a failure to derive the key of a domain with: 403 if it was revoked.
+func domainKeyStatus(err error) int {
+	if errors.Is(err, kdf.ErrRevoked) {
+		return http.StatusForbidden
+	}
+	return http.StatusServiceUnavailable
+}
+
+// [2022-04-25] domainKeyLabel returns the label of the key of a named domain.
+func domainKeyLabel(domain string) kdf.Label {
+	return kdf.Label{Purpose: KeyPurposeDomain + "/" + domain}
+}
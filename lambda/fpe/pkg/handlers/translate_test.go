@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/marker"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/policy"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+)
+
+const testPolicy = `
+version: "2022-04-21"
+dataClasses:
+  customer.phone:
+    transform: fpe
+    fpe: {radix: 10}
+domains:
+  partner-a: {}
+  partner-b: {}
+translations:
+  - {from: default, to: partner-a}
+  - {from: partner-a, to: default}
+  - {from: default, to: partner-b}
+`
+
+func decodeTranslateResponse(t *testing.T, resp events.APIGatewayV2HTTPResponse) TranslateResponse {
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, resp.StatusCode, resp.Body)
+	}
+
+	var out TranslateResponse
+	if err := json.Unmarshal([]byte(resp.Body), &out); err != nil {
+		t.Fatalf("Unable to decode response: %v", err)
+	}
+	return out
+}
+
+func TestTranslate(t *testing.T) {
+	t.Setenv("FPE_TWEAK", "D8E7920AFA330A73")
+
+	p, err := policy.Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	loadedPolicy.doc = p
+
+	ctx := context.Background()
+	req := events.APIGatewayV2HTTPRequest{}
+	if err := NewService(kms.NewMemoryKeyManager(), secretsmanager.NewMemoryStore(), Config{SecretName: "/secret/fpe/dek"}).Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	const plaintext = "01012345678"
+	ours := decodeFpeResponse(t, mustResponse(Encrypt(plaintext, 10, nil, ctx, req)))
+
+	a := decodeTranslateResponse(t, mustResponse(Translate(ours.Ciphertext, 10, "default", "partner-a", "", nil, ctx, req)))
+	b := decodeTranslateResponse(t, mustResponse(Translate(ours.Ciphertext, 10, "default", "partner-b", "", nil, ctx, req)))
+
+	if a.Token == ours.Ciphertext || a.Token == b.Token || len(a.Token) != len(plaintext) {
+		t.Fatalf("Domains must have distinct tokens: ours %s, a %s, b %s", ours.Ciphertext, a.Token, b.Token)
+	}
+
+	back := decodeTranslateResponse(t, mustResponse(Translate(a.Token, 10, "partner-a", "default", "", nil, ctx, req)))
+	if back.Token != ours.Ciphertext || back.KeyId != ours.KeyId {
+		t.Fatalf("Translating back: expected %s, got %+v", ours.Ciphertext, back)
+	}
+
+	resp, _ := Translate(b.Token, 10, "partner-b", "default", "", nil, ctx, req)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status %d for a pair not allowed, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	resp, _ = Translate(a.Token, 10, "partner-c", "default", "", nil, ctx, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for an unknown domain, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// Marked tokens are checked and unmarked on the way in, and marked again on the way out
+	check := &marker.Spec{Scheme: marker.SchemeCheck}
+	marked := decodeFpeResponse(t, mustResponse(Encrypt(plaintext, 10, check, ctx, req)))
+
+	am := decodeTranslateResponse(t, mustResponse(Translate(marked.Ciphertext, 10, "default", "partner-a", "", check, ctx, req)))
+	if len(am.Token) <= len(a.Token) {
+		t.Fatalf("Expected a marked token, got %s", am.Token)
+	}
+
+	backm := decodeTranslateResponse(t, mustResponse(Translate(am.Token, 10, "partner-a", "default", "", check, ctx, req)))
+	if backm.Token != marked.Ciphertext {
+		t.Fatalf("Translating marked tokens back: expected %s, got %s", marked.Ciphertext, backm.Token)
+	}
+
+	resp, _ = Translate(a.Token, 10, "partner-a", "default", "", check, ctx, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for an unmarked token, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kdf"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/keyset"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+// serviceKeys hands the subkeys of the data encryption key and the shared tweak to transformers.
+// [2022-04-25] It records the label of the last derived key handed out, for the response.
+type serviceKeys struct {
+	label string
+
+	// [2022-05-09] Keys of the request
+	kc *keyContext
+
+	// [2022-05-05] Key of the keyset, and its unwrapped key, the subkeys are derived from
+	// instead of the root key, e.g. the key a token was made with
+	source keyset.Key
+	dek    []byte
+
+	// Key and tweak used for every purpose instead of a subkey and FPE_TWEAK if set,
+	// e.g. the key and tweak of a data class
+	key   []byte
+	tweak []byte
+}
+
+// [2022-05-05] Purposes whose key was the data encryption key itself before FF1 used subkeys.
+// Keys of the keyset created before then are still used as is for them, see keyset.Key.Derivation.
+var rawKeyPurposes = map[string]bool{
+	transform.TypeFpe:     true,
+	transform.TypeFormat:  true,
+	transform.TypePattern: true,
+	transform.TypeLong:    true,
+	KeyPurposeScan:        true,
+}
+
+func (k *serviceKeys) Key(purpose string) ([]byte, error) {
+	if k.key != nil {
+		return k.key, nil
+	}
+
+	if purpose == "" {
+		return nil, errors.New("key purpose is required")
+	}
+
+	source, dek := k.source, k.dek
+	if dek == nil {
+		if k.kc.ring == nil {
+			return nil, errors.New("data encryption key is not available")
+		}
+		source, dek = k.kc.ring.root()
+	}
+
+	if rawKeyPurposes[purpose] && !source.Derives() {
+		return dek, nil
+	}
+
+	label := kdf.Label{Purpose: purpose}
+	k.label = k.kc.scoped(label).String()
+
+	if k.dek == nil {
+		return k.kc.subkey(label)
+	}
+	return k.kc.subkeyOf(dek, label)
+}
+
+func (k *serviceKeys) Tweak() ([]byte, error) {
+	if k.tweak != nil {
+		return k.tweak, nil
+	}
+	return fpeTweak()
+}
+
+// registered returns the transformer of typ and params parsed as if they came with a request,
+// so the dedicated handlers share the checks, defaults and ciphers of the registered types.
+func registered(typ string, params interface{}) (transform.Transformer, transform.Params, error) {
+	t, _, ok := transform.Lookup(typ)
+	if !ok {
+		return nil, nil, errors.New(ErrorUnknownType + ": " + typ)
+	}
+
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parsed, err := t.Parse(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return t, parsed, nil
+}
+
+// applyTransform protects input with t, or reveals it for the "Decrypt" operation.
+func applyTransform(operation string, t transform.Transformer, keys transform.Keys, params transform.Params, input string) (string, error) {
+	if operation == "Decrypt" {
+		return t.Reveal(keys, params, input)
+	}
+
+	if err := t.Validate(params, input); err != nil {
+		return "", transform.InvalidInput(err)
+	}
+	return t.Protect(keys, params, input)
+}
+
+// transformError answers a failure of applyTransform: 400 if the input caused it, 403 if a key was revoked.
+func transformError(err error) (events.APIGatewayV2HTTPResponse, error) {
+	if errors.Is(err, transform.ErrInvalidInput) {
+		return HandleError(http.StatusBadRequest, errors.New(err.Error()))
+	}
+	return keyError(err)
+}
+
+// [2022-03-31] Encrypt input with the transformer registered for typ (see transform.Register).
+func TypeEncrypt(
+	input string,
+	typ string,
+	params json.RawMessage,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return typeOperation(keysOf(ctx), "Encrypt", input, typ, params)
+}
+
+func TypeDecrypt(
+	input string,
+	typ string,
+	params json.RawMessage,
+	ctx context.Context,
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	return typeOperation(keysOf(ctx), "Decrypt", input, typ, params)
+}
+
+// [2022-03-31] List the registered types and their parameters.
+func Types(
+	ctx context.Context, // Reserved.
+	req events.APIGatewayV2HTTPRequest, // Reserved.
+) (
+	events.APIGatewayV2HTTPResponse,
+	error,
+) {
+	var resp TypesResponse
+
+	resp.Operation = "Types"
+	resp.Types = transform.Types()
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
+
+func typeOperation(kc *keyContext, operation string, input string, typ string, raw json.RawMessage) (events.APIGatewayV2HTTPResponse, error) {
+	var resp FpeResponse
+
+	t, info, ok := transform.Lookup(typ)
+	if !ok {
+		return HandleError(http.StatusBadRequest, errors.New(ErrorUnknownType+": "+typ))
+	}
+
+	params, err := t.Parse(raw)
+	if err != nil {
+		return HandleError(http.StatusBadRequest, errors.New(ErrorInvalidParams+": "+err.Error()))
+	}
+
+	keys := serviceKeys{kc: kc}
+	var plaintext, ciphertext string
+	if operation == "Encrypt" {
+		plaintext = input
+		ciphertext, err = applyTransform(operation, t, &keys, params, plaintext)
+	} else {
+		if !info.Reversible {
+			return HandleError(http.StatusBadRequest, errors.New(ErrorIrreversibleTransform+": "+typ))
+		}
+		ciphertext = input
+		plaintext, err = applyTransform(operation, t, &keys, params, ciphertext)
+	}
+	if err != nil {
+		return transformError(err)
+	}
+
+	// Set response.
+	resp.Operation = "Type-" + operation
+	resp.Plaintext = plaintext
+	resp.Ciphertext = ciphertext
+	resp.Radix = -1 // Unused
+	resp.Type = typ
+	resp.KeyLabel = keys.label
+
+	return apiResponse(
+		http.StatusOK,
+		&resp,
+	)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ff1"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kdf"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/keyset"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/transform"
+)
+
+func TestTypeErrors(t *testing.T) {
+	t.Setenv("FPE_TWEAK", "D8E7920AFA330A73")
+
+	ctx := context.Background()
+	req := events.APIGatewayV2HTTPRequest{}
+	s := NewService(kms.NewMemoryKeyManager(), secretsmanager.NewMemoryStore(), Config{SecretName: "/secret/fpe/dek"})
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	// The dedicated handlers and the "type" requests share the registered transformers
+	typed := decodeFpeResponse(t, mustResponse(TypeEncrypt("4111111111111111", "format", json.RawMessage(`{"format": "luhn"}`), ctx, req)))
+	format := decodeFpeResponse(t, mustResponse(FormatEncrypt("4111111111111111", "luhn", ctx, req)))
+	if typed.Ciphertext != format.Ciphertext {
+		t.Fatalf("Type and format tokens differ: %q, %q", typed.Ciphertext, format.Ciphertext)
+	}
+
+	// Bad input is the caller's error, not the service's
+	for name, call := range map[string]func() (events.APIGatewayV2HTTPResponse, error){
+		"type fpe": func() (events.APIGatewayV2HTTPResponse, error) {
+			return TypeDecrypt("01234x6789", "fpe", json.RawMessage(`{"radix": 10}`), ctx, req)
+		},
+		"type ope": func() (events.APIGatewayV2HTTPResponse, error) {
+			return TypeDecrypt("not-a-token", "ope", nil, ctx, req)
+		},
+		"decrypt": func() (events.APIGatewayV2HTTPResponse, error) {
+			return Decrypt("01234x6789", 10, "", nil, ctx, req)
+		},
+		"long": func() (events.APIGatewayV2HTTPResponse, error) {
+			return LongDecrypt("01234x6789", 10, 0, ctx, req)
+		},
+	} {
+		resp, _ := call()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d: %s", name, http.StatusBadRequest, resp.StatusCode, resp.Body)
+		}
+	}
+}
+
+// FF1 uses subkeys of the keys added since subkeys, and older keys as is, so their tokens still decrypt
+func TestFpeSubkeys(t *testing.T) {
+	t.Setenv("FPE_TWEAK", "D8E7920AFA330A73")
+
+	keys := kms.NewMemoryKeyManager()
+	ctx := context.Background()
+	req := events.APIGatewayV2HTTPRequest{}
+	tweak, _ := fpeTweak()
+
+	wrapped, _ := keys.GenerateDataKey("", nil)
+	dek, _ := keys.DecryptDataKey(wrapped, nil)
+	raw, _ := ff1.NewCipher(10, len(tweak), dek, tweak)
+	legacy, _ := raw.Encrypt("0123456789")
+
+	// A secret written before keysets holds the hex-encoded wrapped key
+	store := secretsmanager.NewMemoryStore()
+	store.CreateSecret("/secret/fpe/dek", hex.EncodeToString(wrapped), "")
+	if err := NewService(keys, store, Config{SecretName: "/secret/fpe/dek"}).Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if out := decodeFpeResponse(t, mustResponse(Encrypt("0123456789", 10, nil, ctx, req))); out.Ciphertext != legacy || out.KeyLabel != "" {
+		t.Fatalf("Expected the legacy key to be used as is, got %+v", out)
+	}
+
+	// A key added by rotation is only used through its subkey
+	ks, _ := keyset.New(wrapped, dek, nil)
+	value, _ := ks.Encode()
+	store = secretsmanager.NewMemoryStore()
+	store.CreateSecret("/secret/fpe/dek", value, "")
+	if err := NewService(keys, store, Config{SecretName: "/secret/fpe/dek"}).Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	out := decodeFpeResponse(t, mustResponse(Encrypt("0123456789", 10, nil, ctx, req)))
+	subkey, _ := kdf.Derive(dek, kdf.Label{Purpose: transform.TypeFpe})
+	derived, _ := ff1.NewCipher(10, len(tweak), subkey, tweak)
+	if want, _ := derived.Encrypt("0123456789"); out.Ciphertext != want || out.KeyLabel != "fpe/fpe" {
+		t.Fatalf("Expected the token under the subkey fpe/fpe, got %+v", out)
+	}
+
+	if dec := decodeFpeResponse(t, mustResponse(Decrypt(out.Ciphertext, 10, "", nil, ctx, req))); dec.Plaintext != "0123456789" {
+		t.Fatalf("Decrypt: %+v", dec)
+	}
+}
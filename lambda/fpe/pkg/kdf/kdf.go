@@ -0,0 +1,165 @@
+// Package kdf derives a hierarchy of subkeys from a root data encryption key with HKDF-SHA256.
+//
+// Each subkey is named by a Label of tenant, data class and purpose, encoded as the HKDF info:
+//
+//	fpe[/tenant/<tenant>][/class/<data class>]/<purpose>
+//
+// Tenants and data classes cannot contain "/" and purposes cannot start with "tenant/" or
+// "class/", so the encoding is injective: subkeys of different labels never coincide. A label
+// without tenant and data class encodes as "fpe/<purpose>", the info used before the hierarchy
+// existed, so keys derived for purposes alone are unchanged.
+package kdf
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// KeyBytes is the length of every derived key
+const KeyBytes = 32
+
+var (
+	// ErrRevoked is returned for subkeys revoked with Deriver.Revoke
+	ErrRevoked = errors.New("derived key is revoked")
+
+	tenantPattern  = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	purposePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+(/[A-Za-z0-9_.-]+)*$`)
+)
+
+// Label names a subkey. Tenant and DataClass are optional; Purpose is required.
+type Label struct {
+	Tenant    string `json:"tenant,omitempty"`
+	DataClass string `json:"dataClass,omitempty"`
+	Purpose   string `json:"purpose"`
+}
+
+// Validate checks that the label can be encoded unambiguously.
+func (l Label) Validate() error {
+	if l.Tenant != "" && !tenantPattern.MatchString(l.Tenant) {
+		return fmt.Errorf("invalid tenant %q", l.Tenant)
+	}
+
+	if l.DataClass != "" && (strings.Contains(l.DataClass, "/") || strings.TrimSpace(l.DataClass) != l.DataClass) {
+		return fmt.Errorf("invalid data class %q", l.DataClass)
+	}
+
+	if !purposePattern.MatchString(l.Purpose) || strings.HasPrefix(l.Purpose, "tenant/") || strings.HasPrefix(l.Purpose, "class/") {
+		return fmt.Errorf("invalid purpose %q", l.Purpose)
+	}
+
+	return nil
+}
+
+// String returns the HKDF info of the label, which is also how responses report it.
+func (l Label) String() string {
+	var b strings.Builder
+	b.WriteString("fpe/")
+	if l.Tenant != "" {
+		b.WriteString("tenant/" + l.Tenant + "/")
+	}
+	if l.DataClass != "" {
+		b.WriteString("class/" + l.DataClass + "/")
+	}
+	b.WriteString(l.Purpose)
+
+	return b.String()
+}
+
+// Derive returns the subkey of root for label.
+func Derive(root []byte, label Label) ([]byte, error) {
+	if len(root) == 0 {
+		return nil, errors.New("root key must not be empty")
+	}
+
+	if err := label.Validate(); err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, KeyBytes)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, root, nil, []byte(label.String())), key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Deriver derives the subkeys of one root key, caching them and refusing revoked ones.
+type Deriver struct {
+	root []byte
+
+	mu      sync.Mutex
+	cache   map[string][]byte
+	revoked map[string]bool
+}
+
+// NewDeriver returns a deriver for root; labels in revoked, as returned by Label.String, are refused.
+func NewDeriver(root []byte, revoked ...string) *Deriver {
+	d := &Deriver{
+		root:    append([]byte(nil), root...),
+		cache:   map[string][]byte{},
+		revoked: map[string]bool{},
+	}
+	for _, label := range revoked {
+		d.revoked[label] = true
+	}
+
+	return d
+}
+
+// Key returns the subkey for label.
+func (d *Deriver) Key(label Label) ([]byte, error) {
+	name := label.String()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.revoked[name] {
+		return nil, fmt.Errorf("%w: %s", ErrRevoked, name)
+	}
+
+	if key, ok := d.cache[name]; ok {
+		return key, nil
+	}
+
+	key, err := Derive(d.root, label)
+	if err != nil {
+		return nil, err
+	}
+	d.cache[name] = key
+
+	return key, nil
+}
+
+// KeyOf returns the subkey for label of another root key, such as a key of the keyset other
+// than the root one. Labels revoked here are refused for every root; these keys are not cached.
+func (d *Deriver) KeyOf(root []byte, label Label) ([]byte, error) {
+	name := label.String()
+
+	d.mu.Lock()
+	revoked := d.revoked[name]
+	d.mu.Unlock()
+
+	if revoked {
+		return nil, fmt.Errorf("%w: %s", ErrRevoked, name)
+	}
+
+	return Derive(root, label)
+}
+
+// Revoke refuses the subkey for label from now on and drops it from the cache.
+func (d *Deriver) Revoke(label Label) {
+	name := label.String()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.revoked[name] = true
+	delete(d.cache, name)
+}
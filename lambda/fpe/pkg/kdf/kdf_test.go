@@ -0,0 +1,111 @@
+package kdf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var testRoot = bytes.Repeat([]byte{7}, 32)
+
+// Keys derived for a purpose alone must stay what they were before the hierarchy
+func TestPurposeOnlyCompatible(t *testing.T) {
+	want := make([]byte, KeyBytes)
+	io.ReadFull(hkdf.New(sha256.New, testRoot, nil, []byte("fpe/ope")), want)
+
+	got, err := Derive(testRoot, Label{Purpose: "ope"})
+	if err != nil {
+		t.Fatalf("Derive: %v", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Derived key changed for purpose ope")
+	}
+}
+
+func TestLabelsDoNotCoincide(t *testing.T) {
+	labels := []Label{
+		{Purpose: "fpe"},
+		{DataClass: "customer.phone", Purpose: "fpe"},
+		{DataClass: "customer.email", Purpose: "fpe"},
+		{Tenant: "team-a", Purpose: "fpe"},
+		{Tenant: "team-b", Purpose: "fpe"},
+		{Tenant: "team-a", DataClass: "customer.phone", Purpose: "fpe"},
+		{Tenant: "team-a", DataClass: "customer.phone", Purpose: "pseudonym"},
+		{Purpose: "policy/customer"},
+	}
+
+	seen := map[string]Label{}
+	for _, l := range labels {
+		key, err := Derive(testRoot, l)
+		if err != nil {
+			t.Fatalf("Derive(%s): %v", l, err)
+		}
+		if other, ok := seen[string(key)]; ok {
+			t.Fatalf("%s and %s derive the same key", l, other)
+		}
+		seen[string(key)] = l
+	}
+}
+
+func TestInvalidLabels(t *testing.T) {
+	for _, l := range []Label{
+		{},
+		{Purpose: "class/customer/fpe"},
+		{Purpose: "tenant/a/fpe"},
+		{DataClass: "a/b", Purpose: "fpe"},
+		{Tenant: "a/b", Purpose: "fpe"},
+	} {
+		if _, err := Derive(testRoot, l); err == nil {
+			t.Fatalf("Expected %+v to be rejected", l)
+		}
+	}
+}
+
+func TestDeriverRevoke(t *testing.T) {
+	phone := Label{DataClass: "customer.phone", Purpose: "fpe"}
+	email := Label{DataClass: "customer.email", Purpose: "fpe"}
+
+	d := NewDeriver(testRoot, email.String())
+
+	if _, err := d.Key(email); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("Expected ErrRevoked, got %v", err)
+	}
+
+	key, err := d.Key(phone)
+	if err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+
+	if want, _ := Derive(testRoot, phone); !bytes.Equal(key, want) {
+		t.Fatalf("Deriver and Derive disagree")
+	}
+
+	d.Revoke(phone)
+	if _, err := d.Key(phone); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("Expected ErrRevoked after Revoke, got %v", err)
+	}
+
+	// Revocation holds for the subkeys of other roots too
+	other := bytes.Repeat([]byte{8}, 32)
+	if _, err := d.KeyOf(other, phone); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("Expected ErrRevoked for another root, got %v", err)
+	}
+
+	fpe := Label{Purpose: "fpe"}
+	if key, _ := d.KeyOf(other, fpe); key == nil || bytes.Equal(key, mustDerive(testRoot, fpe)) {
+		t.Fatalf("Expected the subkey of the other root")
+	}
+}
+
+func mustDerive(root []byte, label Label) []byte {
+	key, err := Derive(root, label)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
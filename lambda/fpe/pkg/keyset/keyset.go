@@ -0,0 +1,339 @@
+// Package keyset implements the versioned set of data encryption keys kept in the
+// data encryption key secret, so the key can be rotated without losing old data.
+//
+// Each key is wrapped by the key manager and carries an ID, a status, a check value and the
+// KMS encryption context it was wrapped with, if any, which unwrapping it requires:
+//   - pending: added by rotation, not used until it is promoted to primary;
+//   - primary: the single key new data is encrypted with;
+//   - active: no longer used for encryption, still used for decryption;
+//   - retired: kept for the record, used for nothing.
+//
+// The document is JSON:
+//
+//	{"version": 1, "keys": [{"id": "3f9c1a2b", "status": "primary", "wrappedKey": "<hex>",
+//	  "checkValue": "<hex>", "createdAt": "2022-04-14T00:00:00Z",
+//	  "encryptionContext": {"service": "fpe-pseudonymization"}, "derivation": "hkdf"}]}
+//
+// Secrets written before keysets existed, holding a hex-encoded wrapped key or a
+// {"wrappedKey", "checkValue"} object, are read as a keyset of one primary key with ID "legacy".
+//
+// A secret created by the deployment holds Uninitialized until its first rotation generates
+// the first key, stored as a keyset of that single primary key.
+package keyset
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Status of a key
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusPrimary Status = "primary"
+	StatusActive  Status = "active"
+	StatusRetired Status = "retired"
+
+	// DocumentVersion is the version of the keyset document written by Encode
+	DocumentVersion = 1
+
+	// LegacyKeyID is the ID of the key of a secret written before keysets existed
+	LegacyKeyID = "legacy"
+
+	// DerivationHKDF marks keys that FF1 only uses through subkeys derived per purpose, see package kdf
+	DerivationHKDF = "hkdf"
+
+	// Uninitialized is the value of a keyset secret created before its first key
+	Uninitialized = "uninitialized"
+
+	// Length in bytes of key check values and of generated key IDs
+	checkValueBytes = 8
+	keyIDBytes      = 4
+)
+
+var (
+	// ErrUnknownKey is returned for key IDs not in the keyset
+	ErrUnknownKey = errors.New("unknown key ID")
+
+	// ErrKeyRetired is returned if a retired key is asked for
+	ErrKeyRetired = errors.New("key is retired")
+
+	// ErrCheckValueMismatch is returned if an unwrapped key does not match its check value
+	ErrCheckValueMismatch = errors.New("data encryption key does not match the stored check value")
+
+	// ErrUninitialized is returned by Parse for Uninitialized, until rotation generates the first key
+	ErrUninitialized = errors.New("keyset is not initialized yet, its first rotation generates the key")
+)
+
+// Key is one wrapped data encryption key
+type Key struct {
+	ID         string    `json:"id"`
+	Status     Status    `json:"status"`
+	WrappedKey string    `json:"wrappedKey"`
+	CheckValue string    `json:"checkValue,omitempty"`
+	CreatedAt  time.Time `json:"createdAt,omitempty"`
+
+	// [2022-05-02] KMS encryption context the key was wrapped with
+	EncryptionContext map[string]string `json:"encryptionContext,omitempty"`
+
+	// [2022-05-05] DerivationHKDF for keys added since FF1 uses subkeys; FF1 uses older keys
+	// as is, so the tokens made with them still decrypt
+	Derivation string `json:"derivation,omitempty"`
+}
+
+// Keyset is the versioned list of keys, oldest first
+type Keyset struct {
+	Version int   `json:"version"`
+	Keys    []Key `json:"keys"`
+}
+
+// New returns a keyset holding a single primary key, wrapped with the encryption context context.
+func New(wrappedKey []byte, dek []byte, context map[string]string) (*Keyset, error) {
+	ks := &Keyset{Version: DocumentVersion}
+	if _, err := ks.Add(wrappedKey, dek, context, StatusPrimary); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Parse decodes and validates a keyset document or a legacy secret.
+func Parse(value string) (*Keyset, error) {
+	value = strings.TrimSpace(value)
+	if value == Uninitialized {
+		return nil, ErrUninitialized
+	}
+
+	var ks Keyset
+	switch {
+	case strings.HasPrefix(value, "{") && strings.Contains(value, `"keys"`):
+		if err := json.Unmarshal([]byte(value), &ks); err != nil {
+			return nil, err
+		}
+
+	case strings.HasPrefix(value, "{"):
+		var legacy struct {
+			WrappedKey string `json:"wrappedKey"`
+			CheckValue string `json:"checkValue"`
+		}
+		if err := json.Unmarshal([]byte(value), &legacy); err != nil {
+			return nil, err
+		}
+		ks = legacyKeyset(legacy.WrappedKey, legacy.CheckValue)
+
+	default:
+		ks = legacyKeyset(value, "")
+	}
+
+	if err := ks.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &ks, nil
+}
+
+func legacyKeyset(wrappedKey string, checkValue string) Keyset {
+	return Keyset{
+		Version: DocumentVersion,
+		Keys: []Key{{
+			ID:         LegacyKeyID,
+			Status:     StatusPrimary,
+			WrappedKey: wrappedKey,
+			CheckValue: checkValue,
+		}},
+	}
+}
+
+// Validate checks that IDs are unique, wrapped keys are hex, exactly one key is primary and at most one is pending.
+func (ks *Keyset) Validate() error {
+	if len(ks.Keys) == 0 {
+		return errors.New("keyset holds no keys")
+	}
+
+	ids := map[string]bool{}
+	primaries, pending := 0, 0
+
+	for _, k := range ks.Keys {
+		if k.ID == "" || ids[k.ID] {
+			return fmt.Errorf("key ID %q is empty or not unique", k.ID)
+		}
+		ids[k.ID] = true
+
+		if wrapped, err := hex.DecodeString(k.WrappedKey); err != nil || len(wrapped) == 0 {
+			return fmt.Errorf("wrapped key of %s is not hex-encoded", k.ID)
+		}
+
+		if k.Derivation != "" && k.Derivation != DerivationHKDF {
+			return fmt.Errorf("key %s has unknown derivation %q", k.ID, k.Derivation)
+		}
+
+		switch k.Status {
+		case StatusPrimary:
+			primaries++
+		case StatusPending:
+			pending++
+		case StatusActive, StatusRetired:
+		default:
+			return fmt.Errorf("key %s has unknown status %q", k.ID, k.Status)
+		}
+	}
+
+	if primaries != 1 {
+		return fmt.Errorf("keyset must have exactly one primary key, has %d", primaries)
+	}
+
+	if pending > 1 {
+		return fmt.Errorf("keyset must have at most one pending key, has %d", pending)
+	}
+
+	return nil
+}
+
+// Encode returns the keyset document.
+func (ks *Keyset) Encode() (string, error) {
+	ks.Version = DocumentVersion
+
+	value, err := json.Marshal(ks)
+	return string(value), err
+}
+
+// Primary returns the primary key.
+func (ks *Keyset) Primary() Key {
+	for _, k := range ks.Keys {
+		if k.Status == StatusPrimary {
+			return k
+		}
+	}
+	return Key{}
+}
+
+// Pending returns the pending key, if any.
+func (ks *Keyset) Pending() (Key, bool) {
+	for _, k := range ks.Keys {
+		if k.Status == StatusPending {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// Promote makes the pending key with the given ID primary and demotes the current primary key to active.
+func (ks *Keyset) Promote(id string) error {
+	k, err := ks.Lookup(id)
+	if err != nil {
+		return err
+	}
+
+	if k.Status != StatusPending {
+		return fmt.Errorf("key %s is %s, not pending", id, k.Status)
+	}
+
+	for i := range ks.Keys {
+		switch {
+		case ks.Keys[i].ID == id:
+			ks.Keys[i].Status = StatusPrimary
+		case ks.Keys[i].Status == StatusPrimary:
+			ks.Keys[i].Status = StatusActive
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns the key with the given ID.
+func (ks *Keyset) Lookup(id string) (Key, error) {
+	for _, k := range ks.Keys {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return Key{}, ErrUnknownKey
+}
+
+// Usable returns the keys that may decrypt, the primary key first.
+func (ks *Keyset) Usable() []Key {
+	keys := []Key{ks.Primary()}
+	for _, k := range ks.Keys {
+		if k.Status == StatusActive {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Add appends a key with a new random ID. Adding a primary key demotes the current one to active.
+func (ks *Keyset) Add(wrappedKey []byte, dek []byte, context map[string]string, status Status) (Key, error) {
+	id, err := newKeyID()
+	if err != nil {
+		return Key{}, err
+	}
+
+	if status == StatusPrimary {
+		for i := range ks.Keys {
+			if ks.Keys[i].Status == StatusPrimary {
+				ks.Keys[i].Status = StatusActive
+			}
+		}
+	}
+
+	k := Key{
+		ID:         id,
+		Status:     status,
+		WrappedKey: hex.EncodeToString(wrappedKey),
+		CheckValue: CheckValue(dek),
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+
+		EncryptionContext: context,
+		Derivation:        DerivationHKDF,
+	}
+	ks.Keys = append(ks.Keys, k)
+
+	return k, nil
+}
+
+// Derives reports whether FF1 uses the key through subkeys only.
+func (k Key) Derives() bool {
+	return k.Derivation == DerivationHKDF
+}
+
+// Wrapped returns the decoded wrapped key.
+func (k Key) Wrapped() []byte {
+	wrapped, _ := hex.DecodeString(k.WrappedKey)
+	return wrapped
+}
+
+// Verify checks that dek is the key k was created with. Keys without a check value,
+// from legacy secrets, cannot be verified and are accepted.
+func (k Key) Verify(dek []byte) error {
+	if k.CheckValue == "" {
+		return nil
+	}
+
+	if !hmac.Equal([]byte(CheckValue(dek)), []byte(strings.ToLower(k.CheckValue))) {
+		return ErrCheckValueMismatch
+	}
+
+	return nil
+}
+
+// CheckValue identifies a key without revealing it: a truncated HMAC of a fixed label.
+func CheckValue(dek []byte) string {
+	mac := hmac.New(sha256.New, dek)
+	mac.Write([]byte("fpe/key-check-value"))
+	return hex.EncodeToString(mac.Sum(nil)[:checkValueBytes])
+}
+
+func newKeyID() (string, error) {
+	id := make([]byte, keyIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,137 @@
+package keyset
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAddAndParse(t *testing.T) {
+	dek1 := bytes.Repeat([]byte{1}, 32)
+	dek2 := bytes.Repeat([]byte{2}, 32)
+
+	ks, err := New([]byte("wrapped-1"), dek1, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	first := ks.Primary()
+
+	second, err := ks.Add([]byte("wrapped-2"), dek2, map[string]string{"service": "fpe"}, StatusPrimary)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	value, err := ks.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	parsed, err := Parse(value)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if parsed.Primary().ID != second.ID {
+		t.Fatalf("Expected %s to be primary, got %s", second.ID, parsed.Primary().ID)
+	}
+
+	if context := parsed.Primary().EncryptionContext; len(context) != 1 || context["service"] != "fpe" {
+		t.Fatalf("Unexpected encryption context %v", context)
+	}
+
+	old, err := parsed.Lookup(first.ID)
+	if err != nil || old.Status != StatusActive {
+		t.Fatalf("Expected the former primary to be active, got %+v (%v)", old, err)
+	}
+
+	if usable := parsed.Usable(); len(usable) != 2 || usable[0].ID != second.ID {
+		t.Fatalf("Unexpected usable keys: %+v", usable)
+	}
+
+	if !bytes.Equal(old.Wrapped(), []byte("wrapped-1")) {
+		t.Fatalf("Unexpected wrapped key %q", old.Wrapped())
+	}
+
+	if old.Verify(dek1) != nil || old.Verify(dek2) != ErrCheckValueMismatch {
+		t.Fatalf("Check value does not identify the key")
+	}
+
+	if _, err := parsed.Lookup("nope"); err != ErrUnknownKey {
+		t.Fatalf("Expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestParseLegacy(t *testing.T) {
+	for _, value := range []string{"00ff", `{"wrappedKey": "00ff", "checkValue": "0102030405060708"}`} {
+		ks, err := Parse(value)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", value, err)
+		}
+
+		if k := ks.Primary(); k.ID != LegacyKeyID || !bytes.Equal(k.Wrapped(), []byte{0, 0xff}) {
+			t.Fatalf("Unexpected legacy key %+v", k)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []string{
+		`{"version": 1, "keys": []}`,
+		`{"version": 1, "keys": [{"id": "a", "status": "active", "wrappedKey": "00"}]}`,
+		`{"version": 1, "keys": [{"id": "a", "status": "primary", "wrappedKey": "00"}, {"id": "a", "status": "active", "wrappedKey": "00"}]}`,
+		`{"version": 1, "keys": [{"id": "a", "status": "primary", "wrappedKey": "zz"}]}`,
+		`{"version": 1, "keys": [{"id": "a", "status": "disabled", "wrappedKey": "00"}]}`,
+		`{"version": 1, "keys": [{"id": "a", "status": "active", "wrappedKey": "00"}, {"id": "b", "status": "pending", "wrappedKey": "00"}]}`,
+	}
+
+	for _, value := range tests {
+		if _, err := Parse(value); err == nil {
+			t.Fatalf("Expected %s to be rejected", value)
+		}
+	}
+}
+
+func TestParseUninitialized(t *testing.T) {
+	if _, err := Parse(Uninitialized); err != ErrUninitialized {
+		t.Fatalf("Expected ErrUninitialized, got %v", err)
+	}
+
+	// Rotation writes the first key as primary; a keyset of a pending key only has none
+	if _, err := Parse(`{"version": 1, "keys": [{"id": "a", "status": "pending", "wrappedKey": "00"}]}`); err == nil {
+		t.Fatalf("Expected a keyset without a primary key to be rejected")
+	}
+}
+
+func TestPromote(t *testing.T) {
+	ks, _ := New([]byte("wrapped-1"), bytes.Repeat([]byte{1}, 32), nil)
+	first := ks.Primary()
+
+	pending, err := ks.Add([]byte("wrapped-2"), bytes.Repeat([]byte{2}, 32), nil, StatusPending)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	if ks.Primary().ID != first.ID || len(ks.Usable()) != 1 {
+		t.Fatalf("A pending key must not be used")
+	}
+
+	if err := ks.Promote(first.ID); err == nil {
+		t.Fatalf("Expected an error promoting a key that is not pending")
+	}
+
+	if err := ks.Promote(pending.ID); err != nil {
+		t.Fatalf("Promote: %v", err)
+	}
+
+	if ks.Primary().ID != pending.ID {
+		t.Fatalf("Expected %s to be primary, got %s", pending.ID, ks.Primary().ID)
+	}
+
+	if _, ok := ks.Pending(); ok {
+		t.Fatalf("No key must be pending after promotion")
+	}
+
+	if err := ks.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
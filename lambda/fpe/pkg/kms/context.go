@@ -0,0 +1,79 @@
+package kms
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EncryptionContext is the KMS encryption context of a data key: key-value pairs bound to the
+// wrapped key, which must be given again to unwrap it. KMS logs them in CloudTrail, and key
+// policies can require them with kms:EncryptionContext conditions.
+type EncryptionContext map[string]string
+
+// ParseEncryptionContext reads comma-separated key=value pairs, e.g. "service=fpe,stage=prod".
+func ParseEncryptionContext(s string) (EncryptionContext, error) {
+	context := EncryptionContext{}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		i := strings.Index(pair, "=")
+		if i <= 0 || i == len(pair)-1 {
+			return nil, fmt.Errorf("invalid encryption context pair %q, must be key=value", pair)
+		}
+		context[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+
+	if len(context) == 0 {
+		return nil, nil
+	}
+
+	return context, context.Validate()
+}
+
+// Validate checks that keys and values are not empty, as KMS requires.
+func (c EncryptionContext) Validate() error {
+	for k, v := range c {
+		if k == "" || v == "" {
+			return errors.New("encryption context keys and values must not be empty")
+		}
+	}
+	return nil
+}
+
+// Equal reports whether c and other hold the same pairs; nil equals empty.
+func (c EncryptionContext) Equal(other EncryptionContext) bool {
+	if len(c) != len(other) {
+		return false
+	}
+	for k, v := range c {
+		if w, ok := other[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// encode returns an unambiguous encoding of the pairs in key order; empty for an empty context.
+func (c EncryptionContext) encode() []byte {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var out []byte
+	var n [binary.MaxVarintLen64]byte
+	for _, k := range keys {
+		for _, s := range []string{k, c[k]} {
+			out = append(out, n[:binary.PutUvarint(n[:], uint64(len(s)))]...)
+			out = append(out, s...)
+		}
+	}
+
+	return out
+}
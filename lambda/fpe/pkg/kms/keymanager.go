@@ -0,0 +1,81 @@
+package kms
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// DataKeyBytes is the size of generated data encryption keys
+const DataKeyBytes = 32
+
+// ErrDecryptFailed is returned if a wrapped key cannot be unwrapped
+var ErrDecryptFailed = errors.New("failed to decrypt data key")
+
+// KeyManager generates data encryption keys wrapped under a master key and unwraps them again.
+// KmsClientImpl implements it with AWS KMS, LocalKeyManager without any AWS dependency.
+//
+// [2022-05-02] The encryption context, which may be nil, is bound to the wrapped key on generation
+// and must be the same on decryption.
+type KeyManager interface {
+	// GenerateDataKey returns a new data key wrapped under the master key keyId
+	GenerateDataKey(keyId string, context EncryptionContext) ([]byte, error)
+
+	// DecryptDataKey unwraps a data key returned by GenerateDataKey with the same context
+	DecryptDataKey(blob []byte, context EncryptionContext) ([]byte, error)
+}
+
+// GenerateDataKey implements KeyManager with KMS GenerateDataKey.
+func (k *KmsClientImpl) GenerateDataKey(keyId string, context EncryptionContext) ([]byte, error) {
+	var response *kms.GenerateDataKeyOutput
+
+	err := k.CallWithRetry(func(impl *kms.KMS) error {
+		var ferr error
+		keyNumberOfBytes := int64(DataKeyBytes)
+		response, ferr = impl.GenerateDataKey(
+			&kms.GenerateDataKeyInput{
+				KeyId:             &keyId,
+				NumberOfBytes:     &keyNumberOfBytes,
+				EncryptionContext: awsContext(context),
+			},
+		)
+		return ferr
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return response.CiphertextBlob, nil
+}
+
+// DecryptDataKey implements KeyManager with KMS Decrypt.
+func (k *KmsClientImpl) DecryptDataKey(blob []byte, context EncryptionContext) ([]byte, error) {
+	var response *kms.DecryptOutput
+
+	err := k.CallWithRetry(func(impl *kms.KMS) error {
+		var ferr error
+		response, ferr = impl.Decrypt(
+			&kms.DecryptInput{
+				CiphertextBlob:    blob,
+				EncryptionContext: awsContext(context),
+			},
+		)
+		return ferr
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Plaintext, nil
+}
+
+// awsContext converts context for the KMS API, which expects no context rather than an empty one.
+func awsContext(context EncryptionContext) map[string]*string {
+	if len(context) == 0 {
+		return nil
+	}
+	return aws.StringMap(context)
+}
@@ -60,46 +60,23 @@ func (k *KmsClientImpl) CallWithRetry(f KmsFunction) error {
 	return err
 }
 
-func (k *KmsClientImpl) DecryptDEK(data []byte) []byte {
-	var response *kms.DecryptOutput
-
-	err := k.CallWithRetry(func(impl *kms.KMS) error {
-		var ferr error
-		response, ferr = impl.Decrypt(
-			&kms.DecryptInput{
-				CiphertextBlob: data,
-			},
-		)
-		return ferr
-	})
-
+// DecryptDEK is DecryptDataKey without the error, nil on failure.
+func (k *KmsClientImpl) DecryptDEK(data []byte, context EncryptionContext) []byte {
+	plaintext, err := k.DecryptDataKey(data, context)
 	if err != nil {
 		return nil
 	}
 
-	return response.Plaintext
+	return plaintext
 }
 
 // Generate FPE data encryption key and return its CiphertextBlob part
-func (k *KmsClientImpl) GenerateDEK(keyId string) []byte {
-	var response *kms.GenerateDataKeyOutput
-
-	err := k.CallWithRetry(func(impl *kms.KMS) error {
-		var ferr error
-		keyNumberOfBytes := int64(32)
-		response, ferr = impl.GenerateDataKey(
-			&kms.GenerateDataKeyInput{
-				KeyId:         &keyId,
-				NumberOfBytes: &keyNumberOfBytes,
-			},
-		)
-		return ferr
-	})
-
+func (k *KmsClientImpl) GenerateDEK(keyId string, context EncryptionContext) []byte {
+	blob, err := k.GenerateDataKey(keyId, context)
 	if err != nil {
 		fmt.Println(err.Error())
 		return nil
 	}
 
-	return response.CiphertextBlob
+	return blob
 }
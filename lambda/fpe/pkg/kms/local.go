@@ -0,0 +1,153 @@
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// Version byte of blobs produced by LocalKeyManager
+	localBlobVersion = 1
+
+	// MasterKeyBytes is the size of a local master key (AES-256)
+	MasterKeyBytes = 32
+)
+
+// Salt used by MasterKeyFromPassphrase if none is given
+var defaultPassphraseSalt = []byte("fpe-local-key-manager")
+
+// LocalKeyManager wraps data keys with AES-256-GCM under a local master key, so the service
+// can run without AWS. The key ID is bound to the blob as additional authenticated data,
+// and so is the encryption context, which is not stored in the blob.
+//
+// Blob layout: version (1) || len(keyId) (1) || keyId || nonce (12) || sealed key
+type LocalKeyManager struct {
+	aead cipher.AEAD
+}
+
+// NewLocalKeyManager returns a key manager using masterKey, which must be 32 bytes long.
+func NewLocalKeyManager(masterKey []byte) (*LocalKeyManager, error) {
+	if len(masterKey) != MasterKeyBytes {
+		return nil, errors.New("master key must be 32 bytes long")
+	}
+
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalKeyManager{aead: aead}, nil
+}
+
+// NewMemoryKeyManager returns a local key manager with a random master key that only
+// lives as long as the process; meant for tests.
+func NewMemoryKeyManager() *LocalKeyManager {
+	masterKey := make([]byte, MasterKeyBytes)
+	if _, err := io.ReadFull(rand.Reader, masterKey); err != nil {
+		panic(err)
+	}
+
+	m, _ := NewLocalKeyManager(masterKey)
+	return m
+}
+
+// MasterKeyFromFile reads a master key stored as 64 hex characters or as 32 raw bytes.
+func MasterKeyFromFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if text := strings.TrimSpace(string(data)); len(text) == 2*MasterKeyBytes {
+		if key, err := hex.DecodeString(text); err == nil {
+			return key, nil
+		}
+	}
+
+	if len(data) != MasterKeyBytes {
+		return nil, errors.New("master key file must hold 32 bytes or 64 hex characters")
+	}
+
+	return data, nil
+}
+
+// MasterKeyFromPassphrase derives a master key from a passphrase with scrypt.
+// A nil salt selects a fixed salt, which is only acceptable for development.
+func MasterKeyFromPassphrase(passphrase string, salt []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase must not be empty")
+	}
+
+	if salt == nil {
+		salt = defaultPassphraseSalt
+	}
+
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, MasterKeyBytes)
+}
+
+// GenerateDataKey implements KeyManager.
+func (m *LocalKeyManager) GenerateDataKey(keyId string, context EncryptionContext) ([]byte, error) {
+	if len(keyId) > 255 {
+		return nil, errors.New("key ID is too long")
+	}
+
+	if err := context.Validate(); err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, DataKeyBytes)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte{localBlobVersion, byte(len(keyId))}, keyId...)
+
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	blob := append(header, nonce...)
+	return m.aead.Seal(blob, nonce, dataKey, additionalData(header, context)), nil
+}
+
+// DecryptDataKey implements KeyManager.
+func (m *LocalKeyManager) DecryptDataKey(blob []byte, context EncryptionContext) ([]byte, error) {
+	if len(blob) < 2 || blob[0] != localBlobVersion {
+		return nil, ErrDecryptFailed
+	}
+
+	headerLength := 2 + int(blob[1])
+	if len(blob) < headerLength+m.aead.NonceSize()+m.aead.Overhead() {
+		return nil, ErrDecryptFailed
+	}
+
+	header := blob[:headerLength]
+	nonce := blob[headerLength : headerLength+m.aead.NonceSize()]
+
+	dataKey, err := m.aead.Open(nil, nonce, blob[headerLength+m.aead.NonceSize():], additionalData(header, context))
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+
+	return dataKey, nil
+}
+
+// additionalData authenticates the blob header and the encryption context. Without a context it
+// is the header alone, so blobs generated before contexts existed still decrypt.
+func additionalData(header []byte, context EncryptionContext) []byte {
+	return append(append([]byte(nil), header...), context.encode()...)
+}
@@ -0,0 +1,100 @@
+package kms
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalKeyManager(t *testing.T) {
+	m := NewMemoryKeyManager()
+
+	blob, err := m.GenerateDataKey("alias/fpe", nil)
+	if err != nil {
+		t.Fatalf("GenerateDataKey: %v", err)
+	}
+
+	key, err := m.DecryptDataKey(blob, nil)
+	if err != nil {
+		t.Fatalf("DecryptDataKey: %v", err)
+	}
+
+	if len(key) != DataKeyBytes {
+		t.Fatalf("Expected a %d byte data key, got %d", DataKeyBytes, len(key))
+	}
+
+	again, _ := m.DecryptDataKey(blob, nil)
+	if !bytes.Equal(key, again) {
+		t.Fatalf("DecryptDataKey is not deterministic")
+	}
+
+	// The key ID is authenticated
+	tampered := append([]byte(nil), blob...)
+	tampered[3] ^= 1
+	if _, err := m.DecryptDataKey(tampered, nil); err != ErrDecryptFailed {
+		t.Fatalf("Expected ErrDecryptFailed for a tampered blob, got %v", err)
+	}
+
+	if _, err := NewMemoryKeyManager().DecryptDataKey(blob, nil); err != ErrDecryptFailed {
+		t.Fatalf("Expected ErrDecryptFailed under another master key, got %v", err)
+	}
+}
+
+func TestEncryptionContext(t *testing.T) {
+	m := NewMemoryKeyManager()
+	context := EncryptionContext{"service": "fpe", "secretName": "/secret/fpe/dek"}
+
+	blob, err := m.GenerateDataKey("alias/fpe", context)
+	if err != nil {
+		t.Fatalf("GenerateDataKey: %v", err)
+	}
+
+	if _, err := m.DecryptDataKey(blob, EncryptionContext{"secretName": "/secret/fpe/dek", "service": "fpe"}); err != nil {
+		t.Fatalf("DecryptDataKey: %v", err)
+	}
+
+	for _, other := range []EncryptionContext{nil, {"service": "fpe"}, {"service": "fpe", "secretName": "/secret/other"}} {
+		if _, err := m.DecryptDataKey(blob, other); err != ErrDecryptFailed {
+			t.Fatalf("Expected ErrDecryptFailed with context %v, got %v", other, err)
+		}
+	}
+
+	parsed, err := ParseEncryptionContext(" service=fpe, secretName=/secret/fpe/dek ")
+	if err != nil || !parsed.Equal(context) {
+		t.Fatalf("ParseEncryptionContext: %v, %v", parsed, err)
+	}
+
+	for _, s := range []string{"service", "=fpe", "service="} {
+		if _, err := ParseEncryptionContext(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
+
+func TestMasterKeySources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(path, []byte(strings.Repeat("ab", MasterKeyBytes)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := MasterKeyFromFile(path)
+	if err != nil || len(key) != MasterKeyBytes || key[0] != 0xab {
+		t.Fatalf("MasterKeyFromFile: %x, %v", key, err)
+	}
+
+	a, err := MasterKeyFromPassphrase("correct horse battery staple", nil)
+	if err != nil {
+		t.Fatalf("MasterKeyFromPassphrase: %v", err)
+	}
+
+	b, _ := MasterKeyFromPassphrase("correct horse battery staple", nil)
+	if !bytes.Equal(a, b) {
+		t.Fatalf("Passphrase derivation is not deterministic")
+	}
+
+	if _, err := MasterKeyFromPassphrase("", nil); err == nil {
+		t.Fatalf("Expected an error for an empty passphrase")
+	}
+}
@@ -0,0 +1,188 @@
+// Package longfpe encrypts long values (e.g. multi-kilobyte free-text fields) with FF1
+// by splitting them into fixed-size blocks of numerals.
+//
+// FF1's big integer arithmetic is quadratic in the input length, so each block is
+// encrypted separately with a tweak bound to the block index and to a digest of its
+// neighbour. Two passes are made: a forward pass chaining each block to the previous
+// ciphertext block, and a backward pass chaining each block to the next one. After both
+// passes every output block depends on the whole input, so identical blocks do not
+// repeat, while the output length and alphabet are preserved and decryption is exact.
+package longfpe
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ff1"
+)
+
+const (
+	// DefaultBlockSize is used if no positive block size is given
+	DefaultBlockSize = 64
+
+	// Same bound FF1 uses to derive its minimum message length
+	feistelMin = 100
+
+	// Length of the per-block tweak suffix: pass (1) || index (4) || neighbour digest (8)
+	digestLen     = 8
+	chainTweakLen = 1 + 4 + digestLen
+	passForward   = 0x01
+	passBackward  = 0x02
+	maxBlockSize  = 1 << 16
+)
+
+// ErrBlockSizeInvalid is returned if the block size is too small for the radix or unreasonably large
+var ErrBlockSizeInvalid = errors.New("block size must be at least the FF1 minimum length for the radix")
+
+// A Cipher encrypts long values block by block with chained tweaks
+type Cipher struct {
+	ff1       ff1.Cipher
+	tweak     []byte
+	blockSize int
+	minLen    int
+}
+
+// NewCipher initializes a long-value cipher for the radix, block size, key and base tweak.
+// A non-positive blockSize selects DefaultBlockSize.
+func NewCipher(radix int, blockSize int, key []byte, tweak []byte) (*Cipher, error) {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+
+	FF1, err := ff1.NewCipher(radix, len(tweak)+chainTweakLen, key, tweak)
+	if err != nil {
+		return nil, err
+	}
+
+	minLen := int(math.Ceil(math.Log(feistelMin) / math.Log(float64(radix))))
+	if blockSize < minLen || blockSize > maxBlockSize {
+		return nil, ErrBlockSizeInvalid
+	}
+
+	return &Cipher{
+		ff1:       FF1,
+		tweak:     append([]byte(nil), tweak...),
+		blockSize: blockSize,
+		minLen:    minLen,
+	}, nil
+}
+
+// BlockSize returns the number of numerals per block.
+func (c *Cipher) BlockSize() int {
+	return c.blockSize
+}
+
+// Encrypt encrypts X and returns a ciphertext of the same length and alphabet
+func (c *Cipher) Encrypt(X string) (string, error) {
+	blocks := c.split(X)
+	k := len(blocks)
+
+	// Forward pass, each block chained to the previous ciphertext block
+	for i := 0; i < k; i++ {
+		var prev string
+		if i > 0 {
+			prev = blocks[i-1]
+		}
+
+		out, err := c.ff1.EncryptWithTweak(blocks[i], c.blockTweak(passForward, i, prev))
+		if err != nil {
+			return "", err
+		}
+		blocks[i] = out
+	}
+
+	// Backward pass, each block chained to the next ciphertext block
+	for i := k - 1; i >= 0; i-- {
+		var next string
+		if i < k-1 {
+			next = blocks[i+1]
+		}
+
+		out, err := c.ff1.EncryptWithTweak(blocks[i], c.blockTweak(passBackward, i, next))
+		if err != nil {
+			return "", err
+		}
+		blocks[i] = out
+	}
+
+	return strings.Join(blocks, ""), nil
+}
+
+// Decrypt reverses Encrypt
+func (c *Cipher) Decrypt(X string) (string, error) {
+	blocks := c.split(X)
+	k := len(blocks)
+
+	// Undo the backward pass; the neighbours are still in ciphertext form when
+	// walking from the front
+	for i := 0; i < k; i++ {
+		var next string
+		if i < k-1 {
+			next = blocks[i+1]
+		}
+
+		out, err := c.ff1.DecryptWithTweak(blocks[i], c.blockTweak(passBackward, i, next))
+		if err != nil {
+			return "", err
+		}
+		blocks[i] = out
+	}
+
+	// Undo the forward pass; walk from the back for the same reason
+	for i := k - 1; i >= 0; i-- {
+		var prev string
+		if i > 0 {
+			prev = blocks[i-1]
+		}
+
+		out, err := c.ff1.DecryptWithTweak(blocks[i], c.blockTweak(passForward, i, prev))
+		if err != nil {
+			return "", err
+		}
+		blocks[i] = out
+	}
+
+	return strings.Join(blocks, ""), nil
+}
+
+// split cuts X into blocks of blockSize numerals. A trailing block shorter than the
+// FF1 minimum length is merged into its predecessor.
+func (c *Cipher) split(X string) []string {
+	var blocks []string
+	for start := 0; start < len(X); start += c.blockSize {
+		end := start + c.blockSize
+		if end > len(X) {
+			end = len(X)
+		}
+		blocks = append(blocks, X[start:end])
+	}
+
+	if k := len(blocks); k > 1 && len(blocks[k-1]) < c.minLen {
+		blocks[k-2] += blocks[k-1]
+		blocks = blocks[:k-1]
+	}
+
+	// Let FF1 reject empty and too short input
+	if len(blocks) == 0 {
+		blocks = append(blocks, X)
+	}
+
+	return blocks
+}
+
+// blockTweak returns base tweak || pass || index || digest(neighbour)
+func (c *Cipher) blockTweak(pass byte, index int, neighbour string) []byte {
+	tweak := make([]byte, len(c.tweak)+chainTweakLen)
+	n := copy(tweak, c.tweak)
+
+	tweak[n] = pass
+	binary.BigEndian.PutUint32(tweak[n+1:n+5], uint32(index))
+
+	digest := sha256.Sum256([]byte(neighbour))
+	copy(tweak[n+5:], digest[:digestLen])
+
+	return tweak
+}
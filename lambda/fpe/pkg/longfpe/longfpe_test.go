@@ -0,0 +1,134 @@
+package longfpe
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func newTestCipher(t *testing.T, radix int, blockSize int) *Cipher {
+	key, err := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94")
+	if err != nil {
+		t.Fatalf("Unable to decode hex key: %v", err)
+	}
+
+	tweak, err := hex.DecodeString("D8E7920AFA330A73")
+	if err != nil {
+		t.Fatalf("Unable to decode tweak: %v", err)
+	}
+
+	c, err := NewCipher(radix, blockSize, key, tweak)
+	if err != nil {
+		t.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	return c
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := newTestCipher(t, 36, 0)
+
+	// 4 KiB of radix-36 numerals with a short trailing block
+	plaintext := strings.Repeat("thequickbrownfox0123456789", 158)[:4097]
+
+	ciphertext, err := c.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if len(ciphertext) != len(plaintext) {
+		t.Fatalf("Length not preserved: %d != %d", len(ciphertext), len(plaintext))
+	}
+
+	decrypted, err := c.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if decrypted != plaintext {
+		t.Fatalf("Long round trip failed.\nExpected: %v\nGot: %v", plaintext, decrypted)
+	}
+}
+
+func TestIdenticalBlocksDiffer(t *testing.T) {
+	c := newTestCipher(t, 10, 16)
+
+	block := "0123456789012345"
+	ciphertext, err := c.Encrypt(strings.Repeat(block, 4))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		b := ciphertext[i*16 : (i+1)*16]
+		if seen[b] {
+			t.Fatalf("Identical plaintext blocks produced identical ciphertext block %q", b)
+		}
+		seen[b] = true
+	}
+}
+
+// Changing the last numeral must change the first ciphertext block thanks to the backward pass
+func TestDiffusion(t *testing.T) {
+	c := newTestCipher(t, 10, 16)
+
+	a, err := c.Encrypt(strings.Repeat("1", 64))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	b, err := c.Encrypt(strings.Repeat("1", 63) + "2")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if a[:16] == b[:16] {
+		t.Fatalf("First block did not change: %q", a[:16])
+	}
+}
+
+func TestShortInputs(t *testing.T) {
+	c := newTestCipher(t, 10, 16)
+
+	for _, plaintext := range []string{"12", "1234567890123456", "12345678901234567"} {
+		ciphertext, err := c.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", plaintext, err)
+		}
+
+		decrypted, err := c.Decrypt(ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt(%q): %v", ciphertext, err)
+		}
+
+		if decrypted != plaintext {
+			t.Fatalf("Round trip failed.\nExpected: %v\nGot: %v", plaintext, decrypted)
+		}
+	}
+
+	if _, err := c.Encrypt("1"); err == nil {
+		t.Fatalf("Expected an error for input below the FF1 minimum length")
+	}
+}
+
+func TestBlockSizeInvalid(t *testing.T) {
+	if _, err := NewCipher(10, 1, make([]byte, 16), nil); err != ErrBlockSizeInvalid {
+		t.Fatalf("Expected ErrBlockSizeInvalid, got %v", err)
+	}
+}
+
+func BenchmarkEncrypt4K(b *testing.B) {
+	c, err := NewCipher(36, 0, make([]byte, 32), nil)
+	if err != nil {
+		b.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	plaintext := strings.Repeat("thequickbrownfox0123456789", 158)
+
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		c.Encrypt(plaintext)
+	}
+}
@@ -0,0 +1,198 @@
+// Package marker makes FPE tokens recognizable, so that already-pseudonymized values
+// are not encrypted twice and values that are not tokens are not "decrypted".
+//
+// Two schemes are offered:
+//   - prefix: the token is the ciphertext preceded by a reserved prefix that real data
+//     never starts with. Detection is exact as long as that assumption holds; the token
+//     is len(prefix) characters longer than the value.
+//   - check: the token is the ciphertext followed by CheckLength keyed check characters
+//     from the radix alphabet, so it stays within the format of the data type apart from
+//     its length. Real data is mistaken for a token with probability radix^-CheckLength;
+//     only the key holder can forge or verify the check characters.
+//
+// Neither scheme can keep the length of the value: there are radix^CheckLength times fewer
+// tokens than values of a given length, so a reversible token must be longer. Types whose
+// values have a fixed length need the extra characters in their format.
+//
+// A value mistaken for a token is not encrypted, so the default check length keeps that
+// below one in a million and tokens are rejected by /encrypt unless OnToken is "skip".
+package marker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+const (
+	SchemePrefix = "prefix"
+	SchemeCheck  = "check"
+
+	// What to do when /encrypt is given a value that already is a token
+	OnTokenSkip   = "skip"
+	OnTokenReject = "reject"
+
+	// Spec.CheckLength defaults to the fewest check characters with at least this many values,
+	// e.g. 6 for radix 10 and 4 for radix 36
+	minCheckValues = 1000000
+
+	// Upper bound for Spec.CheckLength, enough for radix 2
+	maxCheckLength = 24
+
+	// Digits as used by FF1 (math/big) for radices up to 62
+	digits = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+var (
+	// ErrUnknownScheme is returned for schemes other than prefix and check
+	ErrUnknownScheme = errors.New("unknown marker scheme, must be prefix or check")
+
+	// ErrInvalidSpec is returned for inconsistent parameters
+	ErrInvalidSpec = errors.New("invalid marker specification")
+)
+
+// Spec configures the marker of one data type
+type Spec struct {
+	Scheme      string `json:"scheme"`
+	Prefix      string `json:"prefix,omitempty"`
+	CheckLength int    `json:"checkLength,omitempty"`
+
+	// "reject" (default) fails the request if /encrypt is given a token, "skip" returns it unchanged
+	OnToken string `json:"onToken,omitempty"`
+}
+
+// Validate checks that the parameters are consistent with the scheme.
+func (s Spec) Validate() error {
+	switch s.Scheme {
+	case SchemePrefix:
+		if s.Prefix == "" {
+			return ErrInvalidSpec
+		}
+	case SchemeCheck:
+		if s.CheckLength < 0 || s.CheckLength > maxCheckLength {
+			return ErrInvalidSpec
+		}
+	default:
+		return ErrUnknownScheme
+	}
+
+	switch s.OnToken {
+	case "", OnTokenSkip, OnTokenReject:
+	default:
+		return ErrInvalidSpec
+	}
+
+	return nil
+}
+
+// Rejects reports whether tokens given to /encrypt must be rejected rather than skipped.
+func (s Spec) Rejects() bool {
+	return s.OnToken != OnTokenSkip
+}
+
+// A Scheme marks ciphertexts as tokens and recognizes them again
+type Scheme interface {
+	// Mark turns a ciphertext into a token
+	Mark(ciphertext string) string
+
+	// Unmark returns the ciphertext inside a token, or false if value is not a token
+	Unmark(value string) (string, bool)
+}
+
+// New returns the scheme described by spec for ciphertexts in the given radix.
+// The key is only used by the check scheme.
+func New(spec Spec, key []byte, radix int) (Scheme, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	if spec.Scheme == SchemePrefix {
+		return prefixScheme{prefix: spec.Prefix}, nil
+	}
+
+	if radix < 2 || radix > len(digits) {
+		return nil, ErrInvalidSpec
+	}
+
+	if len(key) < 16 {
+		return nil, errors.New("key must be at least 128 bits")
+	}
+
+	length := spec.CheckLength
+	if length == 0 {
+		length = defaultCheckLength(radix)
+	}
+
+	return checkScheme{key: append([]byte(nil), key...), alphabet: digits[:radix], length: length}, nil
+}
+
+// defaultCheckLength returns the fewest check characters of the radix with at least
+// minCheckValues values.
+func defaultCheckLength(radix int) int {
+	length := 1
+	for values := radix; values < minCheckValues; values *= radix {
+		length++
+	}
+	return length
+}
+
+type prefixScheme struct {
+	prefix string
+}
+
+func (p prefixScheme) Mark(ciphertext string) string {
+	return p.prefix + ciphertext
+}
+
+func (p prefixScheme) Unmark(value string) (string, bool) {
+	if !strings.HasPrefix(value, p.prefix) {
+		return "", false
+	}
+	return value[len(p.prefix):], true
+}
+
+type checkScheme struct {
+	key      []byte
+	alphabet string
+	length   int
+}
+
+func (c checkScheme) Mark(ciphertext string) string {
+	return ciphertext + c.check(ciphertext)
+}
+
+func (c checkScheme) Unmark(value string) (string, bool) {
+	if len(value) <= c.length {
+		return "", false
+	}
+
+	ciphertext, check := value[:len(value)-c.length], value[len(value)-c.length:]
+	if !hmac.Equal([]byte(check), []byte(c.check(ciphertext))) {
+		return "", false
+	}
+
+	return ciphertext, true
+}
+
+// check derives the check characters from HMAC-SHA256(key, ciphertext || block),
+// using 64 bits per character to keep the modulo bias negligible
+func (c checkScheme) check(ciphertext string) string {
+	out := make([]byte, c.length)
+
+	var digest []byte
+	for i := range out {
+		if i%4 == 0 {
+			mac := hmac.New(sha256.New, c.key)
+			mac.Write([]byte(ciphertext))
+			mac.Write([]byte{byte(i / 4)})
+			digest = mac.Sum(nil)
+		}
+
+		v := binary.BigEndian.Uint64(digest[(i%4)*8:])
+		out[i] = c.alphabet[v%uint64(len(c.alphabet))]
+	}
+
+	return string(out)
+}
@@ -0,0 +1,105 @@
+package marker
+
+import (
+	"fmt"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestPrefix(t *testing.T) {
+	s, err := New(Spec{Scheme: SchemePrefix, Prefix: "T9"}, nil, 10)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	token := s.Mark("2433477484")
+	if token != "T92433477484" {
+		t.Fatalf("Unexpected token %q", token)
+	}
+
+	ciphertext, ok := s.Unmark(token)
+	if !ok || ciphertext != "2433477484" {
+		t.Fatalf("Unmark(%q) = %q, %v", token, ciphertext, ok)
+	}
+
+	if _, ok := s.Unmark("0123456789"); ok {
+		t.Fatalf("Plain value recognized as token")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s, err := New(Spec{Scheme: SchemeCheck, CheckLength: 2}, testKey, 10)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	token := s.Mark("2433477484")
+	if len(token) != 12 {
+		t.Fatalf("Unexpected token %q", token)
+	}
+
+	ciphertext, ok := s.Unmark(token)
+	if !ok || ciphertext != "2433477484" {
+		t.Fatalf("Unmark(%q) = %q, %v", token, ciphertext, ok)
+	}
+
+	// With two check digits about 1 in 100 random values is mistaken for a token
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if _, ok := s.Unmark(fmt.Sprintf("%012d", i*7919)); ok {
+			falsePositives++
+		}
+	}
+	if falsePositives > 200 {
+		t.Fatalf("Too many false positives: %d", falsePositives)
+	}
+
+	// Another key does not recognize the token
+	other, _ := New(Spec{Scheme: SchemeCheck, CheckLength: 8}, []byte("fedcba9876543210fedcba9876543210"), 10)
+	if _, ok := other.Unmark(s.Mark("2433477484")); ok {
+		t.Fatalf("Token recognized under a different key")
+	}
+}
+
+func TestCheckAlphabet(t *testing.T) {
+	s, _ := New(Spec{Scheme: SchemeCheck, CheckLength: 8}, testKey, 16)
+
+	token := s.Mark("00ff")
+	for _, c := range token[4:] {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			t.Fatalf("Check character %q outside radix 16 in %q", c, token)
+		}
+	}
+}
+
+func TestCheckDefaults(t *testing.T) {
+	for radix, length := range map[int]int{2: 20, 10: 6, 16: 5, 36: 4, 62: 4} {
+		s, err := New(Spec{Scheme: SchemeCheck}, testKey, radix)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if token := s.Mark("0101"); len(token) != 4+length {
+			t.Fatalf("Expected %d check characters for radix %d, got %q", length, radix, token)
+		}
+	}
+
+	if !(Spec{Scheme: SchemeCheck}).Rejects() || (Spec{Scheme: SchemeCheck, OnToken: OnTokenSkip}).Rejects() {
+		t.Fatalf("Tokens must be rejected unless skipping is requested")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []Spec{
+		{Scheme: "watermark"},
+		{Scheme: SchemePrefix},
+		{Scheme: SchemeCheck, CheckLength: 25},
+		{Scheme: SchemeCheck, OnToken: "ignore"},
+	}
+
+	for _, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", spec)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Package masking implements irreversible, format-aware masking and redaction transforms.
+//
+// Letters and digits are the maskable characters of a value; separators such as
+// '-', ' ', '.' or '@' always stay in place, so "1234-5678-9012-3456" masked with
+// keepLast=4 becomes "****-****-****-3456".
+package masking
+
+import (
+	"errors"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Type selects the masking transform
+type Type string
+
+const (
+	// TypePartial masks every maskable character except the first KeepFirst and last KeepLast ones
+	TypePartial Type = "partial"
+
+	// TypeReplace masks every maskable character
+	TypeReplace Type = "replace"
+
+	// TypeNull removes the value altogether
+	TypeNull Type = "null"
+
+	// TypeConstant substitutes the whole value with Constant
+	TypeConstant Type = "constant"
+
+	// DefaultMaskChar is used if MaskChar is empty
+	DefaultMaskChar = "*"
+)
+
+var (
+	// ErrUnknownType is returned for unknown masking types
+	ErrUnknownType = errors.New("unknown masking type, must be partial, replace, null or constant")
+
+	// ErrInvalidSpec is returned for inconsistent parameters
+	ErrInvalidSpec = errors.New("invalid masking specification")
+)
+
+// A Spec describes one masking transform
+type Spec struct {
+	Type      Type   `json:"type"`
+	KeepFirst int    `json:"keepFirst,omitempty"`
+	KeepLast  int    `json:"keepLast,omitempty"`
+	MaskChar  string `json:"maskChar,omitempty"`
+	Constant  string `json:"constant,omitempty"`
+}
+
+// Validate checks that the parameters are consistent with the type.
+func (s Spec) Validate() error {
+	switch s.Type {
+	case TypePartial:
+		if s.KeepFirst < 0 || s.KeepLast < 0 {
+			return ErrInvalidSpec
+		}
+	case TypeReplace, TypeNull, TypeConstant:
+	default:
+		return ErrUnknownType
+	}
+
+	if s.MaskChar != "" && utf8.RuneCountInString(s.MaskChar) != 1 {
+		return ErrInvalidSpec
+	}
+
+	return nil
+}
+
+// Nulls reports whether the transform removes the value, i.e. Apply's result must be treated as null.
+func (s Spec) Nulls() bool {
+	return s.Type == TypeNull
+}
+
+// Apply masks value. For TypeNull the result is the empty string; use Nulls to tell it apart.
+func (s Spec) Apply(value string) (string, error) {
+	if err := s.Validate(); err != nil {
+		return "", err
+	}
+
+	switch s.Type {
+	case TypeNull:
+		return "", nil
+
+	case TypeConstant:
+		return s.Constant, nil
+
+	case TypeReplace:
+		return s.mask(value, 0, 0), nil
+
+	default:
+		return s.mask(value, s.KeepFirst, s.KeepLast), nil
+	}
+}
+
+// mask replaces maskable characters with the mask character, except for the first
+// keepFirst and the last keepLast of them.
+func (s Spec) mask(value string, keepFirst int, keepLast int) string {
+	maskChar := s.MaskChar
+	if maskChar == "" {
+		maskChar = DefaultMaskChar
+	}
+	maskRune, _ := utf8.DecodeRuneInString(maskChar)
+
+	runes := []rune(value)
+
+	total := 0
+	for _, r := range runes {
+		if Maskable(r) {
+			total++
+		}
+	}
+
+	index := 0
+	for i, r := range runes {
+		if !Maskable(r) {
+			continue
+		}
+
+		if index >= keepFirst && index < total-keepLast {
+			runes[i] = maskRune
+		}
+		index++
+	}
+
+	return string(runes)
+}
+
+// Maskable reports whether r carries data (letter or digit) rather than formatting.
+func Maskable(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,49 @@
+package masking
+
+import "testing"
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		spec     Spec
+		input    string
+		expected string
+	}{
+		{Spec{Type: TypePartial, KeepLast: 4}, "1234-5678-9012-3456", "****-****-****-3456"},
+		{Spec{Type: TypePartial, KeepFirst: 6, KeepLast: 4}, "1234 5678 9012 3456", "1234 56** **** 3456"},
+		{Spec{Type: TypePartial, KeepFirst: 1, MaskChar: "x"}, "john.doe@example.com", "jxxx.xxx@xxxxxxx.xxx"},
+		{Spec{Type: TypePartial, KeepFirst: 10, KeepLast: 10}, "010-1234", "010-1234"},
+		{Spec{Type: TypePartial, KeepLast: 1}, "홍길동", "**동"},
+		{Spec{Type: TypeReplace, MaskChar: "#"}, "901231-1234567", "######-#######"},
+		{Spec{Type: TypeConstant, Constant: "REDACTED"}, "secret", "REDACTED"},
+		{Spec{Type: TypeNull}, "secret", ""},
+	}
+
+	for _, test := range tests {
+		masked, err := test.spec.Apply(test.input)
+		if err != nil {
+			t.Fatalf("%+v: %v", test.spec, err)
+		}
+
+		if masked != test.expected {
+			t.Fatalf("%+v applied to %q:\nExpected: %v\nGot: %v", test.spec, test.input, test.expected, masked)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []Spec{
+		{Type: "scramble"},
+		{Type: TypePartial, KeepLast: -1},
+		{Type: TypeReplace, MaskChar: "**"},
+	}
+
+	for _, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", spec)
+		}
+	}
+
+	if !(Spec{Type: TypeNull}).Nulls() {
+		t.Fatalf("Null spec must report Nulls")
+	}
+}
@@ -0,0 +1,97 @@
+package ope
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	// DateLayout is the textual format of the date domain
+	DateLayout = "2006-01-02"
+
+	secondsPerDay = 24 * 60 * 60
+)
+
+var (
+	// ErrUnknownDomain is returned for domain names other than "integer" and "date"
+	ErrUnknownDomain = errors.New("unknown OPE domain, must be integer or date")
+
+	epoch = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// A Domain maps textual values onto [0, 2^bits) preserving their order.
+type Domain interface {
+	Name() string
+	Encode(value string) (uint64, error)
+	Decode(m uint64) string
+}
+
+// LookupDomain returns the domain with the given name over 2^bits points.
+func LookupDomain(name string, bits uint) (Domain, error) {
+	switch name {
+	case "integer":
+		return IntegerDomain{Bits: bits}, nil
+	case "date":
+		return DateDomain{Bits: bits}, nil
+	default:
+		return nil, ErrUnknownDomain
+	}
+}
+
+// IntegerDomain holds signed integers in [-2^(Bits-1), 2^(Bits-1)).
+type IntegerDomain struct {
+	Bits uint
+}
+
+func (d IntegerDomain) Name() string {
+	return "integer"
+}
+
+func (d IntegerDomain) Encode(value string) (uint64, error) {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return offset(v, d.Bits)
+}
+
+func (d IntegerDomain) Decode(m uint64) string {
+	return strconv.FormatInt(unoffset(m, d.Bits), 10)
+}
+
+// DateDomain holds calendar dates (YYYY-MM-DD) as days relative to 1970-01-01.
+type DateDomain struct {
+	Bits uint
+}
+
+func (d DateDomain) Name() string {
+	return "date"
+}
+
+func (d DateDomain) Encode(value string) (uint64, error) {
+	t, err := time.Parse(DateLayout, value)
+	if err != nil {
+		return 0, err
+	}
+
+	// Parsed dates are midnight UTC, so the division is exact
+	return offset(t.Unix()/secondsPerDay, d.Bits)
+}
+
+func (d DateDomain) Decode(m uint64) string {
+	return epoch.AddDate(0, 0, int(unoffset(m, d.Bits))).Format(DateLayout)
+}
+
+// offset shifts a signed value into [0, 2^bits) keeping the order
+func offset(v int64, bits uint) (uint64, error) {
+	half := int64(1) << (bits - 1)
+	if v < -half || v >= half {
+		return 0, ErrOutOfDomain
+	}
+	return uint64(v + half), nil
+}
+
+func unoffset(m uint64, bits uint) int64 {
+	return int64(m) - int64(1)<<(bits-1)
+}
@@ -0,0 +1,276 @@
+// Package ope implements order-preserving encryption following Boldyreva, Chenette,
+// Lee and O'Neill, "Order-Preserving Symmetric Encryption" (EUROCRYPT 2009).
+//
+// Encryption lazily samples a random order-preserving function from a domain of
+// 2^domainBits integers into a range of 2^(domainBits+ExpansionBits) integers. The
+// range is halved recursively; at each step the number of domain points mapped into
+// the lower half is drawn from the hypergeometric distribution, using coins generated
+// by HMAC-SHA256 over the current node, so the same key always yields the same function.
+//
+// Leakage profile. OPE is deterministic and order-revealing by design:
+//   - equality and the total order of all plaintexts are revealed to anyone holding ciphertexts;
+//   - ciphertexts leak approximate plaintext values: for a random order-preserving function
+//     roughly the upper half of the plaintext bits can be recovered, and the distance between
+//     two ciphertexts approximates the distance between their plaintexts;
+//   - frequency analysis applies, exactly as for any deterministic scheme.
+//
+// Only use it for columns where range queries are required and the above is acceptable,
+// never for identifiers (use FF1 there). For large ranges the hypergeometric distribution is
+// approximated by a normal distribution clamped to its support; this keeps the scheme
+// correct and order-preserving and only slightly perturbs the distribution of the sampled function.
+package ope
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math"
+)
+
+const (
+	// DefaultDomainBits is the largest domain supported with the default expansion
+	DefaultDomainBits = 47
+
+	// ExpansionBits is the number of bits the range is larger than the domain
+	ExpansionBits = 16
+
+	// Populations up to this size are sampled exactly
+	exactSampleLimit = 64
+
+	// Tags separating coins for inner nodes and leaves
+	tagNode = 0x00
+	tagLeaf = 0x01
+)
+
+var (
+	// ErrDomainBitsInvalid is returned if the domain does not fit the 63-bit range
+	ErrDomainBitsInvalid = errors.New("domain bits must be between 1 and 47, inclusive")
+
+	// ErrOutOfDomain is returned if the plaintext is not in [0, 2^domainBits)
+	ErrOutOfDomain = errors.New("plaintext is out of the domain")
+
+	// ErrInvalidCiphertext is returned if the ciphertext is not the image of any plaintext
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+)
+
+// A Cipher is an order-preserving encryption scheme keyed for one domain size
+type Cipher struct {
+	key        []byte
+	domainBits uint
+	rangeBits  uint
+}
+
+// NewCipher initializes an OPE cipher over [0, 2^domainBits) with the given key.
+func NewCipher(key []byte, domainBits uint) (*Cipher, error) {
+	if len(key) == 0 {
+		return nil, errors.New("key must not be empty")
+	}
+
+	if domainBits < 1 || domainBits > DefaultDomainBits {
+		return nil, ErrDomainBitsInvalid
+	}
+
+	return &Cipher{
+		key:        append([]byte(nil), key...),
+		domainBits: domainBits,
+		rangeBits:  domainBits + ExpansionBits,
+	}, nil
+}
+
+// DomainBits returns the size of the plaintext domain in bits.
+func (c *Cipher) DomainBits() uint {
+	return c.domainBits
+}
+
+// Encrypt maps m to a ciphertext; m1 < m2 implies Encrypt(m1) < Encrypt(m2).
+func (c *Cipher) Encrypt(m uint64) (uint64, error) {
+	if m >= uint64(1)<<c.domainBits {
+		return 0, ErrOutOfDomain
+	}
+
+	// Inclusive bounds of the current domain and range
+	dlo, dhi := uint64(0), uint64(1)<<c.domainBits-1
+	rlo, rhi := uint64(0), uint64(1)<<c.rangeBits-1
+
+	for dlo != dhi {
+		y, k := c.split(dlo, dhi, rlo, rhi)
+		if m-dlo < k {
+			dhi, rhi = dlo+k-1, y
+		} else {
+			dlo, rlo = dlo+k, y+1
+		}
+	}
+
+	return c.leaf(dlo, rlo, rhi), nil
+}
+
+// Decrypt reverses Encrypt.
+func (c *Cipher) Decrypt(ct uint64) (uint64, error) {
+	if ct >= uint64(1)<<c.rangeBits {
+		return 0, ErrInvalidCiphertext
+	}
+
+	dlo, dhi := uint64(0), uint64(1)<<c.domainBits-1
+	rlo, rhi := uint64(0), uint64(1)<<c.rangeBits-1
+
+	for dlo != dhi {
+		y, k := c.split(dlo, dhi, rlo, rhi)
+		if ct <= y {
+			// No plaintext was mapped into the lower half
+			if k == 0 {
+				return 0, ErrInvalidCiphertext
+			}
+			dhi, rhi = dlo+k-1, y
+		} else {
+			// No plaintext was mapped into the upper half
+			if k == dhi-dlo+1 {
+				return 0, ErrInvalidCiphertext
+			}
+			dlo, rlo = dlo+k, y+1
+		}
+	}
+
+	if c.leaf(dlo, rlo, rhi) != ct {
+		return 0, ErrInvalidCiphertext
+	}
+
+	return dlo, nil
+}
+
+// split returns the last range point y of the lower half of the range and the
+// number k of domain points, counted from dlo, that are mapped into it.
+func (c *Cipher) split(dlo, dhi, rlo, rhi uint64) (uint64, uint64) {
+	M := dhi - dlo + 1
+	N := rhi - rlo + 1
+	draws := N - N/2
+	y := rlo + draws - 1
+
+	t := c.newTape(tagNode, dlo, dhi, rlo, rhi)
+	k := hypergeometric(t, N, M, draws)
+
+	return y, k
+}
+
+// leaf picks the ciphertext of the single domain point d uniformly from [rlo, rhi].
+func (c *Cipher) leaf(d, rlo, rhi uint64) uint64 {
+	t := c.newTape(tagLeaf, d, d, rlo, rhi)
+	return rlo + t.uniform(rhi-rlo+1)
+}
+
+// hypergeometric draws the number of successes when drawing `draws` items without
+// replacement from a population of `population` items holding `successes` successes.
+func hypergeometric(t *tape, population, successes, draws uint64) uint64 {
+	// Support of the distribution
+	var lo uint64
+	if failures := population - successes; draws > failures {
+		lo = draws - failures
+	}
+	hi := successes
+	if draws < hi {
+		hi = draws
+	}
+
+	if lo == hi {
+		return lo
+	}
+
+	if draws <= exactSampleLimit {
+		var k uint64
+		left, good := population, successes
+		for i := uint64(0); i < draws; i++ {
+			if t.uniform(left) < good {
+				k++
+				good--
+			}
+			left--
+		}
+		return k
+	}
+
+	// Normal approximation, clamped to the support
+	n, K, N := float64(draws), float64(successes), float64(population)
+	p := K / N
+	mean := n * p
+	sd := math.Sqrt(n * p * (1 - p) * (N - n) / (N - 1))
+
+	z := math.Sqrt(-2*math.Log(t.float())) * math.Cos(2*math.Pi*t.float())
+	s := math.Round(mean + sd*z)
+
+	switch {
+	case s <= float64(lo):
+		return lo
+	case s >= float64(hi):
+		return hi
+	}
+
+	k := uint64(s)
+	if k < lo {
+		k = lo
+	}
+	if k > hi {
+		k = hi
+	}
+
+	return k
+}
+
+// tape is a deterministic stream of coins derived from the key and a tree node
+type tape struct {
+	mac     hash.Hash
+	seed    []byte
+	counter uint32
+	buf     []byte
+}
+
+func (c *Cipher) newTape(tag byte, fields ...uint64) *tape {
+	seed := make([]byte, 2+8*len(fields))
+	seed[0] = tag
+	seed[1] = byte(c.domainBits)
+	for i, f := range fields {
+		binary.BigEndian.PutUint64(seed[2+8*i:], f)
+	}
+
+	return &tape{
+		mac:  hmac.New(sha256.New, c.key),
+		seed: seed,
+	}
+}
+
+func (t *tape) next() uint64 {
+	if len(t.buf) < 8 {
+		var counter [4]byte
+		binary.BigEndian.PutUint32(counter[:], t.counter)
+		t.counter++
+
+		t.mac.Reset()
+		t.mac.Write(t.seed)
+		t.mac.Write(counter[:])
+		t.buf = t.mac.Sum(nil)
+	}
+
+	v := binary.BigEndian.Uint64(t.buf[:8])
+	t.buf = t.buf[8:]
+
+	return v
+}
+
+// float returns a uniform value in the open interval (0, 1)
+func (t *tape) float() float64 {
+	return (float64(t.next()>>11) + 0.5) / (1 << 53)
+}
+
+// uniform returns an unbiased value in [0, n) by rejection sampling. n must be positive.
+func (t *tape) uniform(n uint64) uint64 {
+	if n&(n-1) == 0 {
+		return t.next() & (n - 1)
+	}
+
+	limit := math.MaxUint64 - math.MaxUint64%n
+	for {
+		if v := t.next(); v < limit {
+			return v % n
+		}
+	}
+}
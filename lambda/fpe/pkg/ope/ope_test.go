@@ -0,0 +1,157 @@
+package ope
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestOrderPreserved(t *testing.T) {
+	c, err := NewCipher(testKey, DefaultDomainBits)
+	if err != nil {
+		t.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	plaintexts := []uint64{0, 1, 2, 1 << 46, 1<<47 - 1}
+	for i := 0; i < 200; i++ {
+		plaintexts = append(plaintexts, uint64(r.Int63n(1<<47)))
+	}
+	sort.Slice(plaintexts, func(i, j int) bool { return plaintexts[i] < plaintexts[j] })
+
+	var prev uint64
+	for i, m := range plaintexts {
+		ct, err := c.Encrypt(m)
+		if err != nil {
+			t.Fatalf("Encrypt(%d): %v", m, err)
+		}
+
+		if i > 0 && plaintexts[i-1] != m && ct <= prev {
+			t.Fatalf("Order not preserved: Encrypt(%d) = %d <= Encrypt(%d) = %d", m, ct, plaintexts[i-1], prev)
+		}
+		prev = ct
+
+		decrypted, err := c.Decrypt(ct)
+		if err != nil {
+			t.Fatalf("Decrypt(%d): %v", ct, err)
+		}
+
+		if decrypted != m {
+			t.Fatalf("Round trip failed.\nExpected: %v\nGot: %v", m, decrypted)
+		}
+	}
+}
+
+// Exhaustive check on a small domain, which exercises the exact sampler
+func TestSmallDomain(t *testing.T) {
+	c, err := NewCipher(testKey, 4)
+	if err != nil {
+		t.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	images := map[uint64]bool{}
+	var prev uint64
+	for m := uint64(0); m < 16; m++ {
+		ct, err := c.Encrypt(m)
+		if err != nil {
+			t.Fatalf("Encrypt(%d): %v", m, err)
+		}
+
+		if m > 0 && ct <= prev {
+			t.Fatalf("Order not preserved at %d", m)
+		}
+		prev = ct
+		images[ct] = true
+	}
+
+	// Other range points must be rejected; check the neighbours of every image and a sparse sweep
+	candidates := []uint64{}
+	for ct := range images {
+		candidates = append(candidates, ct-1, ct, ct+1)
+	}
+	for ct := uint64(0); ct < 1<<20; ct += 257 {
+		candidates = append(candidates, ct)
+	}
+
+	for _, ct := range candidates {
+		m, err := c.Decrypt(ct)
+		if images[ct] {
+			if err != nil {
+				t.Fatalf("Decrypt(%d): %v", ct, err)
+			}
+			continue
+		}
+
+		if err != ErrInvalidCiphertext {
+			t.Fatalf("Decrypt(%d) = %d, expected ErrInvalidCiphertext", ct, m)
+		}
+	}
+}
+
+func TestDomains(t *testing.T) {
+	c, err := NewCipher(testKey, DefaultDomainBits)
+	if err != nil {
+		t.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	tests := []struct {
+		domain string
+		values []string
+	}{
+		{"integer", []string{"-100000", "-1", "0", "1", "4200", "99999999"}},
+		{"date", []string{"1899-12-31", "1969-12-31", "1970-01-01", "2022-02-21", "2022-02-22"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.domain, func(t *testing.T) {
+			d, err := LookupDomain(test.domain, c.DomainBits())
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			var prev uint64
+			for i, value := range test.values {
+				m, err := d.Encode(value)
+				if err != nil {
+					t.Fatalf("Encode(%q): %v", value, err)
+				}
+
+				ct, err := c.Encrypt(m)
+				if err != nil {
+					t.Fatalf("%v", err)
+				}
+
+				if i > 0 && ct <= prev {
+					t.Fatalf("Order not preserved at %q", value)
+				}
+				prev = ct
+
+				decrypted, err := c.Decrypt(ct)
+				if err != nil {
+					t.Fatalf("%v", err)
+				}
+
+				if got := d.Decode(decrypted); got != value {
+					t.Fatalf("Round trip failed.\nExpected: %v\nGot: %v", value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestOutOfDomain(t *testing.T) {
+	c, err := NewCipher(testKey, 8)
+	if err != nil {
+		t.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	if _, err := c.Encrypt(256); err != ErrOutOfDomain {
+		t.Fatalf("Expected ErrOutOfDomain, got %v", err)
+	}
+
+	if _, err := (IntegerDomain{Bits: 8}).Encode("128"); err != ErrOutOfDomain {
+		t.Fatalf("Expected ErrOutOfDomain, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// DefaultDomain is the domain of the tokens /encrypt returns: the keyset of the service and
+// the service-wide tweak. It needs no definition, but may only appear in translations.
+const DefaultDomain = "default"
+
+// Length in bytes of tweaks derived from domain names
+const domainTweakLength = 8
+
+// ErrUnknownDomain is returned for domains not defined in the policy
+var ErrUnknownDomain = errors.New("unknown domain")
+
+// Domain separates the tokens of one party, e.g. a data sharing partner, from all others.
+// Its tokens are FF1 under a key and tweak derived from the domain name, so they are
+// unlinkable to the tokens of any other domain.
+type Domain struct {
+	Description string `json:"description,omitempty"`
+}
+
+// Translation allows converting tokens From one domain To another. The reverse
+// direction needs an entry of its own.
+type Translation struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Domain returns the domain called name; DefaultDomain is always defined.
+func (p *Policy) Domain(name string) (Domain, error) {
+	if name == DefaultDomain {
+		return Domain{Description: "Tokens of /encrypt"}, nil
+	}
+
+	d, ok := p.Domains[name]
+	if !ok {
+		return Domain{}, ErrUnknownDomain
+	}
+	return d, nil
+}
+
+// DomainNames returns the names of all defined domains in sorted order.
+func (p *Policy) DomainNames() []string {
+	names := make([]string, 0, len(p.Domains))
+	for name := range p.Domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// MayTranslate reports whether tokens may be translated from one domain to another.
+func (p *Policy) MayTranslate(from string, to string) bool {
+	for _, t := range p.Translations {
+		if t.From == from && t.To == to {
+			return true
+		}
+	}
+	return false
+}
+
+// DomainTweak returns the FF1 tweak of the domain called name.
+func DomainTweak(name string) []byte {
+	digest := sha256.Sum256([]byte("fpe/domain/" + name))
+	return digest[:domainTweakLength]
+}
+
+func (p *Policy) validateDomains() error {
+	for _, name := range p.DomainNames() {
+		if name == DefaultDomain || !classNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid domain name %q", name)
+		}
+	}
+
+	seen := map[Translation]bool{}
+	for _, t := range p.Translations {
+		if t.From == t.To {
+			return fmt.Errorf("translation from %s to itself", t.From)
+		}
+
+		for _, name := range []string{t.From, t.To} {
+			if _, err := p.Domain(name); err != nil {
+				return fmt.Errorf("translation from %s to %s: unknown domain %q", t.From, t.To, name)
+			}
+		}
+
+		if seen[t] {
+			return fmt.Errorf("translation from %s to %s is listed twice", t.From, t.To)
+		}
+		seen[t] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,368 @@
+// Package policy implements declarative pseudonymization policies.
+//
+// A policy is a versioned document, in JSON or YAML, that defines data classes such as
+// "customer.phone": the transform applied to values of the class, the key and tweak it is
+// keyed with, and who may reverse it. Callers name a data class instead of passing radix,
+// tweak and key parameters, so every value of a class is treated the same way.
+//
+//	version: "2022-03-28"
+//	dataClasses:
+//	  customer.phone:
+//	    transform: fpe
+//	    fpe: {radix: 10}
+//	    key: customer
+//	    tweak: {source: class}
+//	    reversibleBy: [support]
+//	  customer.email:
+//	    transform: hash
+//	    hash: {encoding: fpe}
+//
+// A policy may also define pseudonymization domains, e.g. one per data sharing partner,
+// and the translations of tokens allowed between them:
+//
+//	domains:
+//	  partner-a: {description: Tokens shared with partner A}
+//	translations:
+//	  - {from: default, to: partner-a}
+//	  - {from: partner-a, to: default}
+package policy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/cyclewalk"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/generalization"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/masking"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/pseudonym"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/regexfpe"
+)
+
+// Transforms a data class can use
+const (
+	TransformFpe        = "fpe"
+	TransformMask       = "mask"
+	TransformHash       = "hash"
+	TransformGeneralize = "generalize"
+)
+
+// Tweak sources
+const (
+	// TweakGlobal uses the service-wide FPE_TWEAK (default)
+	TweakGlobal = "global"
+
+	// TweakStatic uses the hex-encoded Value
+	TweakStatic = "static"
+
+	// TweakClass derives the tweak from the data class name, separating classes that share a key
+	TweakClass = "class"
+
+	// Length in bytes of tweaks derived from class names
+	classTweakLength = 8
+)
+
+// Everyone may reverse a class that lists this in ReversibleBy
+const AnyPrincipal = "*"
+
+var (
+	// ErrUnknownDataClass is returned for data classes not defined in the policy
+	ErrUnknownDataClass = errors.New("unknown data class")
+
+	classNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+)
+
+// Policy is a versioned set of data classes
+type Policy struct {
+	Version     string               `json:"version"`
+	DataClasses map[string]DataClass `json:"dataClasses"`
+
+	// [2022-04-21] Domains and the translations allowed between them, see domains.go
+	Domains      map[string]Domain `json:"domains,omitempty"`
+	Translations []Translation     `json:"translations,omitempty"`
+}
+
+// DataClass defines the treatment of one kind of value. Exactly the parameters of Transform must be set.
+type DataClass struct {
+	Description string `json:"description,omitempty"`
+	Transform   string `json:"transform"`
+
+	Fpe        *FpeSpec               `json:"fpe,omitempty"`
+	Mask       *masking.Spec          `json:"mask,omitempty"`
+	Hash       *HashSpec              `json:"hash,omitempty"`
+	Generalize *generalization.Config `json:"generalize,omitempty"`
+
+	// Key reference, shared by the classes naming it; empty for a subkey of the class alone
+	Key   string     `json:"key,omitempty"`
+	Tweak *TweakSpec `json:"tweak,omitempty"`
+
+	// Groups of callers that may reverse the transform; only valid for fpe
+	ReversibleBy []string `json:"reversibleBy,omitempty"`
+}
+
+// FpeSpec selects the FF1 domain: exactly one of Radix, Format and Pattern
+type FpeSpec struct {
+	Radix   int    `json:"radix,omitempty"`
+	Format  string `json:"format,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// HashSpec configures keyed one-way pseudonyms
+type HashSpec struct {
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// TweakSpec selects where the FF1 tweak comes from
+type TweakSpec struct {
+	Source string `json:"source"`
+	Value  string `json:"value,omitempty"`
+}
+
+// Parse decodes and validates a policy in JSON or YAML. Unknown fields are rejected
+// so that typos in a policy fail at load time rather than silently changing treatment.
+func Parse(data []byte) (*Policy, error) {
+	trimmed := bytes.TrimSpace(data)
+
+	// YAML is converted to JSON first, so both formats share the JSON field names
+	if len(trimmed) > 0 && trimmed[0] != '{' {
+		var doc interface{}
+		if err := yaml.Unmarshal(trimmed, &doc); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if trimmed, err = json.Marshal(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.DisallowUnknownFields()
+
+	var p Policy
+	if err := decoder.Decode(&p); err != nil {
+		return nil, err
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// LoadFile reads and parses the policy in path.
+func LoadFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+// Validate checks every data class, domain and translation of the policy.
+func (p *Policy) Validate() error {
+	if p.Version == "" {
+		return errors.New("policy version must not be empty")
+	}
+
+	if len(p.DataClasses) == 0 {
+		return errors.New("policy defines no data classes")
+	}
+
+	for _, name := range p.Names() {
+		if !classNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid data class name %q", name)
+		}
+
+		if err := p.DataClasses[name].Validate(); err != nil {
+			return fmt.Errorf("data class %s: %v", name, err)
+		}
+	}
+
+	return p.validateDomains()
+}
+
+// Lookup returns the data class called name.
+func (p *Policy) Lookup(name string) (DataClass, error) {
+	c, ok := p.DataClasses[name]
+	if !ok {
+		return DataClass{}, ErrUnknownDataClass
+	}
+	return c, nil
+}
+
+// Names returns the names of all data classes in sorted order.
+func (p *Policy) Names() []string {
+	names := make([]string, 0, len(p.DataClasses))
+	for name := range p.DataClasses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Validate checks that the parameters match the transform.
+func (c DataClass) Validate() error {
+	specs := map[string]bool{
+		TransformFpe:        c.Fpe != nil,
+		TransformMask:       c.Mask != nil,
+		TransformHash:       c.Hash != nil,
+		TransformGeneralize: c.Generalize != nil,
+	}
+
+	if _, ok := specs[c.Transform]; !ok {
+		return fmt.Errorf("unknown transform %q, must be fpe, mask, hash or generalize", c.Transform)
+	}
+
+	for transform, set := range specs {
+		if set && transform != c.Transform {
+			return fmt.Errorf("%s parameters given for transform %s", transform, c.Transform)
+		}
+	}
+
+	if len(c.ReversibleBy) > 0 && !c.Reversible() {
+		return errors.New("reversibleBy is only valid for fpe")
+	}
+
+	if c.Key != "" && !classNamePattern.MatchString(c.Key) {
+		return fmt.Errorf("invalid key reference %q", c.Key)
+	}
+
+	if c.Tweak != nil {
+		if err := c.Tweak.validate(); err != nil {
+			return err
+		}
+	}
+
+	switch c.Transform {
+	case TransformFpe:
+		if c.Fpe == nil {
+			return errors.New("missing fpe parameters")
+		}
+		return c.Fpe.validate()
+
+	case TransformMask:
+		if c.Mask == nil {
+			return errors.New("missing mask parameters")
+		}
+		return c.Mask.Validate()
+
+	case TransformHash:
+		if c.Hash != nil {
+			switch pseudonym.Encoding(c.Hash.Encoding) {
+			case "", pseudonym.EncodingHex, pseudonym.EncodingBase32, pseudonym.EncodingFormatPreserving:
+			default:
+				return pseudonym.ErrUnknownEncoding
+			}
+		}
+
+	case TransformGeneralize:
+		if c.Generalize == nil {
+			return errors.New("missing generalize parameters")
+		}
+		return c.Generalize.Validate()
+	}
+
+	return nil
+}
+
+// Reversible reports whether the transform of the class can be reversed at all.
+func (c DataClass) Reversible() bool {
+	return c.Transform == TransformFpe
+}
+
+// MayReverse reports whether a caller belonging to groups may reverse values of the class.
+func (c DataClass) MayReverse(groups []string) bool {
+	if !c.Reversible() {
+		return false
+	}
+
+	for _, allowed := range c.ReversibleBy {
+		if allowed == AnyPrincipal {
+			return true
+		}
+		for _, g := range groups {
+			if g == allowed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// TweakFor returns the tweak of the class called name, given the service-wide tweak.
+func (c DataClass) TweakFor(name string, global []byte) ([]byte, error) {
+	if c.Tweak == nil {
+		return global, nil
+	}
+
+	switch c.Tweak.Source {
+	case TweakStatic:
+		return hex.DecodeString(c.Tweak.Value)
+	case TweakClass:
+		digest := sha256.Sum256([]byte("fpe/class/" + name))
+		return digest[:classTweakLength], nil
+	}
+
+	return global, nil
+}
+
+func (s FpeSpec) validate() error {
+	set := 0
+	if s.Radix != 0 {
+		set++
+	}
+	if s.Format != "" {
+		set++
+	}
+	if s.Pattern != "" {
+		set++
+	}
+	if set != 1 {
+		return errors.New("fpe needs exactly one of radix, format and pattern")
+	}
+
+	switch {
+	case s.Radix != 0:
+		if s.Radix < 2 || s.Radix > 62 {
+			return errors.New("radix must be between 2 and 62, inclusive")
+		}
+	case s.Format != "":
+		if _, ok := cyclewalk.LookupFormat(s.Format); !ok {
+			return fmt.Errorf("unknown format %q", s.Format)
+		}
+	default:
+		return regexfpe.Validate(s.Pattern)
+	}
+
+	return nil
+}
+
+func (s TweakSpec) validate() error {
+	switch s.Source {
+	case TweakGlobal, TweakClass:
+		if s.Value != "" {
+			return fmt.Errorf("tweak source %s takes no value", s.Source)
+		}
+	case TweakStatic:
+		if _, err := hex.DecodeString(s.Value); err != nil || s.Value == "" {
+			return errors.New("static tweak must be a non-empty hex string")
+		}
+	default:
+		return fmt.Errorf("unknown tweak source %q, must be global, static or class", s.Source)
+	}
+
+	return nil
+}
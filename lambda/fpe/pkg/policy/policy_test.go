@@ -0,0 +1,150 @@
+package policy
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testYAML = `
+version: "2022-03-28"
+dataClasses:
+  customer.phone:
+    transform: fpe
+    fpe: {radix: 10}
+    key: customer
+    tweak: {source: class}
+    reversibleBy: [support]
+  customer.card:
+    transform: fpe
+    fpe: {format: luhn}
+    reversibleBy: ["*"]
+  customer.email:
+    transform: hash
+    hash: {encoding: fpe}
+  customer.birth:
+    transform: generalize
+    generalize: {kind: date, date: {granularity: year}}
+  ticket.note:
+    transform: mask
+    mask: {type: "null"}
+`
+
+func TestParseYAMLAndJSON(t *testing.T) {
+	p, err := Parse([]byte(testYAML))
+	if err != nil {
+		t.Fatalf("Parse(YAML): %v", err)
+	}
+
+	if len(p.DataClasses) != 5 {
+		t.Fatalf("Expected 5 data classes, got %v", p.Names())
+	}
+
+	phone, err := p.Lookup("customer.phone")
+	if err != nil || phone.Fpe.Radix != 10 || phone.Key != "customer" {
+		t.Fatalf("Unexpected customer.phone: %+v (%v)", phone, err)
+	}
+
+	if !phone.MayReverse([]string{"analyst", "support"}) || phone.MayReverse([]string{"analyst"}) {
+		t.Fatalf("Unexpected reversibility of customer.phone")
+	}
+
+	if card, _ := p.Lookup("customer.card"); !card.MayReverse(nil) {
+		t.Fatalf("Expected customer.card to be reversible by anyone")
+	}
+
+	if email, _ := p.Lookup("customer.email"); email.MayReverse([]string{"support"}) {
+		t.Fatalf("Hashes must never be reversible")
+	}
+
+	if _, err := p.Lookup("customer.ssn"); err != ErrUnknownDataClass {
+		t.Fatalf("Expected ErrUnknownDataClass, got %v", err)
+	}
+
+	json := `{"version": "1", "dataClasses": {"order.id": {"transform": "fpe", "fpe": {"pattern": "[A-Z]{2}\\d{6}"}}}}`
+	if _, err := Parse([]byte(json)); err != nil {
+		t.Fatalf("Parse(JSON): %v", err)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	tests := map[string]string{
+		"no version":       `{"dataClasses": {"a": {"transform": "fpe", "fpe": {"radix": 10}}}}`,
+		"unknown field":    `{"version": "1", "dataClasses": {"a": {"transform": "fpe", "fpe": {"radx": 10}}}}`,
+		"unknown format":   `{"version": "1", "dataClasses": {"a": {"transform": "fpe", "fpe": {"format": "iban"}}}}`,
+		"two domains":      `{"version": "1", "dataClasses": {"a": {"transform": "fpe", "fpe": {"radix": 10, "format": "luhn"}}}}`,
+		"wrong parameters": `{"version": "1", "dataClasses": {"a": {"transform": "fpe", "mask": {"type": "replace"}}}}`,
+		"reversible mask":  `{"version": "1", "dataClasses": {"a": {"transform": "mask", "mask": {"type": "replace"}, "reversibleBy": ["*"]}}}`,
+		"bad tweak":        `{"version": "1", "dataClasses": {"a": {"transform": "fpe", "fpe": {"radix": 10}, "tweak": {"source": "static", "value": "xyz"}}}}`,
+		"bad name":         `{"version": "1", "dataClasses": {"a..b": {"transform": "fpe", "fpe": {"radix": 10}}}}`,
+		"unknown domain":   `{"version": "1", "dataClasses": {"a": {"transform": "fpe", "fpe": {"radix": 10}}}, "translations": [{"from": "default", "to": "b"}]}`,
+		"self translation": `{"version": "1", "dataClasses": {"a": {"transform": "fpe", "fpe": {"radix": 10}}}, "domains": {"b": {}}, "translations": [{"from": "b", "to": "b"}]}`,
+		"default domain":   `{"version": "1", "dataClasses": {"a": {"transform": "fpe", "fpe": {"radix": 10}}}, "domains": {"default": {}}}`,
+	}
+
+	for name, doc := range tests {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Fatalf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestTranslations(t *testing.T) {
+	p, err := Parse([]byte(testYAML + `
+domains:
+  partner-a: {description: Partner A}
+  partner-b: {}
+translations:
+  - {from: default, to: partner-a}
+  - {from: partner-a, to: partner-b}
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if !p.MayTranslate(DefaultDomain, "partner-a") || p.MayTranslate("partner-a", DefaultDomain) {
+		t.Fatalf("Translations must only be allowed in the listed direction")
+	}
+
+	if _, err := p.Domain("partner-c"); err != ErrUnknownDomain {
+		t.Fatalf("Expected ErrUnknownDomain, got %v", err)
+	}
+
+	if bytes.Equal(DomainTweak("partner-a"), DomainTweak("partner-b")) {
+		t.Fatalf("Expected distinct domain tweaks")
+	}
+}
+
+func TestTweakFor(t *testing.T) {
+	global := []byte{1, 2, 3}
+
+	c := DataClass{Transform: TransformFpe, Fpe: &FpeSpec{Radix: 10}}
+	if tweak, _ := c.TweakFor("a", global); !bytes.Equal(tweak, global) {
+		t.Fatalf("Expected the global tweak")
+	}
+
+	c.Tweak = &TweakSpec{Source: TweakClass}
+	a, _ := c.TweakFor("a", global)
+	b, _ := c.TweakFor("b", global)
+	if len(a) != classTweakLength || bytes.Equal(a, b) {
+		t.Fatalf("Expected distinct class tweaks, got %x and %x", a, b)
+	}
+
+	c.Tweak = &TweakSpec{Source: TweakStatic, Value: "D8E7920AFA330A73"}
+	if tweak, _ := c.TweakFor("a", global); len(tweak) != 8 {
+		t.Fatalf("Unexpected static tweak %x", tweak)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(strings.TrimSpace(testYAML)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadFile(path); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package profiler
+
+import (
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/masking"
+)
+
+// Field transforms of /encrypt in record mode
+const (
+	TransformFpe       = "fpe"
+	TransformMask      = "mask"
+	TransformDateShift = "dateshift"
+)
+
+// PolicyVersion is the version of the policy format emitted by SuggestPolicy
+const PolicyVersion = 1
+
+// Policy is a suggested, editable mapping of columns to transforms
+type Policy struct {
+	Version int    `json:"version"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Rule maps one column to a transform, using the parameters of /encrypt record mode
+type Rule struct {
+	Column     string        `json:"column"`
+	Type       string        `json:"type"`
+	Transform  string        `json:"transform"`
+	Radix      int           `json:"radix,omitempty"`
+	Mask       *masking.Spec `json:"mask,omitempty"`
+	DateLayout string        `json:"dateLayout,omitempty"`
+}
+
+// SuggestPolicy proposes a transform for every classified column. Identifiers made of digits
+// only are encrypted with FF1 so they can be re-identified; formatted identifiers are masked
+// since the separators are outside the radix. Columns without values get no rule.
+func SuggestPolicy(columns []Column) Policy {
+	policy := Policy{Version: PolicyVersion, Rules: []Rule{}}
+
+	for _, c := range columns {
+		rule := Rule{Column: c.Name, Type: c.Type}
+
+		switch c.Type {
+		case TypePAN, TypePhone, TypeRRN:
+			if c.DigitsOnly {
+				rule.Transform = TransformFpe
+				rule.Radix = 10
+				break
+			}
+
+			rule.Transform = TransformMask
+			if c.Type == TypeRRN {
+				// Birth date and gender digit
+				rule.Mask = &masking.Spec{Type: masking.TypePartial, KeepFirst: 7}
+			} else {
+				rule.Mask = &masking.Spec{Type: masking.TypePartial, KeepLast: 4}
+			}
+
+		case TypeEmail:
+			rule.Transform = TransformMask
+			rule.Mask = &masking.Spec{Type: masking.TypePartial, KeepFirst: 1}
+
+		case TypeDate:
+			rule.Transform = TransformDateShift
+			rule.DateLayout = c.DateLayout
+
+		case TypeText:
+			// Free text may hold anything, redact it until reviewed
+			rule.Transform = TransformMask
+			rule.Mask = &masking.Spec{Type: masking.TypeNull}
+
+		default:
+			continue
+		}
+
+		policy.Rules = append(policy.Rules, rule)
+	}
+
+	return policy
+}
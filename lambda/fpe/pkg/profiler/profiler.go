@@ -0,0 +1,228 @@
+// Package profiler classifies the columns of tabular data by the kind of personal data
+// they hold and suggests a pseudonymization policy for them.
+//
+// Each sampled value is classified on its own; a column gets the type most of its
+// non-empty values have, and the share of those values is the confidence.
+package profiler
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/scanner"
+)
+
+// Data types a column can be classified as
+const (
+	TypePAN   = "pan"
+	TypeEmail = "email"
+	TypePhone = "phone"
+	TypeRRN   = "rrn"
+	TypeDate  = "date"
+	TypeText  = "text"
+
+	// TypeEmpty is used for columns without any non-empty sampled value
+	TypeEmpty = "empty"
+
+	// DefaultSampleSize is used if Options.SampleSize is not positive
+	DefaultSampleSize = 1000
+)
+
+var (
+	// ErrNoHeader is returned if the data has no header row
+	ErrNoHeader = errors.New("data has no header row")
+
+	// Date layouts tried in order; the first one parsing a value wins
+	dateLayouts = []string{
+		"2006-01-02",
+		"2006/01/02",
+		"2006.01.02",
+		"20060102",
+		"01/02/2006",
+		time.RFC3339,
+		"2006-01-02 15:04:05",
+	}
+
+	// Detector types checked in order before falling back to dates and text
+	detectorTypes = []struct {
+		detector string
+		dataType string
+	}{
+		{scanner.TypeEmail, TypeEmail},
+		{scanner.TypeRRN, TypeRRN},
+		{scanner.TypeCard, TypePAN},
+		{scanner.TypePhone, TypePhone},
+	}
+)
+
+// Options control sampling
+type Options struct {
+	SampleSize int `json:"sampleSize,omitempty"`
+}
+
+// Column is the classification of one column
+type Column struct {
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
+	Confidence float64 `json:"confidence"`
+	Sampled    int     `json:"sampled"`
+
+	// Set for date columns: the Go layout most values are written in
+	DateLayout string `json:"dateLayout,omitempty"`
+
+	// Whether every value of the column's type consists of digits only
+	DigitsOnly bool `json:"digitsOnly,omitempty"`
+}
+
+// Report is the result of profiling a table
+type Report struct {
+	Rows    int      `json:"rows"`
+	Columns []Column `json:"columns"`
+	Policy  Policy   `json:"policy"`
+}
+
+// Profile classifies the columns of a table given as a header and rows.
+// At most SampleSize rows, spread evenly over the table, are inspected.
+func Profile(header []string, rows [][]string, opts Options) Report {
+	sample := sampleRows(rows, opts.SampleSize)
+
+	columns := make([]Column, len(header))
+	for i, name := range header {
+		columns[i] = classifyColumn(name, i, sample)
+	}
+
+	return Report{
+		Rows:    len(rows),
+		Columns: columns,
+		Policy:  SuggestPolicy(columns),
+	}
+}
+
+// ProfileCSV profiles CSV data whose first record is the header.
+func ProfileCSV(r io.Reader, opts Options) (Report, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return Report{}, err
+	}
+
+	if len(records) == 0 {
+		return Report{}, ErrNoHeader
+	}
+
+	return Profile(records[0], records[1:], opts), nil
+}
+
+// Classify returns the data type of a single value and, for dates, its layout.
+func Classify(value string) (string, string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return TypeEmpty, ""
+	}
+
+	for _, t := range detectorTypes {
+		d, _ := scanner.Builtin(t.detector)
+		loc := d.Pattern.FindStringIndex(value)
+		if loc == nil || loc[0] != 0 || loc[1] != len(value) {
+			continue
+		}
+		if d.Validate == nil || d.Validate(value) {
+			return t.dataType, ""
+		}
+	}
+
+	for _, layout := range dateLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return TypeDate, layout
+		}
+	}
+
+	return TypeText, ""
+}
+
+func classifyColumn(name string, index int, sample [][]string) Column {
+	counts := map[string]int{}
+	layouts := map[string]int{}
+	digitsOnly := map[string]bool{}
+	nonEmpty := 0
+
+	for _, row := range sample {
+		if index >= len(row) {
+			continue
+		}
+
+		t, layout := Classify(row[index])
+		if t == TypeEmpty {
+			continue
+		}
+
+		nonEmpty++
+		if counts[t] == 0 {
+			digitsOnly[t] = true
+		}
+		counts[t]++
+		digitsOnly[t] = digitsOnly[t] && isDigits(strings.TrimSpace(row[index]))
+		if layout != "" {
+			layouts[layout]++
+		}
+	}
+
+	column := Column{Name: name, Type: TypeEmpty, Sampled: len(sample)}
+	if nonEmpty == 0 {
+		return column
+	}
+
+	// Ties are broken in favour of the more specific type
+	best := 0
+	for _, t := range []string{TypeEmail, TypeRRN, TypePAN, TypePhone, TypeDate, TypeText} {
+		if counts[t] > best {
+			column.Type, best = t, counts[t]
+		}
+	}
+
+	column.Confidence = float64(best) / float64(nonEmpty)
+	column.DigitsOnly = digitsOnly[column.Type]
+
+	if column.Type == TypeDate {
+		most := 0
+		for _, layout := range dateLayouts {
+			if layouts[layout] > most {
+				column.DateLayout, most = layout, layouts[layout]
+			}
+		}
+	}
+
+	return column
+}
+
+// sampleRows picks at most size rows at even intervals
+func sampleRows(rows [][]string, size int) [][]string {
+	if size <= 0 {
+		size = DefaultSampleSize
+	}
+
+	if len(rows) <= size {
+		return rows
+	}
+
+	sample := make([][]string, size)
+	for i := range sample {
+		sample[i] = rows[i*len(rows)/size]
+	}
+
+	return sample
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
@@ -0,0 +1,100 @@
+package profiler
+
+import (
+	"strings"
+	"testing"
+)
+
+const testCSV = `id,name,email,card,phone,rrn,birth,notes
+1,Hong Gildong,hong@example.com,4111111111111111,010-1234-5678,900101-1234568,1990-01-01,VIP
+2,Kim Cheolsu,kim@example.co.kr,5500000000000004,010-9876-5432,9001011234568,1985-12-31,"Called twice, wants refund"
+3,Lee Younghee,not-an-email,4012888888881881,02-123-4567,,2001-07-15,
+4,Park Minsu,park@example.com,378282246310005,031-123-4567,900101-1234568,1979-03-02,n/a
+`
+
+func TestProfileCSV(t *testing.T) {
+	report, err := ProfileCSV(strings.NewReader(testCSV), Options{})
+	if err != nil {
+		t.Fatalf("ProfileCSV: %v", err)
+	}
+
+	if report.Rows != 4 {
+		t.Fatalf("Expected 4 rows, got %d", report.Rows)
+	}
+
+	expected := map[string]struct {
+		dataType   string
+		confidence float64
+	}{
+		"name":  {TypeText, 1},
+		"email": {TypeEmail, 0.75},
+		"card":  {TypePAN, 1},
+		"phone": {TypePhone, 1},
+		"rrn":   {TypeRRN, 1},
+		"birth": {TypeDate, 1},
+		"notes": {TypeText, 1},
+	}
+
+	for _, c := range report.Columns {
+		e, ok := expected[c.Name]
+		if !ok {
+			continue
+		}
+		if c.Type != e.dataType || c.Confidence != e.confidence {
+			t.Fatalf("Column %s: expected %s (%v), got %s (%v)", c.Name, e.dataType, e.confidence, c.Type, c.Confidence)
+		}
+	}
+
+	rules := map[string]Rule{}
+	for _, r := range report.Policy.Rules {
+		rules[r.Column] = r
+	}
+
+	if r := rules["card"]; r.Transform != TransformFpe || r.Radix != 10 {
+		t.Fatalf("Expected FF1 for digit-only card numbers, got %+v", r)
+	}
+
+	if r := rules["phone"]; r.Transform != TransformMask || r.Mask == nil || r.Mask.KeepLast != 4 {
+		t.Fatalf("Expected partial mask for formatted phone numbers, got %+v", r)
+	}
+
+	if r := rules["birth"]; r.Transform != TransformDateShift || r.DateLayout != "2006-01-02" {
+		t.Fatalf("Expected date shift, got %+v", r)
+	}
+}
+
+func TestSampleRows(t *testing.T) {
+	rows := make([][]string, 10)
+	for i := range rows {
+		rows[i] = []string{string(rune('a' + i))}
+	}
+
+	sample := sampleRows(rows, 5)
+	if len(sample) != 5 || sample[0][0] != "a" || sample[4][0] != "i" {
+		t.Fatalf("Unexpected sample: %v", sample)
+	}
+
+	if len(sampleRows(rows, 0)) != 10 {
+		t.Fatalf("Expected all rows with the default sample size")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := map[string]string{
+		"":                     TypeEmpty,
+		"john@example.com":     TypeEmail,
+		"4111 1111 1111 1111":  TypePAN,
+		"4111 1111 1111 1112":  TypeText,
+		"01012345678":          TypePhone,
+		"20220321":             TypeDate,
+		"hello world":          TypeText,
+		"900101-1234568":       TypeRRN,
+		"2022-03-21T10:00:00Z": TypeDate,
+	}
+
+	for value, expected := range tests {
+		if got, _ := Classify(value); got != expected {
+			t.Fatalf("Classify(%q): expected %s, got %s", value, expected, got)
+		}
+	}
+}
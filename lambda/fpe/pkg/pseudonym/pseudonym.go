@@ -0,0 +1,141 @@
+// Package pseudonym produces deterministic, irreversible pseudonyms with HMAC-SHA256.
+//
+// The same input under the same key always yields the same pseudonym, so joins across
+// datasets keep working, but nobody (including the service) can map a pseudonym back
+// to its input other than by guessing inputs and comparing.
+package pseudonym
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// An Encoding selects the textual form of a pseudonym
+type Encoding string
+
+const (
+	// EncodingHex is the lower-case hex encoding of the full digest
+	EncodingHex Encoding = "hex"
+
+	// EncodingBase32 is the unpadded base32 encoding of the full digest
+	EncodingBase32 Encoding = "base32"
+
+	// EncodingFormatPreserving maps the digest into the input's alphabet and length:
+	// digits stay digits, upper-case letters stay upper-case, lower-case letters stay
+	// lower-case, and every other character is kept in place.
+	EncodingFormatPreserving Encoding = "fpe"
+)
+
+var (
+	// ErrUnknownEncoding is returned for encodings other than hex, base32 and fpe
+	ErrUnknownEncoding = errors.New("unknown encoding, must be hex, base32 or fpe")
+
+	// ErrNothingToPseudonymize is returned if a format-preserving pseudonym would equal the input
+	// because the input has no letters or digits
+	ErrNothingToPseudonymize = errors.New("input has no letters or digits to pseudonymize")
+)
+
+// A Pseudonymizer computes keyed one-way pseudonyms
+type Pseudonymizer struct {
+	key []byte
+}
+
+// New returns a Pseudonymizer keyed with key.
+func New(key []byte) (*Pseudonymizer, error) {
+	if len(key) < 16 {
+		return nil, errors.New("key must be at least 128 bits")
+	}
+
+	return &Pseudonymizer{key: append([]byte(nil), key...)}, nil
+}
+
+// Digest returns HMAC-SHA256(key, input).
+func (p *Pseudonymizer) Digest(input string) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(input))
+	return mac.Sum(nil)
+}
+
+// Pseudonymize returns the pseudonym of input in the given encoding.
+func (p *Pseudonymizer) Pseudonymize(input string, encoding Encoding) (string, error) {
+	digest := p.Digest(input)
+
+	switch encoding {
+	case EncodingHex, "":
+		return hex.EncodeToString(digest), nil
+
+	case EncodingBase32:
+		return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(digest), nil
+
+	case EncodingFormatPreserving:
+		return p.formatPreserving(input, digest)
+
+	default:
+		return "", ErrUnknownEncoding
+	}
+}
+
+func (p *Pseudonymizer) formatPreserving(input string, digest []byte) (string, error) {
+	s := &stream{key: p.key, seed: digest}
+
+	out := []byte(input)
+	mapped := false
+	for i, c := range out {
+		switch {
+		case c >= '0' && c <= '9':
+			out[i] = '0' + s.uniform(10)
+		case c >= 'A' && c <= 'Z':
+			out[i] = 'A' + s.uniform(26)
+		case c >= 'a' && c <= 'z':
+			out[i] = 'a' + s.uniform(26)
+		default:
+			continue
+		}
+		mapped = true
+	}
+
+	if !mapped {
+		return "", ErrNothingToPseudonymize
+	}
+
+	return string(out), nil
+}
+
+// stream expands a digest into as many pseudo-random bytes as needed: HMAC(key, seed || counter)
+type stream struct {
+	key     []byte
+	seed    []byte
+	counter uint32
+	buf     []byte
+}
+
+func (s *stream) byte() byte {
+	if len(s.buf) == 0 {
+		var counter [4]byte
+		binary.BigEndian.PutUint32(counter[:], s.counter)
+		s.counter++
+
+		mac := hmac.New(sha256.New, s.key)
+		mac.Write(s.seed)
+		mac.Write(counter[:])
+		s.buf = mac.Sum(nil)
+	}
+
+	b := s.buf[0]
+	s.buf = s.buf[1:]
+	return b
+}
+
+// uniform returns an unbiased value in [0, n) for n <= 256 by rejection sampling
+func (s *stream) uniform(n int) byte {
+	limit := 256 - 256%n
+	for {
+		if b := int(s.byte()); b < limit {
+			return byte(b % n)
+		}
+	}
+}
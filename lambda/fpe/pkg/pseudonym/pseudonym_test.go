@@ -0,0 +1,97 @@
+package pseudonym
+
+import (
+	"regexp"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestDeterministic(t *testing.T) {
+	p, err := New(testKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	for _, encoding := range []Encoding{EncodingHex, EncodingBase32, EncodingFormatPreserving} {
+		a, err := p.Pseudonymize("alice@example.com", encoding)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+
+		b, err := p.Pseudonymize("alice@example.com", encoding)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+
+		if a != b {
+			t.Fatalf("%s: pseudonyms differ: %q != %q", encoding, a, b)
+		}
+
+		c, err := p.Pseudonymize("bob@example.com", encoding)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+
+		if a == c {
+			t.Fatalf("%s: different inputs share pseudonym %q", encoding, a)
+		}
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	p, err := New(testKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tests := []struct {
+		encoding Encoding
+		input    string
+		pattern  string
+	}{
+		{EncodingHex, "010-1234-5678", `^[0-9a-f]{64}$`},
+		{EncodingBase32, "010-1234-5678", `^[A-Z2-7]{52}$`},
+		{EncodingFormatPreserving, "010-1234-5678", `^\d{3}-\d{4}-\d{4}$`},
+		{EncodingFormatPreserving, "Kim.Sh_77@Example.com", `^[A-Z][a-z]{2}\.[A-Z][a-z]_\d{2}@[A-Z][a-z]{6}\.[a-z]{3}$`},
+	}
+
+	for _, test := range tests {
+		token, err := p.Pseudonymize(test.input, test.encoding)
+		if err != nil {
+			t.Fatalf("%s(%q): %v", test.encoding, test.input, err)
+		}
+
+		if !regexp.MustCompile(test.pattern).MatchString(token) {
+			t.Fatalf("%s(%q) = %q does not match %s", test.encoding, test.input, token, test.pattern)
+		}
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	p1, _ := New(testKey)
+	p2, _ := New([]byte("fedcba9876543210fedcba9876543210"))
+
+	a, _ := p1.Pseudonymize("4111111111111111", EncodingFormatPreserving)
+	b, _ := p2.Pseudonymize("4111111111111111", EncodingFormatPreserving)
+
+	if a == b {
+		t.Fatalf("Different keys produced the same pseudonym %q", a)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	p, _ := New(testKey)
+
+	if _, err := p.Pseudonymize("x", "rot13"); err != ErrUnknownEncoding {
+		t.Fatalf("Expected ErrUnknownEncoding, got %v", err)
+	}
+
+	if _, err := p.Pseudonymize("--", EncodingFormatPreserving); err != ErrNothingToPseudonymize {
+		t.Fatalf("Expected ErrNothingToPseudonymize, got %v", err)
+	}
+
+	if _, err := New([]byte("short")); err == nil {
+		t.Fatalf("Expected an error for a short key")
+	}
+}
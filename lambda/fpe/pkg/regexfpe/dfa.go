@@ -0,0 +1,188 @@
+package regexfpe
+
+import (
+	"errors"
+	"regexp/syntax"
+	"sort"
+)
+
+const (
+	// Printable ASCII is the alphabet every pattern is evaluated over.
+	// Restricting the alphabet keeps "." and negated classes from exploding the DFA.
+	alphabetFirst = 0x20
+	alphabetLast  = 0x7e
+
+	// Upper bound on the number of DFA states built by subset construction.
+	maxStates = 4096
+
+	// Transition target for the dead (rejecting sink) state.
+	deadState = -1
+)
+
+var (
+	// ErrUnsupportedPattern is returned if the pattern uses constructs that cannot be mapped to a DFA
+	ErrUnsupportedPattern = errors.New("pattern uses unsupported constructs (word boundaries)")
+
+	// ErrTooManyStates is returned if the pattern compiles to a DFA that is too large
+	ErrTooManyStates = errors.New("pattern compiles to too many DFA states")
+)
+
+// dfa is a deterministic finite automaton over the printable ASCII alphabet.
+// The automaton always matches the whole input, i.e. patterns are implicitly anchored.
+type dfa struct {
+	alphabet []byte
+	start    int
+	accept   []bool
+	// next[q][i] is the state reached from q on alphabet[i], or deadState
+	next [][]int
+}
+
+// compile parses a regular expression and converts it into a DFA via subset construction.
+func compile(pattern string) (*dfa, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasWordBoundary(re) {
+		return nil, ErrUnsupportedPattern
+	}
+
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil, err
+	}
+
+	alphabet := make([]byte, 0, alphabetLast-alphabetFirst+1)
+	for b := alphabetFirst; b <= alphabetLast; b++ {
+		alphabet = append(alphabet, byte(b))
+	}
+
+	d := &dfa{alphabet: alphabet}
+
+	// Each DFA state is identified by the sorted set of NFA instructions it represents
+	index := map[string]int{}
+	var sets [][]uint32
+
+	addState := func(set []uint32) (int, error) {
+		key := setKey(set)
+		if q, ok := index[key]; ok {
+			return q, nil
+		}
+		if len(sets) >= maxStates {
+			return 0, ErrTooManyStates
+		}
+
+		q := len(sets)
+		index[key] = q
+		sets = append(sets, set)
+
+		accepting := false
+		for _, pc := range set {
+			if prog.Inst[pc].Op == syntax.InstMatch {
+				accepting = true
+				break
+			}
+		}
+		d.accept = append(d.accept, accepting)
+		d.next = append(d.next, nil)
+
+		return q, nil
+	}
+
+	d.start, err = addState(closure(prog, []uint32{uint32(prog.Start)}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Sets grow while we iterate, so this is a breadth-first worklist
+	for q := 0; q < len(sets); q++ {
+		row := make([]int, len(alphabet))
+		for i, sym := range alphabet {
+			var targets []uint32
+			for _, pc := range sets[q] {
+				inst := &prog.Inst[pc]
+				switch inst.Op {
+				case syntax.InstRune, syntax.InstRune1, syntax.InstRuneAny, syntax.InstRuneAnyNotNL:
+					if inst.MatchRune(rune(sym)) {
+						targets = append(targets, inst.Out)
+					}
+				}
+			}
+
+			if len(targets) == 0 {
+				row[i] = deadState
+				continue
+			}
+
+			row[i], err = addState(closure(prog, targets))
+			if err != nil {
+				return nil, err
+			}
+		}
+		d.next[q] = row
+	}
+
+	return d, nil
+}
+
+// closure returns the sorted set of instructions reachable from pcs through empty transitions.
+// Anchors are treated as empty transitions since the whole input is always matched.
+func closure(prog *syntax.Prog, pcs []uint32) []uint32 {
+	seen := map[uint32]bool{}
+	stack := append([]uint32(nil), pcs...)
+	var set []uint32
+
+	for len(stack) > 0 {
+		pc := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[pc] {
+			continue
+		}
+		seen[pc] = true
+
+		inst := &prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			stack = append(stack, inst.Out, inst.Arg)
+		case syntax.InstCapture, syntax.InstNop, syntax.InstEmptyWidth:
+			stack = append(stack, inst.Out)
+		case syntax.InstFail:
+			// Dead end, nothing to add
+		default:
+			set = append(set, pc)
+		}
+	}
+
+	sort.Slice(set, func(i, j int) bool { return set[i] < set[j] })
+
+	return set
+}
+
+func setKey(set []uint32) string {
+	key := make([]byte, 0, len(set)*4)
+	for _, pc := range set {
+		key = append(key, byte(pc>>24), byte(pc>>16), byte(pc>>8), byte(pc))
+	}
+	return string(key)
+}
+
+func hasWordBoundary(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpWordBoundary || re.Op == syntax.OpNoWordBoundary {
+		return true
+	}
+	for _, sub := range re.Sub {
+		if hasWordBoundary(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// symbolIndex returns the position of b in the alphabet, or -1 if b is not part of it.
+func (d *dfa) symbolIndex(b byte) int {
+	if b < alphabetFirst || b > alphabetLast {
+		return -1
+	}
+	return int(b - alphabetFirst)
+}
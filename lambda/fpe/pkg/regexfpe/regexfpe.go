@@ -0,0 +1,232 @@
+// Package regexfpe implements format-preserving encryption over domains defined by
+// a regular expression, following the rank-encipher-unrank construction of libfte.
+//
+// The pattern is compiled to a DFA, the input is ranked among all strings of the same
+// length in the language, the rank is enciphered with FF1 (cycle-walking until it falls
+// back into the domain), and the result is unranked into a string of the language again.
+package regexfpe
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/cyclewalk"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ff1"
+)
+
+const (
+	// Ranks are enciphered as binary strings, FF1 needs at least 7 of them for radix 2
+	rankRadix  = 2
+	minRankLen = 7
+
+	// Upper bound for cycle-walking; the expected number of iterations is below 2 for slices of
+	// more than 64 strings and at most 64 for smaller ones, see encipher
+	maxCycleWalks = 1000
+)
+
+// ErrNotInLanguage is returned if the input is not matched by the pattern
+var ErrNotInLanguage = errors.New("input is not matched by the pattern")
+
+// A Cipher encrypts strings of a regular language into strings of the same language and length.
+type Cipher struct {
+	pattern string
+	dfa     *dfa
+	ff1     ff1.Cipher
+
+	// counts[n][q] is the number of accepted strings of length n starting from state q
+	mu     sync.Mutex
+	counts [][]*big.Int
+}
+
+// NewCipher compiles the pattern and initializes an FF1 cipher with the key and tweak
+// used to encipher ranks.
+func NewCipher(pattern string, key []byte, tweak []byte) (*Cipher, error) {
+	d, err := compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	FF1, err := ff1.NewCipher(rankRadix, len(tweak), key, tweak)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{
+		pattern: pattern,
+		dfa:     d,
+		ff1:     FF1,
+	}, nil
+}
+
+// Validate reports whether pattern can be used with NewCipher, without keying a cipher.
+func Validate(pattern string) error {
+	_, err := compile(pattern)
+	return err
+}
+
+// Pattern returns the regular expression the cipher was created with.
+func (c *Cipher) Pattern() string {
+	return c.pattern
+}
+
+// Encrypt encrypts X into another string of the language with the same length.
+func (c *Cipher) Encrypt(X string) (string, error) {
+	return c.permute(X, cyclewalk.Cipher.Encrypt)
+}
+
+// Decrypt reverses Encrypt.
+func (c *Cipher) Decrypt(X string) (string, error) {
+	return c.permute(X, cyclewalk.Cipher.Decrypt)
+}
+
+func (c *Cipher) permute(X string, f func(cyclewalk.Cipher, string) (string, error)) (string, error) {
+	n := len(X)
+	counts := c.countsFor(n)
+
+	// Size of the domain: strings of length n in the language
+	size := counts[n][c.dfa.start]
+	if size.Sign() == 0 {
+		return "", ErrNotInLanguage
+	}
+
+	rank, err := c.rank(X, counts)
+	if err != nil {
+		return "", err
+	}
+
+	// A domain of one string has nothing to permute
+	if size.Cmp(big.NewInt(1)) == 0 {
+		return X, nil
+	}
+
+	var max big.Int
+	max.Sub(size, big.NewInt(1))
+	width := max.BitLen()
+	if width < minRankLen {
+		width = minRankLen
+	}
+
+	// Cycle-walk: re-apply the permutation until the rank is back in range. The binary domain
+	// is less than twice as large as the language slice, unless it was padded to minRankLen
+	// bits: a slice of s < 64 strings then takes 128/s iterations on average, and exceeding
+	// maxCycleWalks has a probability below 1e-6.
+	walker, err := cyclewalk.NewCipher(c.ff1, func(text string) bool {
+		var value big.Int
+		value.SetString(text, rankRadix)
+		return value.Cmp(size) < 0
+	}, maxCycleWalks)
+	if err != nil {
+		return "", err
+	}
+
+	text := rank.Text(rankRadix)
+	text = strings.Repeat("0", width-len(text)) + text
+
+	text, err = f(walker, text)
+	if err != nil {
+		return "", err
+	}
+
+	var value big.Int
+	value.SetString(text, rankRadix)
+
+	return c.unrank(&value, n, counts), nil
+}
+
+// rank returns the position of X among the strings of length len(X) in the language,
+// in lexicographic order of the alphabet.
+func (c *Cipher) rank(X string, counts [][]*big.Int) (*big.Int, error) {
+	n := len(X)
+	rank := new(big.Int)
+	q := c.dfa.start
+
+	for i := 0; i < n; i++ {
+		sym := c.dfa.symbolIndex(X[i])
+		if sym < 0 {
+			return nil, ErrNotInLanguage
+		}
+
+		remaining := n - i - 1
+		for s := 0; s < sym; s++ {
+			if next := c.dfa.next[q][s]; next != deadState {
+				rank.Add(rank, counts[remaining][next])
+			}
+		}
+
+		q = c.dfa.next[q][sym]
+		if q == deadState {
+			return nil, ErrNotInLanguage
+		}
+	}
+
+	if !c.dfa.accept[q] {
+		return nil, ErrNotInLanguage
+	}
+
+	return rank, nil
+}
+
+// unrank is the inverse of rank. The rank must be smaller than the size of the domain.
+func (c *Cipher) unrank(rank *big.Int, n int, counts [][]*big.Int) string {
+	var remainder big.Int
+	remainder.Set(rank)
+
+	out := make([]byte, n)
+	q := c.dfa.start
+
+	for i := 0; i < n; i++ {
+		remaining := n - i - 1
+		for s, next := range c.dfa.next[q] {
+			if next == deadState {
+				continue
+			}
+
+			count := counts[remaining][next]
+			if remainder.Cmp(count) < 0 {
+				out[i] = c.dfa.alphabet[s]
+				q = next
+				break
+			}
+			remainder.Sub(&remainder, count)
+		}
+	}
+
+	return string(out)
+}
+
+// countsFor returns the count table up to length n, extending the cached table if needed.
+func (c *Cipher) countsFor(n int) [][]*big.Int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	states := len(c.dfa.accept)
+
+	if len(c.counts) == 0 {
+		row := make([]*big.Int, states)
+		for q := range row {
+			row[q] = new(big.Int)
+			if c.dfa.accept[q] {
+				row[q].SetInt64(1)
+			}
+		}
+		c.counts = append(c.counts, row)
+	}
+
+	for k := len(c.counts); k <= n; k++ {
+		prev := c.counts[k-1]
+		row := make([]*big.Int, states)
+		for q := range row {
+			row[q] = new(big.Int)
+			for _, next := range c.dfa.next[q] {
+				if next != deadState {
+					row[q].Add(row[q], prev[next])
+				}
+			}
+		}
+		c.counts = append(c.counts, row)
+	}
+
+	return c.counts[:n+1]
+}
@@ -0,0 +1,119 @@
+package regexfpe
+
+import (
+	"encoding/hex"
+	"math/big"
+	"regexp"
+	"testing"
+)
+
+const (
+	testKey   = "2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94"
+	testTweak = "D8E7920AFA330A73"
+)
+
+func newTestCipher(t *testing.T, pattern string) *Cipher {
+	key, err := hex.DecodeString(testKey)
+	if err != nil {
+		t.Fatalf("Unable to decode hex key: %v", testKey)
+	}
+
+	tweak, err := hex.DecodeString(testTweak)
+	if err != nil {
+		t.Fatalf("Unable to decode tweak: %v", testTweak)
+	}
+
+	c, err := NewCipher(pattern, key, tweak)
+	if err != nil {
+		t.Fatalf("Unable to create cipher: %v", err)
+	}
+
+	return c
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		pattern string
+		inputs  []string
+	}{
+		{`[A-Z]{2}\d{6,8}|\d{10}`, []string{"AB123456", "ZZ9999999", "QW00000000", "0123456789"}},
+		{`\d{3}-\d{2}-\d{4}`, []string{"123-45-6789", "000-00-0000"}},
+		{`[a-z]+@example\.com`, []string{"john@example.com", "a@example.com"}},
+		{`(?i)ab[0-9]`, []string{"aB7", "AB0"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			c := newTestCipher(t, test.pattern)
+			re := regexp.MustCompile(`^(?:` + test.pattern + `)$`)
+
+			for _, plaintext := range test.inputs {
+				ciphertext, err := c.Encrypt(plaintext)
+				if err != nil {
+					t.Fatalf("Encrypt(%q): %v", plaintext, err)
+				}
+
+				if len(ciphertext) != len(plaintext) {
+					t.Fatalf("Encrypt(%q) changed length: %q", plaintext, ciphertext)
+				}
+
+				if !re.MatchString(ciphertext) {
+					t.Fatalf("Encrypt(%q) left the language: %q", plaintext, ciphertext)
+				}
+
+				decrypted, err := c.Decrypt(ciphertext)
+				if err != nil {
+					t.Fatalf("Decrypt(%q): %v", ciphertext, err)
+				}
+
+				if decrypted != plaintext {
+					t.Fatalf("Round trip failed.\nExpected: %v\nGot: %v", plaintext, decrypted)
+				}
+			}
+		})
+	}
+}
+
+// Ranking must be a bijection onto [0, size) for every length
+func TestRankUnrank(t *testing.T) {
+	c := newTestCipher(t, `[ab]{1,2}c?`)
+
+	for n := 1; n <= 3; n++ {
+		counts := c.countsFor(n)
+		size := counts[n][c.dfa.start].Int64()
+
+		seen := map[string]bool{}
+		for r := int64(0); r < size; r++ {
+			s := c.unrank(big.NewInt(r), n, counts)
+			if seen[s] {
+				t.Fatalf("unrank produced %q twice", s)
+			}
+			seen[s] = true
+
+			back, err := c.rank(s, counts)
+			if err != nil {
+				t.Fatalf("rank(%q): %v", s, err)
+			}
+
+			if back.Int64() != r {
+				t.Fatalf("rank(unrank(%d)) = %d", r, back.Int64())
+			}
+		}
+	}
+}
+
+func TestNotInLanguage(t *testing.T) {
+	c := newTestCipher(t, `[A-Z]{2}\d{6,8}|\d{10}`)
+
+	for _, input := range []string{"", "A1234567", "AB12345", "AB12345678901", "ab123456"} {
+		if _, err := c.Encrypt(input); err != ErrNotInLanguage {
+			t.Fatalf("Encrypt(%q): expected ErrNotInLanguage, got %v", input, err)
+		}
+	}
+}
+
+func TestUnsupportedPattern(t *testing.T) {
+	if _, err := compile(`\bfoo`); err != ErrUnsupportedPattern {
+		t.Fatalf("Expected ErrUnsupportedPattern, got %v", err)
+	}
+}
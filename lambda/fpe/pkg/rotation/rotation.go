@@ -0,0 +1,380 @@
+// Package rotation implements the Secrets Manager rotation protocol for the data encryption keyset:
+//   - createSecret: generates a data encryption key under the master key and stores the current
+//     keyset with the new key added as primary, the former primary key demoted to active, as the
+//     version of the rotation token labeled AWSPENDING; if the current value is
+//     keyset.Uninitialized, as deployed, the keyset of the new key alone;
+//   - setSecret: nothing to do, no other system holds the keys;
+//   - testSecret: unwraps every key of the pending keyset, checks them against their check values
+//     and round-trips FF1 and an envelope under the new key, as the FPE function uses it;
+//   - finishSecret: moves AWSCURRENT to the version of the rotation token, which labels the
+//     former current version AWSPREVIOUS, and removes AWSPENDING.
+//
+// The FPE function only reads AWSCURRENT, so it keeps using the former primary key until
+// finishSecret; every step can be retried.
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ff1"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/handlers"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kdf"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/keyset"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+)
+
+// Steps of the rotation protocol
+const (
+	StepCreateSecret = "createSecret"
+	StepSetSecret    = "setSecret"
+	StepTestSecret   = "testSecret"
+	StepFinishSecret = "finishSecret"
+)
+
+// Event is what Secrets Manager invokes a rotation function with
+type Event struct {
+	SecretId           string `json:"SecretId"`
+	ClientRequestToken string `json:"ClientRequestToken"`
+	Step               string `json:"Step"`
+}
+
+// Values the pending key must round-trip before it is promoted, by radix
+var selfTests = []struct {
+	radix int
+	input string
+}{
+	{10, "0123456789"},
+	{10, "4111111111111111"},
+	{36, "fpe0rotation0test"},
+}
+
+// [2022-05-05] Scope tells how the FPE function uses the keys of a secret.
+type Scope struct {
+	// Encryption context new keys are bound to
+	EncryptionContext kms.EncryptionContext
+
+	// [2022-05-09] Tenant the subkeys of the keys are derived for, see kdf.Label
+	Tenant string
+}
+
+// ScopeFunc returns the scope of the secret secretId
+type ScopeFunc func(secretId string) Scope
+
+// Rotator rotates the keyset stored in a versioned secret store.
+type Rotator struct {
+	keys        kms.KeyManager
+	secrets     secretsmanager.VersionedStore
+	masterKeyId string
+	tweak       []byte
+	scope       ScopeFunc
+}
+
+// NewRotator returns a rotator generating keys under masterKeyId, bound to the encryption context
+// of the scope returned by scope, and self-testing them with tweak. Without a scope function, or
+// if its scope has no context, new keys get the context of the primary key.
+func NewRotator(keys kms.KeyManager, secrets secretsmanager.VersionedStore, masterKeyId string, tweak []byte, scope ScopeFunc) *Rotator {
+	return &Rotator{
+		keys:        keys,
+		secrets:     secrets,
+		masterKeyId: masterKeyId,
+		tweak:       tweak,
+		scope:       scope,
+	}
+}
+
+// scopeOf returns the scope of the secret secretId, empty without a scope function
+func (r *Rotator) scopeOf(secretId string) Scope {
+	if r.scope == nil {
+		return Scope{}
+	}
+	return r.scope(secretId)
+}
+
+// Rotate runs one step of the rotation protocol.
+func (r *Rotator) Rotate(ctx context.Context, event Event) error {
+	if event.SecretId == "" || event.ClientRequestToken == "" {
+		return errors.New("secret ID and client request token must not be empty")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done, err := r.checkVersion(event)
+	if err != nil || done {
+		return err
+	}
+
+	fmt.Printf("{Rotation} %s for version %s of %s\n", event.Step, event.ClientRequestToken, event.SecretId)
+
+	switch event.Step {
+	case StepCreateSecret:
+		return r.createSecret(event)
+	case StepSetSecret:
+		return nil
+	case StepTestSecret:
+		return r.testSecret(event)
+	case StepFinishSecret:
+		return r.finishSecret(event)
+	}
+
+	return fmt.Errorf("unknown rotation step %q", event.Step)
+}
+
+// checkVersion reports whether the rotation has already finished, and fails if the
+// version of the token exists but is not the one being rotated.
+func (r *Rotator) checkVersion(event Event) (bool, error) {
+	stages, err := r.secrets.VersionStages(event.SecretId)
+	if err != nil {
+		return false, err
+	}
+
+	// Secrets Manager registers the version before createSecret; local stores do not
+	labels, ok := stages[event.ClientRequestToken]
+	if has(labels, secretsmanager.StageCurrent) {
+		fmt.Println("{Rotation} Version", event.ClientRequestToken, "is already current.")
+		return true, nil
+	}
+
+	if !ok {
+		if event.Step == StepCreateSecret {
+			return false, nil
+		}
+		return false, fmt.Errorf("version %s of %s does not exist", event.ClientRequestToken, event.SecretId)
+	}
+
+	if !has(labels, secretsmanager.StagePending) {
+		return false, fmt.Errorf("version %s of %s is not pending rotation", event.ClientRequestToken, event.SecretId)
+	}
+
+	return false, nil
+}
+
+func (r *Rotator) createSecret(event Event) error {
+	if _, err := r.secrets.GetSecretVersion(event.SecretId, event.ClientRequestToken, secretsmanager.StagePending); err == nil {
+		return nil
+	} else if err != secretsmanager.ErrSecretNotFound {
+		return err
+	}
+
+	value, err := r.secrets.GetSecretVersion(event.SecretId, "", secretsmanager.StageCurrent)
+	if err != nil {
+		return err
+	}
+
+	// [2022-05-05] The new key is bound to the configured encryption context, so keys created
+	// before contexts are replaced by bound ones; the context of the primary key otherwise
+	context := r.scopeOf(event.SecretId).EncryptionContext
+
+	ks, err := keyset.Parse(value)
+	switch {
+	case err == keyset.ErrUninitialized:
+		// The secret was created by the deployment; this rotation generates its first key
+		ks = &keyset.Keyset{}
+	case err != nil:
+		return err
+	default:
+		if k, ok := ks.Pending(); ok {
+			return fmt.Errorf("current keyset already has the pending key %s", k.ID)
+		}
+		if len(context) == 0 {
+			context = ks.Primary().EncryptionContext
+		}
+	}
+
+	wrapped, err := r.keys.GenerateDataKey(r.masterKeyId, context)
+	if err != nil {
+		return err
+	}
+
+	dek, err := r.keys.DecryptDataKey(wrapped, context)
+	if err != nil {
+		return err
+	}
+
+	k, err := ks.Add(wrapped, dek, context, keyset.StatusPrimary)
+	if err != nil {
+		return err
+	}
+
+	if value, err = ks.Encode(); err != nil {
+		return err
+	}
+
+	fmt.Println("{Rotation} Added key", k.ID, "as primary of the pending version")
+
+	return r.secrets.PutSecretVersion(event.SecretId, event.ClientRequestToken, value, []string{secretsmanager.StagePending})
+}
+
+func (r *Rotator) testSecret(event Event) error {
+	ks, pending, err := r.pendingKeyset(event)
+	if err != nil {
+		return err
+	}
+
+	var pendingKey []byte
+	for _, k := range ks.Keys {
+		dek, err := r.keys.DecryptDataKey(k.Wrapped(), k.EncryptionContext)
+		if err != nil {
+			return fmt.Errorf("key %s: %v", k.ID, err)
+		}
+
+		if len(dek) != kms.DataKeyBytes {
+			return fmt.Errorf("key %s: data encryption key must be %d bytes, got %d", k.ID, kms.DataKeyBytes, len(dek))
+		}
+
+		if err := k.Verify(dek); err != nil {
+			return fmt.Errorf("key %s: %v", k.ID, err)
+		}
+
+		if k.ID == pending.ID {
+			pendingKey = dek
+		} else if k.CheckValue == pending.CheckValue {
+			return fmt.Errorf("pending key %s duplicates key %s", pending.ID, k.ID)
+		}
+	}
+
+	// [2022-05-05] FF1 uses new keys through their subkeys only, derived for the tenant of the secret
+	fpeKey := pendingKey
+	if pending.Derives() {
+		label := kdf.Label{Tenant: r.scopeOf(event.SecretId).Tenant, Purpose: handlers.KeyPurposeFpe}
+		if fpeKey, err = kdf.Derive(pendingKey, label); err != nil {
+			return err
+		}
+	}
+
+	if err := SelfTest(fpeKey, r.tweak); err != nil {
+		return err
+	}
+
+	// [2022-05-09] Envelopes are sealed under the key itself
+	return selfTestEnvelope(pending, pendingKey)
+}
+
+// selfTestEnvelope checks that an envelope sealed with k, unwrapped to dek, opens again.
+func selfTestEnvelope(k keyset.Key, dek []byte) error {
+	sealed, err := handlers.SealEnvelope(k, dek, []byte(selfTests[0].input))
+	if err != nil {
+		return err
+	}
+
+	opened, err := handlers.OpenEnvelope(sealed, dek)
+	if err != nil {
+		return err
+	}
+
+	if string(opened) != selfTests[0].input {
+		return errors.New("envelope round trip failed")
+	}
+
+	return nil
+}
+
+func (r *Rotator) finishSecret(event Event) error {
+	_, pending, err := r.pendingKeyset(event)
+	if err != nil {
+		return err
+	}
+
+	stages, err := r.secrets.VersionStages(event.SecretId)
+	if err != nil {
+		return err
+	}
+
+	current := ""
+	for versionId, labels := range stages {
+		if has(labels, secretsmanager.StageCurrent) {
+			current = versionId
+		}
+	}
+
+	// The store labels the version AWSCURRENT is moved from AWSPREVIOUS
+	err = r.secrets.UpdateVersionStage(event.SecretId, secretsmanager.StageCurrent, event.ClientRequestToken, current)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("{Rotation} Promoted key", pending.ID, "to primary")
+
+	return r.secrets.UpdateVersionStage(event.SecretId, secretsmanager.StagePending, "", event.ClientRequestToken)
+}
+
+// pendingKeyset returns the keyset of the version being rotated and its new primary key.
+func (r *Rotator) pendingKeyset(event Event) (*keyset.Keyset, keyset.Key, error) {
+	value, err := r.secrets.GetSecretVersion(event.SecretId, event.ClientRequestToken, secretsmanager.StagePending)
+	if err != nil {
+		return nil, keyset.Key{}, err
+	}
+
+	ks, err := keyset.Parse(value)
+	if err != nil {
+		return nil, keyset.Key{}, err
+	}
+
+	pending := ks.Primary()
+
+	value, err = r.secrets.GetSecretVersion(event.SecretId, "", secretsmanager.StageCurrent)
+	if err != nil {
+		return nil, keyset.Key{}, err
+	}
+
+	current, err := keyset.Parse(value)
+	if err == keyset.ErrUninitialized {
+		return ks, pending, nil
+	}
+	if err != nil {
+		return nil, keyset.Key{}, err
+	}
+
+	if _, err := current.Lookup(pending.ID); err == nil {
+		return nil, keyset.Key{}, fmt.Errorf("primary key %s of the pending keyset is not new", pending.ID)
+	}
+
+	return ks, pending, nil
+}
+
+// SelfTest checks that FF1 under dek and tweak round-trips and actually changes its inputs.
+func SelfTest(dek []byte, tweak []byte) error {
+	changed := false
+
+	for _, test := range selfTests {
+		FF1, err := ff1.NewCipher(test.radix, len(tweak), dek, tweak)
+		if err != nil {
+			return err
+		}
+
+		ciphertext, err := FF1.Encrypt(test.input)
+		if err != nil {
+			return err
+		}
+
+		plaintext, err := FF1.Decrypt(ciphertext)
+		if err != nil {
+			return err
+		}
+
+		if plaintext != test.input {
+			return fmt.Errorf("FF1 round trip failed for radix %d", test.radix)
+		}
+
+		changed = changed || ciphertext != test.input
+	}
+
+	if !changed {
+		return errors.New("FF1 left every self-test input unchanged")
+	}
+
+	return nil
+}
+
+func has(labels []string, stage string) bool {
+	for _, l := range labels {
+		if l == stage {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package rotation
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/keyset"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/kms"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/secretsmanager"
+)
+
+const (
+	testSecret = "/secret/fpe/dek"
+	testToken  = "6f3c2a8e-1b4d-4e5f-9a7b-0c1d2e3f4a5b"
+)
+
+var testContext = kms.EncryptionContext{"service": "fpe", "secretName": testSecret}
+
+func newTestRotator(t *testing.T) (*Rotator, *secretsmanager.MemoryStore, kms.KeyManager) {
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+
+	wrapped, _ := keys.GenerateDataKey("", testContext)
+	dek, _ := keys.DecryptDataKey(wrapped, testContext)
+	ks, _ := keyset.New(wrapped, dek, testContext)
+	value, _ := ks.Encode()
+	if err := store.CreateSecret(testSecret, value, ""); err != nil {
+		t.Fatalf("CreateSecret: %v", err)
+	}
+
+	tweak, _ := hex.DecodeString("D8E7920AFA330A73")
+
+	return NewRotator(keys, store, "", tweak, nil), store, keys
+}
+
+func rotate(t *testing.T, r *Rotator, token string, steps ...string) {
+	for _, step := range steps {
+		if err := r.Rotate(context.Background(), Event{testSecret, token, step}); err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+	}
+}
+
+func currentKeyset(t *testing.T, store *secretsmanager.MemoryStore) *keyset.Keyset {
+	value, err := store.GetSecret(testSecret)
+	if err != nil {
+		t.Fatalf("GetSecret: %v", err)
+	}
+
+	ks, err := keyset.Parse(value)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return ks
+}
+
+func TestRotation(t *testing.T) {
+	r, store, _ := newTestRotator(t)
+	old := currentKeyset(t, store).Primary()
+
+	rotate(t, r, testToken, StepCreateSecret, StepCreateSecret, StepSetSecret, StepTestSecret)
+
+	// Until finishSecret the service keeps using the current keyset
+	if ks := currentKeyset(t, store); len(ks.Keys) != 1 || ks.Primary().ID != old.ID {
+		t.Fatalf("Current keyset changed before finishSecret: %+v", ks.Keys)
+	}
+
+	rotate(t, r, testToken, StepFinishSecret, StepFinishSecret)
+
+	ks := currentKeyset(t, store)
+	if len(ks.Keys) != 2 || ks.Primary().ID == old.ID {
+		t.Fatalf("Expected a new primary key, got %+v", ks.Keys)
+	}
+
+	if !testContext.Equal(ks.Primary().EncryptionContext) {
+		t.Fatalf("Expected the new key to keep the encryption context, got %v", ks.Primary().EncryptionContext)
+	}
+
+	if k, _ := ks.Lookup(old.ID); k.Status != keyset.StatusActive {
+		t.Fatalf("Expected the former primary key to stay active, got %s", k.Status)
+	}
+
+	if _, err := store.GetSecretVersion(testSecret, "", secretsmanager.StagePending); err != secretsmanager.ErrSecretNotFound {
+		t.Fatalf("Expected no pending version after finishSecret, got %v", err)
+	}
+
+	// A second rotation builds on the first one
+	rotate(t, r, "0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d", StepCreateSecret, StepSetSecret, StepTestSecret, StepFinishSecret)
+
+	if ks := currentKeyset(t, store); len(ks.Keys) != 3 {
+		t.Fatalf("Expected three keys, got %+v", ks.Keys)
+	}
+}
+
+func TestRotationStages(t *testing.T) {
+	r, store, _ := newTestRotator(t)
+
+	stages, _ := store.VersionStages(testSecret)
+	initial := ""
+	for versionId := range stages {
+		initial = versionId
+	}
+
+	rotate(t, r, testToken, StepCreateSecret, StepSetSecret, StepTestSecret, StepFinishSecret)
+
+	stages, err := store.VersionStages(testSecret)
+	if err != nil {
+		t.Fatalf("VersionStages: %v", err)
+	}
+
+	// The version of the rotation token becomes current, as Secrets Manager expects
+	if labels := stages[testToken]; !has(labels, secretsmanager.StageCurrent) || has(labels, secretsmanager.StagePending) {
+		t.Fatalf("Expected version %s to be AWSCURRENT only, got %v", testToken, labels)
+	}
+
+	if labels := stages[initial]; !has(labels, secretsmanager.StagePrevious) || has(labels, secretsmanager.StageCurrent) {
+		t.Fatalf("Expected version %s to be AWSPREVIOUS, got %v", initial, labels)
+	}
+
+	if len(stages) != 2 {
+		t.Fatalf("Expected the initial and the rotated version only, got %v", stages)
+	}
+
+	value, _ := store.GetSecretVersion(testSecret, testToken, "")
+	if current, _ := store.GetSecret(testSecret); current != value {
+		t.Fatalf("Expected the rotated version to be the value of the secret")
+	}
+}
+
+func TestRotationInitializes(t *testing.T) {
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+	if err := store.CreateSecret(testSecret, keyset.Uninitialized, ""); err != nil {
+		t.Fatalf("CreateSecret: %v", err)
+	}
+
+	tweak, _ := hex.DecodeString("D8E7920AFA330A73")
+	r := NewRotator(keys, store, "", tweak, nil)
+
+	rotate(t, r, testToken, StepCreateSecret, StepSetSecret, StepTestSecret, StepFinishSecret)
+
+	ks := currentKeyset(t, store)
+	if len(ks.Keys) != 1 || ks.Primary().ID == "" {
+		t.Fatalf("Expected a keyset of one primary key, got %+v", ks.Keys)
+	}
+}
+
+func TestRotationBindsConfiguredContext(t *testing.T) {
+	keys := kms.NewMemoryKeyManager()
+	store := secretsmanager.NewMemoryStore()
+
+	// A keyset from before encryption contexts
+	wrapped, _ := keys.GenerateDataKey("", nil)
+	dek, _ := keys.DecryptDataKey(wrapped, nil)
+	ks, _ := keyset.New(wrapped, dek, nil)
+	value, _ := ks.Encode()
+	store.CreateSecret(testSecret, value, "")
+
+	tweak, _ := hex.DecodeString("D8E7920AFA330A73")
+	r := NewRotator(keys, store, "", tweak, func(secretId string) Scope {
+		return Scope{EncryptionContext: kms.EncryptionContext{"service": "fpe", "secretName": secretId}, Tenant: "acme"}
+	})
+
+	rotate(t, r, testToken, StepCreateSecret, StepSetSecret, StepTestSecret, StepFinishSecret)
+
+	ks = currentKeyset(t, store)
+	if !testContext.Equal(ks.Primary().EncryptionContext) {
+		t.Fatalf("Expected the new key to be bound to the configured context, got %v", ks.Primary().EncryptionContext)
+	}
+}
+
+func TestRotationRejectsBrokenKey(t *testing.T) {
+	r, store, keys := newTestRotator(t)
+
+	rotate(t, r, testToken, StepCreateSecret)
+
+	// Replace the new key with one that does not match its check value
+	value, _ := store.GetSecretVersion(testSecret, testToken, secretsmanager.StagePending)
+	ks, _ := keyset.Parse(value)
+	other, _ := keys.GenerateDataKey("", testContext)
+	for i := range ks.Keys {
+		if ks.Keys[i].Status == keyset.StatusPrimary {
+			ks.Keys[i].WrappedKey = hex.EncodeToString(other)
+		}
+	}
+	value, _ = ks.Encode()
+
+	broken := "f0e1d2c3-b4a5-4968-8776-655443322110"
+	store.PutSecretVersion(testSecret, broken, value, []string{secretsmanager.StagePending})
+
+	if err := r.Rotate(context.Background(), Event{testSecret, broken, StepTestSecret}); err == nil {
+		t.Fatalf("Expected testSecret to fail")
+	}
+
+	if err := r.Rotate(context.Background(), Event{testSecret, testToken, StepTestSecret}); err == nil {
+		t.Fatalf("Expected testSecret to fail for a version no longer pending")
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	key, _ := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94")
+
+	if err := SelfTest(key, []byte{0xD8, 0xE7}); err != nil {
+		t.Fatalf("SelfTest: %v", err)
+	}
+
+	if err := SelfTest(key[:7], nil); err == nil {
+		t.Fatalf("Expected SelfTest to fail for an invalid key")
+	}
+}
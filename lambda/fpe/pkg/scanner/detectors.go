@@ -0,0 +1,102 @@
+package scanner
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/cyclewalk"
+)
+
+// Types of the built-in detectors
+const (
+	TypeEmail = "email"
+	TypeRRN   = "rrn"
+	TypeCard  = "card"
+	TypePhone = "phone"
+)
+
+var builtins = map[string]Detector{
+	TypeEmail: {
+		Type:    TypeEmail,
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	},
+	// Korean resident registration number: birth date, gender digit, 5 digits and a check digit
+	TypeRRN: {
+		Type:     TypeRRN,
+		Pattern:  regexp.MustCompile(`\b\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])-?[1-8]\d{6}\b`),
+		Validate: func(match string) bool { return RRN(digitsOf(match)) },
+	},
+	// 13 to 19 digits, optionally grouped by spaces or dashes
+	TypeCard: {
+		Type:     TypeCard,
+		Pattern:  regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		Validate: func(match string) bool { return cyclewalk.Luhn(digitsOf(match)) },
+	},
+	// Korean mobile and landline numbers
+	TypePhone: {
+		Type:    TypePhone,
+		Pattern: regexp.MustCompile(`\b(?:01[016789]|02|0[3-6][1-5])[- ]?\d{3,4}[- ]?\d{4}\b`),
+	},
+}
+
+// DefaultTypes lists the built-in detectors in order of priority.
+var DefaultTypes = []string{TypeEmail, TypeRRN, TypeCard, TypePhone}
+
+// Builtin returns the built-in detector for type t.
+func Builtin(t string) (Detector, bool) {
+	d, ok := builtins[t]
+	return d, ok
+}
+
+// BuiltinTypes returns the types of all built-in detectors in sorted order.
+func BuiltinTypes() []string {
+	types := make([]string, 0, len(builtins))
+	for t := range builtins {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// RRN reports whether s is a 13-digit resident registration number with a valid check digit.
+func RRN(s string) bool {
+	if len(s) != 13 {
+		return false
+	}
+
+	weights := [12]int{2, 3, 4, 5, 6, 7, 8, 9, 2, 3, 4, 5}
+	sum := 0
+	for i, w := range weights {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+		sum += int(s[i]-'0') * w
+	}
+
+	return s[12] == byte('0'+(11-sum%11)%10)
+}
+
+// digitsOf returns the digits of s, dropping separators
+func digitsOf(s string) string {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			out = append(out, s[i])
+		}
+	}
+	return string(out)
+}
+
+// withDigits puts digits back into the positions of the digits of s, keeping separators
+func withDigits(s string, digits string) string {
+	out := []byte(s)
+	j := 0
+	for i := range out {
+		if out[i] >= '0' && out[i] <= '9' {
+			out[i] = digits[j]
+			j++
+		}
+	}
+	return string(out)
+}
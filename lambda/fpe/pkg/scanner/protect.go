@@ -0,0 +1,277 @@
+package scanner
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/cyclewalk"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ff1"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/regexfpe"
+)
+
+// Alphanumerics of the local part of an email address are enciphered within this language
+const emailLocalPattern = `[0-9A-Za-z]+`
+
+// DetectorSpec selects a built-in detector by type, or defines a custom one by pattern.
+// Custom patterns must be accepted by regexfpe, which enciphers their matches.
+type DetectorSpec struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// A Protector replaces findings with format-preserving tokens and restores them again.
+//
+// Tokens stay valid values of their type (card tokens pass Luhn, RRN tokens keep birth
+// date, gender digit and a valid check digit, custom tokens match their pattern). They
+// do not carry their type, though: a card token may also be a valid RRN, and scanning
+// the protected text would restore it as one. Restore is therefore given the findings
+// returned by Protect instead of detecting tokens again.
+type Protector struct {
+	scanner    *Scanner
+	transforms map[string]transform
+	spans      map[string]Detector
+}
+
+type transform struct {
+	protect func(string) (string, error)
+	restore func(string) (string, error)
+}
+
+// NewProtector returns a protector running the given detectors, or DefaultTypes if none
+// are given, keyed with key and tweak.
+func NewProtector(specs []DetectorSpec, key []byte, tweak []byte) (*Protector, error) {
+	if len(specs) == 0 {
+		for _, t := range DefaultTypes {
+			specs = append(specs, DetectorSpec{Type: t})
+		}
+	}
+
+	FF1, err := ff1.NewCipher(10, len(tweak), key, tweak)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Protector{transforms: map[string]transform{}, spans: map[string]Detector{}}
+	var detectors []Detector
+
+	for _, spec := range specs {
+		if spec.Type == "" {
+			return nil, errors.New("detector type must not be empty")
+		}
+		if _, ok := p.transforms[spec.Type]; ok {
+			return nil, errors.New("detector type is given twice: " + spec.Type)
+		}
+
+		var d Detector
+		var t transform
+
+		if spec.Pattern != "" {
+			if _, ok := builtins[spec.Type]; ok {
+				return nil, errors.New("custom detector must not reuse a built-in type: " + spec.Type)
+			}
+
+			re, err := regexp.Compile(spec.Pattern)
+			if err != nil {
+				return nil, err
+			}
+
+			c, err := regexfpe.NewCipher(spec.Pattern, key, tweak)
+			if err != nil {
+				return nil, err
+			}
+
+			d = Detector{Type: spec.Type, Pattern: re}
+			t = transform{c.Encrypt, c.Decrypt}
+		} else {
+			var ok bool
+			if d, ok = builtins[spec.Type]; !ok {
+				return nil, ErrUnknownDetector
+			}
+
+			if t, err = builtinTransform(spec.Type, FF1, key, tweak); err != nil {
+				return nil, err
+			}
+		}
+
+		detectors = append(detectors, d)
+		p.transforms[spec.Type] = t
+
+		// Findings given to Restore must be whole matches of the pattern, tokens are
+		span, err := regexp.Compile(`^(?:` + d.Pattern.String() + `)$`)
+		if err != nil {
+			return nil, err
+		}
+		p.spans[spec.Type] = Detector{Type: d.Type, Pattern: span, Validate: d.Validate}
+	}
+
+	p.scanner = New(detectors...)
+
+	return p, nil
+}
+
+// Protect replaces every finding in text with its token.
+func (p *Protector) Protect(text string) (string, []Finding, error) {
+	findings := p.scanner.Scan(text)
+
+	out, err := p.rewrite(text, findings, func(t transform) func(string) (string, error) { return t.protect })
+	if err != nil {
+		return "", nil, err
+	}
+
+	return out, findings, nil
+}
+
+// Restore replaces the tokens at findings, as returned by Protect, with the values they
+// were created from. Every finding must still be detected as its type, as tokens are.
+func (p *Protector) Restore(text string, findings []Finding) (string, error) {
+	end := 0
+	for _, f := range findings {
+		if f.Start < end || f.End < f.Start || f.End > len(text) || !p.detects(f.Type, text[f.Start:f.End]) {
+			return "", ErrInvalidFindings
+		}
+		end = f.End
+	}
+
+	restored, err := p.rewrite(text, findings, func(t transform) func(string) (string, error) { return t.restore })
+	if err != nil {
+		// The ciphers were set up by NewProtector; they only fail on values they did not
+		// create, such as a span of the wrong length or outside the cycle-walked domain.
+		return "", ErrInvalidFindings
+	}
+
+	return restored, nil
+}
+
+// detects reports whether the detector of type t finds value as a whole
+func (p *Protector) detects(t string, value string) bool {
+	d, ok := p.spans[t]
+	if !ok || !d.Pattern.MatchString(value) {
+		return false
+	}
+
+	return d.Validate == nil || d.Validate(value)
+}
+
+func (p *Protector) rewrite(text string, findings []Finding, direction func(transform) func(string) (string, error)) (string, error) {
+	return Rewrite(text, findings, func(f Finding, value string) (string, error) {
+		return direction(p.transforms[f.Type])(value)
+	})
+}
+
+func builtinTransform(t string, FF1 ff1.Cipher, key []byte, tweak []byte) (transform, error) {
+	switch t {
+	case TypeCard:
+		c, err := cyclewalk.NewCipher(FF1, cyclewalk.Luhn, 0)
+		if err != nil {
+			return transform{}, err
+		}
+		return digitTransform(0, func(string) (cyclewalk.Cipher, error) { return c, nil }), nil
+
+	case TypeRRN:
+		// Birth date and gender digit are kept; the rest cycle-walks to a valid check digit
+		return digitTransform(7, func(prefix string) (cyclewalk.Cipher, error) {
+			return cyclewalk.NewCipher(FF1, func(rest string) bool { return RRN(prefix + rest) }, 0)
+		}), nil
+
+	case TypePhone:
+		// The area or carrier code is kept
+		return transform{
+			protect: func(v string) (string, error) { return phoneTransform(FF1.Encrypt, v) },
+			restore: func(v string) (string, error) { return phoneTransform(FF1.Decrypt, v) },
+		}, nil
+
+	case TypeEmail:
+		c, err := regexfpe.NewCipher(emailLocalPattern, key, tweak)
+		if err != nil {
+			return transform{}, err
+		}
+		return transform{
+			protect: func(v string) (string, error) { return emailTransform(c.Encrypt, v) },
+			restore: func(v string) (string, error) { return emailTransform(c.Decrypt, v) },
+		}, nil
+	}
+
+	return transform{}, ErrUnknownDetector
+}
+
+// digitTransform enciphers the digits of a value after the first keep digits, leaving separators in place
+func digitTransform(keep int, cipherFor func(prefix string) (cyclewalk.Cipher, error)) transform {
+	apply := func(v string, decrypt bool) (string, error) {
+		digits := digitsOf(v)
+		prefix := digits[:keep]
+
+		c, err := cipherFor(prefix)
+		if err != nil {
+			return "", err
+		}
+
+		var rest string
+		if decrypt {
+			rest, err = c.Decrypt(digits[keep:])
+		} else {
+			rest, err = c.Encrypt(digits[keep:])
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return withDigits(v, prefix+rest), nil
+	}
+
+	return transform{
+		protect: func(v string) (string, error) { return apply(v, false) },
+		restore: func(v string) (string, error) { return apply(v, true) },
+	}
+}
+
+func phoneTransform(f func(string) (string, error), v string) (string, error) {
+	digits := digitsOf(v)
+
+	keep := 3
+	if strings.HasPrefix(digits, "02") {
+		keep = 2
+	}
+
+	rest, err := f(digits[keep:])
+	if err != nil {
+		return "", err
+	}
+
+	return withDigits(v, digits[:keep]+rest), nil
+}
+
+// emailTransform enciphers the alphanumerics of the local part, keeping punctuation and the domain
+func emailTransform(f func(string) (string, error), v string) (string, error) {
+	at := strings.LastIndexByte(v, '@')
+	local := []byte(v[:at])
+
+	var alnum []byte
+	for _, b := range local {
+		if isAlnum(b) {
+			alnum = append(alnum, b)
+		}
+	}
+	if len(alnum) == 0 {
+		return v, nil
+	}
+
+	enciphered, err := f(string(alnum))
+	if err != nil {
+		return "", err
+	}
+
+	j := 0
+	for i, b := range local {
+		if isAlnum(b) {
+			local[i] = enciphered[j]
+			j++
+		}
+	}
+
+	return string(local) + v[at:], nil
+}
+
+func isAlnum(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
@@ -0,0 +1,107 @@
+// Package scanner finds personal data embedded in free text and rewrites it in place.
+//
+// Detectors combine a regular expression with an optional validator (Luhn, RRN checksum)
+// that filters out matches that merely look like personal data. Overlapping findings are
+// resolved leftmost first, then by the order of the detectors.
+package scanner
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+)
+
+var (
+	// ErrUnknownDetector is returned for detector types that are neither built in nor given a pattern
+	ErrUnknownDetector = errors.New("unknown detector")
+
+	// ErrInvalidFindings is returned for findings that are out of order, overlap, exceed the
+	// text, have a type without detector or do not locate a value of their type
+	ErrInvalidFindings = errors.New("invalid findings")
+)
+
+// A Validator reports whether a regular expression match really is a finding
+type Validator func(match string) bool
+
+// A Detector finds values of one type of personal data
+type Detector struct {
+	Type     string
+	Pattern  *regexp.Regexp
+	Validate Validator
+}
+
+// A Finding locates a detected value. Offsets are byte offsets into the text; since
+// every transform preserves the length of a value, they hold for the input and the output.
+type Finding struct {
+	Type  string `json:"type"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// A Scanner runs a set of detectors over text
+type Scanner struct {
+	detectors []Detector
+}
+
+// New returns a scanner running detectors in order of priority.
+func New(detectors ...Detector) *Scanner {
+	return &Scanner{detectors: append([]Detector(nil), detectors...)}
+}
+
+// Scan returns the non-overlapping findings in text, ordered by offset.
+func (s *Scanner) Scan(text string) []Finding {
+	type candidate struct {
+		Finding
+		priority int
+	}
+
+	var candidates []candidate
+	for priority, d := range s.detectors {
+		for _, loc := range d.Pattern.FindAllStringIndex(text, -1) {
+			if d.Validate != nil && !d.Validate(text[loc[0]:loc[1]]) {
+				continue
+			}
+			candidates = append(candidates, candidate{Finding{d.Type, loc[0], loc[1]}, priority})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Start != candidates[j].Start {
+			return candidates[i].Start < candidates[j].Start
+		}
+		return candidates[i].priority < candidates[j].priority
+	})
+
+	var findings []Finding
+	end := 0
+	for _, c := range candidates {
+		if c.Start < end {
+			continue
+		}
+		findings = append(findings, c.Finding)
+		end = c.End
+	}
+
+	return findings
+}
+
+// Rewrite replaces every finding in text with the value returned by replace.
+// Findings must be ordered and non-overlapping, as returned by Scan.
+func Rewrite(text string, findings []Finding, replace func(f Finding, value string) (string, error)) (string, error) {
+	out := make([]byte, 0, len(text))
+	last := 0
+
+	for _, f := range findings {
+		value, err := replace(f, text[f.Start:f.End])
+		if err != nil {
+			return "", err
+		}
+
+		out = append(out, text[last:f.Start]...)
+		out = append(out, value...)
+		last = f.End
+	}
+	out = append(out, text[last:]...)
+
+	return string(out), nil
+}
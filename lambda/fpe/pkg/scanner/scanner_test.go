@@ -0,0 +1,175 @@
+package scanner
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/cyclewalk"
+)
+
+const (
+	testKey   = "2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94"
+	testTweak = "D8E7920AFA330A73"
+)
+
+func newTestProtector(t *testing.T, specs []DetectorSpec) *Protector {
+	key, err := hex.DecodeString(testKey)
+	if err != nil {
+		t.Fatalf("Unable to decode hex key: %v", testKey)
+	}
+
+	tweak, err := hex.DecodeString(testTweak)
+	if err != nil {
+		t.Fatalf("Unable to decode tweak: %v", testTweak)
+	}
+
+	p, err := NewProtector(specs, key, tweak)
+	if err != nil {
+		t.Fatalf("Unable to create protector: %v", err)
+	}
+
+	return p
+}
+
+func TestScan(t *testing.T) {
+	text := "Customer hong.gildong@example.co.kr (RRN 900101-1234568) paid with 4111 1111 1111 1111, " +
+		"call 010-1234-5678. Order 1234567890123 is not a card."
+
+	var types []string
+	for _, f := range New(defaultDetectors()...).Scan(text) {
+		types = append(types, f.Type+"="+text[f.Start:f.End])
+	}
+
+	expected := []string{
+		"email=hong.gildong@example.co.kr",
+		"rrn=900101-1234568",
+		"card=4111 1111 1111 1111",
+		"phone=010-1234-5678",
+	}
+	if strings.Join(types, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected findings.\nExpected: %v\nGot: %v", expected, types)
+	}
+}
+
+func TestProtectRestore(t *testing.T) {
+	p := newTestProtector(t, nil)
+
+	text := "티켓 #42: hong.gildong@example.co.kr, 900101-1234568, 4111-1111-1111-1111, 02-123-4567."
+
+	protected, findings, err := p.Protect(text)
+	if err != nil {
+		t.Fatalf("Protect: %v", err)
+	}
+
+	if len(findings) != 4 {
+		t.Fatalf("Expected 4 findings, got %v", findings)
+	}
+
+	for _, f := range findings {
+		original, token := text[f.Start:f.End], protected[f.Start:f.End]
+		if original == token {
+			t.Fatalf("%s finding was not protected: %q", f.Type, original)
+		}
+	}
+
+	rrn := protected[findings[1].Start:findings[1].End]
+	if !strings.HasPrefix(rrn, "900101-1") || !RRN(digitsOf(rrn)) {
+		t.Fatalf("RRN token is not a valid RRN with the same birth date: %q", rrn)
+	}
+
+	if card := protected[findings[2].Start:findings[2].End]; !cyclewalk.Luhn(digitsOf(card)) {
+		t.Fatalf("Card token does not pass Luhn: %q", card)
+	}
+
+	if !strings.Contains(protected, "@example.co.kr") || !strings.Contains(protected, " 02-") {
+		t.Fatalf("Email domain or area code was not kept: %q", protected)
+	}
+
+	restored, err := p.Restore(protected, findings)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if restored != text {
+		t.Fatalf("Round trip failed.\nExpected: %v\nGot: %v", text, restored)
+	}
+
+	for _, invalid := range [][]Finding{
+		{{Type: TypeCard, Start: 10, End: 20}, {Type: TypeCard, Start: 15, End: 25}},
+		{{Type: "passport", Start: 0, End: 4}},
+		{{Type: TypeCard, Start: 0, End: len(protected) + 1}},
+	} {
+		if _, err := p.Restore(protected, invalid); err != ErrInvalidFindings {
+			t.Fatalf("Expected ErrInvalidFindings for %v, got %v", invalid, err)
+		}
+	}
+}
+
+func TestRestoreMalformedFindings(t *testing.T) {
+	p := newTestProtector(t, []DetectorSpec{{Type: TypeEmail}, {Type: TypeRRN}, {Type: TypeCard}, {Type: TypePhone}, {Type: "employee", Pattern: `EMP-\d{6}`}})
+
+	for _, test := range []struct {
+		name    string
+		text    string
+		finding Finding
+	}{
+		{"email without @", "abc", Finding{Type: TypeEmail, Start: 0, End: 3}},
+		{"email without local part", "@example.com", Finding{Type: TypeEmail, Start: 0, End: 12}},
+		{"short phone", "1", Finding{Type: TypePhone, Start: 0, End: 1}},
+		{"phone without area code", "123-4567", Finding{Type: TypePhone, Start: 0, End: 8}},
+		{"short RRN", "123", Finding{Type: TypeRRN, Start: 0, End: 3}},
+		{"RRN with invalid check digit", "900101-1234567", Finding{Type: TypeRRN, Start: 0, End: 14}},
+		{"card failing Luhn", "4111-1111-1111-1112", Finding{Type: TypeCard, Start: 0, End: 19}},
+		{"part of a card", "4111-1111-1111-1111", Finding{Type: TypeCard, Start: 0, End: 4}},
+		{"empty span", "abc", Finding{Type: TypeCard, Start: 1, End: 1}},
+		{"custom type outside pattern", "EMP-12", Finding{Type: "employee", Start: 0, End: 6}},
+	} {
+		if _, err := p.Restore(test.text, []Finding{test.finding}); err != ErrInvalidFindings {
+			t.Errorf("%s: expected ErrInvalidFindings, got %v", test.name, err)
+		}
+	}
+}
+
+func TestCustomDetector(t *testing.T) {
+	p := newTestProtector(t, []DetectorSpec{{Type: "employee", Pattern: `EMP-\d{6}`}})
+
+	text := "Escalated by EMP-004211 and EMP-100000."
+
+	protected, findings, err := p.Protect(text)
+	if err != nil {
+		t.Fatalf("Protect: %v", err)
+	}
+
+	if len(findings) != 2 || findings[0].Type != "employee" {
+		t.Fatalf("Unexpected findings: %v", findings)
+	}
+
+	restored, err := p.Restore(protected, findings)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if restored != text {
+		t.Fatalf("Round trip failed.\nExpected: %v\nGot: %v", text, restored)
+	}
+}
+
+func TestRRN(t *testing.T) {
+	if !RRN("9001011234568") {
+		t.Fatalf("Expected valid RRN")
+	}
+
+	if RRN("9001011234567") || RRN("900101123456") {
+		t.Fatalf("Expected invalid RRN")
+	}
+}
+
+func defaultDetectors() []Detector {
+	var detectors []Detector
+	for _, t := range DefaultTypes {
+		d, _ := Builtin(t)
+		detectors = append(detectors, d)
+	}
+	return detectors
+}
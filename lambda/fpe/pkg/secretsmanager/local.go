@@ -0,0 +1,455 @@
+package secretsmanager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Version ID given to the value of secrets stored before versions were kept
+const initialVersionId = "initial"
+
+// Value mirrors the version labeled StageCurrent, so files written before versions were kept still load.
+type localSecret struct {
+	Value       string         `json:"value"`
+	Description string         `json:"description,omitempty"`
+	Versions    []localVersion `json:"versions,omitempty"`
+}
+
+type localVersion struct {
+	Id     string   `json:"id"`
+	Value  string   `json:"value"`
+	Stages []string `json:"stages,omitempty"`
+}
+
+func newLocalSecret(value string, description string) (localSecret, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return localSecret{}, err
+	}
+
+	return localSecret{
+		Value:       value,
+		Description: description,
+		Versions:    []localVersion{{hex.EncodeToString(id), value, []string{StageCurrent}}},
+	}, nil
+}
+
+func (s *localSecret) versions() []localVersion {
+	if len(s.Versions) == 0 {
+		s.Versions = []localVersion{{initialVersionId, s.Value, []string{StageCurrent}}}
+	}
+	return s.Versions
+}
+
+func (v localVersion) has(stage string) bool {
+	for _, s := range v.Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *localVersion) remove(stage string) {
+	stages := v.Stages[:0]
+	for _, s := range v.Stages {
+		if s != stage {
+			stages = append(stages, s)
+		}
+	}
+	v.Stages = stages
+}
+
+func (s *localSecret) get(versionId string, stage string) (string, error) {
+	if versionId == "" && stage == "" {
+		stage = StageCurrent
+	}
+
+	for _, v := range s.versions() {
+		if (versionId == "" || v.Id == versionId) && (stage == "" || v.has(stage)) {
+			return v.Value, nil
+		}
+	}
+
+	return "", ErrSecretNotFound
+}
+
+func (s *localSecret) put(versionId string, value string, stages []string) error {
+	if versionId == "" {
+		return errors.New("version ID must not be empty")
+	}
+
+	for _, v := range s.versions() {
+		if v.Id == versionId {
+			if v.Value != value {
+				return ErrVersionExists
+			}
+			return nil
+		}
+	}
+
+	if len(stages) == 0 {
+		stages = []string{StageCurrent}
+	}
+
+	s.Versions = append(s.Versions, localVersion{Id: versionId, Value: value})
+	for _, stage := range stages {
+		if err := s.move(stage, versionId, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// move attaches stage to moveTo only, and detaches it from removeFrom, which must hold it
+func (s *localSecret) move(stage string, moveTo string, removeFrom string) error {
+	versions := s.versions()
+
+	index := func(id string) int {
+		for i := range versions {
+			if versions[i].Id == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	from := -1
+	if removeFrom != "" {
+		if from = index(removeFrom); from < 0 || !versions[from].has(stage) {
+			return errors.New("version " + removeFrom + " is not labeled " + stage)
+		}
+	}
+
+	to := -1
+	if moveTo != "" {
+		if to = index(moveTo); to < 0 {
+			return ErrSecretNotFound
+		}
+	}
+
+	for i := range versions {
+		if i == to || !versions[i].has(stage) {
+			continue
+		}
+		if to >= 0 || i == from {
+			versions[i].remove(stage)
+			if stage == StageCurrent && to >= 0 {
+				for j := range versions {
+					versions[j].remove(StagePrevious)
+				}
+				versions[i].Stages = append(versions[i].Stages, StagePrevious)
+			}
+		}
+	}
+
+	if to < 0 {
+		return nil
+	}
+
+	if !versions[to].has(stage) {
+		versions[to].Stages = append(versions[to].Stages, stage)
+	}
+	if stage == StageCurrent {
+		versions[to].remove(StagePrevious)
+		s.Value = versions[to].Value
+	}
+
+	return nil
+}
+
+func (s *localSecret) stages() map[string][]string {
+	stages := map[string][]string{}
+	for _, v := range s.versions() {
+		stages[v.Id] = append([]string(nil), v.Stages...)
+	}
+	return stages
+}
+
+// MemoryStore is a VersionedStore that only lives as long as the process; meant for tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	secrets map[string]localSecret
+}
+
+// NewMemoryStore returns an empty in-memory secret store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{secrets: map[string]localSecret{}}
+}
+
+// GetSecret implements SecretStore.
+func (m *MemoryStore) GetSecret(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	secret, ok := m.secrets[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+
+	return secret.Value, nil
+}
+
+// CreateSecret implements SecretStore.
+func (m *MemoryStore) CreateSecret(name string, value string, description string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.secrets[name]; ok {
+		return ErrSecretExists
+	}
+
+	secret, err := newLocalSecret(value, description)
+	if err != nil {
+		return err
+	}
+	m.secrets[name] = secret
+
+	return nil
+}
+
+// GetSecretVersion implements VersionedStore.
+func (m *MemoryStore) GetSecretVersion(name string, versionId string, stage string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	secret, ok := m.secrets[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+
+	return secret.get(versionId, stage)
+}
+
+// PutSecretVersion implements VersionedStore.
+func (m *MemoryStore) PutSecretVersion(name string, versionId string, value string, stages []string) error {
+	return m.update(name, func(secret *localSecret) error {
+		return secret.put(versionId, value, stages)
+	})
+}
+
+// UpdateVersionStage implements VersionedStore.
+func (m *MemoryStore) UpdateVersionStage(name string, stage string, moveToVersionId string, removeFromVersionId string) error {
+	return m.update(name, func(secret *localSecret) error {
+		return secret.move(stage, moveToVersionId, removeFromVersionId)
+	})
+}
+
+// VersionStages implements VersionedStore.
+func (m *MemoryStore) VersionStages(name string) (map[string][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	secret, ok := m.secrets[name]
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+
+	return secret.stages(), nil
+}
+
+// update applies f to a copy of the secret and keeps it only if f succeeds
+func (m *MemoryStore) update(name string, f func(*localSecret) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	secret, ok := m.secrets[name]
+	if !ok {
+		return ErrSecretNotFound
+	}
+
+	secret.Versions = copyVersions(secret.versions())
+	if err := f(&secret); err != nil {
+		return err
+	}
+	m.secrets[name] = secret
+
+	return nil
+}
+
+func copyVersions(versions []localVersion) []localVersion {
+	copied := make([]localVersion, len(versions))
+	for i, v := range versions {
+		copied[i] = localVersion{v.Id, v.Value, append([]string(nil), v.Stages...)}
+	}
+	return copied
+}
+
+// FileStore is a VersionedStore persisted as a JSON document in a local file.
+// Secrets are stored as given; the data keys the service keeps in it are already
+// wrapped by the key manager, so the file needs no encryption of its own.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore returns a store backed by path; the file is created on the first write.
+func NewFileStore(path string) (*FileStore, error) {
+	if path == "" {
+		return nil, errors.New("secret store path must not be empty")
+	}
+
+	return &FileStore{path: path}, nil
+}
+
+// GetSecret implements SecretStore.
+func (f *FileStore) GetSecret(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.load()
+	if err != nil {
+		return "", err
+	}
+
+	secret, ok := secrets[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+
+	return secret.Value, nil
+}
+
+// CreateSecret implements SecretStore.
+func (f *FileStore) CreateSecret(name string, value string, description string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := secrets[name]; ok {
+		return ErrSecretExists
+	}
+
+	secret, err := newLocalSecret(value, description)
+	if err != nil {
+		return err
+	}
+	secrets[name] = secret
+
+	return f.save(secrets)
+}
+
+// GetSecretVersion implements VersionedStore.
+func (f *FileStore) GetSecretVersion(name string, versionId string, stage string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.load()
+	if err != nil {
+		return "", err
+	}
+
+	secret, ok := secrets[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+
+	return secret.get(versionId, stage)
+}
+
+// PutSecretVersion implements VersionedStore.
+func (f *FileStore) PutSecretVersion(name string, versionId string, value string, stages []string) error {
+	return f.update(name, func(secret *localSecret) error {
+		return secret.put(versionId, value, stages)
+	})
+}
+
+// UpdateVersionStage implements VersionedStore.
+func (f *FileStore) UpdateVersionStage(name string, stage string, moveToVersionId string, removeFromVersionId string) error {
+	return f.update(name, func(secret *localSecret) error {
+		return secret.move(stage, moveToVersionId, removeFromVersionId)
+	})
+}
+
+// VersionStages implements VersionedStore.
+func (f *FileStore) VersionStages(name string) (map[string][]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, ok := secrets[name]
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+
+	return secret.stages(), nil
+}
+
+// update applies f to the secret and saves the store only if fn succeeds
+func (f *FileStore) update(name string, fn func(*localSecret) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+
+	secret, ok := secrets[name]
+	if !ok {
+		return ErrSecretNotFound
+	}
+
+	if err := fn(&secret); err != nil {
+		return err
+	}
+	secrets[name] = secret
+
+	return f.save(secrets)
+}
+
+func (f *FileStore) load() (map[string]localSecret, error) {
+	secrets := map[string]localSecret{}
+
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+// save replaces the file atomically, so a crash never leaves a truncated store behind
+func (f *FileStore) save(secrets map[string]localSecret) error {
+	data, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,109 @@
+package secretsmanager
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func testSecretStore(t *testing.T, s SecretStore) {
+	if _, err := s.GetSecret("/secret/fpe/dek"); err != ErrSecretNotFound {
+		t.Fatalf("Expected ErrSecretNotFound, got %v", err)
+	}
+
+	if err := s.CreateSecret("/secret/fpe/dek", "00ff", "test"); err != nil {
+		t.Fatalf("CreateSecret: %v", err)
+	}
+
+	if err := s.CreateSecret("/secret/fpe/dek", "ff00", "test"); err != ErrSecretExists {
+		t.Fatalf("Expected ErrSecretExists, got %v", err)
+	}
+
+	value, err := s.GetSecret("/secret/fpe/dek")
+	if err != nil || value != "00ff" {
+		t.Fatalf("GetSecret: %q, %v", value, err)
+	}
+}
+
+func testVersionedStore(t *testing.T, s VersionedStore) {
+	const name = "/secret/fpe/dek"
+
+	if err := s.PutSecretVersion(name, "v2", "next", []string{StagePending}); err != nil {
+		t.Fatalf("PutSecretVersion: %v", err)
+	}
+
+	if err := s.PutSecretVersion(name, "v2", "next", []string{StagePending}); err != nil {
+		t.Fatalf("Putting the same version again: %v", err)
+	}
+
+	if err := s.PutSecretVersion(name, "v2", "other", []string{StagePending}); err != ErrVersionExists {
+		t.Fatalf("Expected ErrVersionExists, got %v", err)
+	}
+
+	if value, err := s.GetSecretVersion(name, "", StagePending); err != nil || value != "next" {
+		t.Fatalf("GetSecretVersion(pending): %q, %v", value, err)
+	}
+
+	if value, _ := s.GetSecret(name); value != "00ff" {
+		t.Fatalf("A pending version must not become current, got %q", value)
+	}
+
+	stages, _ := s.VersionStages(name)
+	var initial string
+	for id, labels := range stages {
+		if id != "v2" && len(labels) == 1 && labels[0] == StageCurrent {
+			initial = id
+		}
+	}
+	if initial == "" {
+		t.Fatalf("No current version in %v", stages)
+	}
+
+	if err := s.UpdateVersionStage(name, StageCurrent, "v2", initial); err != nil {
+		t.Fatalf("UpdateVersionStage: %v", err)
+	}
+
+	if value, _ := s.GetSecret(name); value != "next" {
+		t.Fatalf("Expected the promoted version to be current, got %q", value)
+	}
+
+	if value, err := s.GetSecretVersion(name, "", StagePrevious); err != nil || value != "00ff" {
+		t.Fatalf("GetSecretVersion(previous): %q, %v", value, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	testSecretStore(t, s)
+	testVersionedStore(t, s)
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	testSecretStore(t, s)
+
+	// A second store on the same file sees the secret
+	reopened, _ := NewFileStore(path)
+	if value, err := reopened.GetSecret("/secret/fpe/dek"); err != nil || value != "00ff" {
+		t.Fatalf("GetSecret after reopening: %q, %v", value, err)
+	}
+	testVersionedStore(t, reopened)
+}
+
+func TestNameOf(t *testing.T) {
+	tests := map[string]string{
+		"/secret/fpe/dek": "/secret/fpe/dek",
+		"arn:aws:secretsmanager:ap-northeast-2:123456789012:secret:/secret/fpe/dek-AbCdEf":                  "/secret/fpe/dek",
+		"arn:aws:secretsmanager:ap-northeast-2:123456789012:secret:/secret/fpe/dek/tenants/payments-a1B2c3": "/secret/fpe/dek/tenants/payments",
+	}
+
+	for id, want := range tests {
+		if name := NameOf(id); name != want {
+			t.Fatalf("NameOf(%s): expected %s, got %s", id, want, name)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package secretsmanager
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+var (
+	// ErrSecretNotFound is returned if no secret with the name exists
+	ErrSecretNotFound = errors.New("secret not found")
+
+	// ErrSecretExists is returned if a secret to be created already exists
+	ErrSecretExists = errors.New("secret already exists")
+
+	// ErrVersionExists is returned if a version to be put already exists with another value
+	ErrVersionExists = errors.New("secret version already exists with another value")
+)
+
+// Staging labels of the Secrets Manager rotation protocol
+const (
+	StageCurrent  = "AWSCURRENT"
+	StagePending  = "AWSPENDING"
+	StagePrevious = "AWSPREVIOUS"
+)
+
+// [2022-05-05] NameOf returns the name of the secret secretId identifies: secretId itself, or
+// the name in an ARN, without the six random characters Secrets Manager appends to it.
+func NameOf(secretId string) string {
+	if !strings.HasPrefix(secretId, "arn:") {
+		return secretId
+	}
+
+	i := strings.Index(secretId, ":secret:")
+	if i < 0 {
+		return secretId
+	}
+	name := secretId[i+len(":secret:"):]
+
+	if n := len(name) - 7; n > 0 && name[n] == '-' {
+		name = name[:n]
+	}
+	return name
+}
+
+// SecretStore keeps named string secrets. SecretsManagerClientImpl implements it with
+// AWS Secrets Manager, MemoryStore and FileStore without any AWS dependency.
+type SecretStore interface {
+	// GetSecret returns the current value of a secret, or ErrSecretNotFound
+	GetSecret(name string) (string, error)
+
+	// CreateSecret creates a secret, or returns ErrSecretExists
+	CreateSecret(name string, value string, description string) error
+}
+
+// VersionedStore is a SecretStore with the versions and staging labels secret rotation needs.
+// GetSecret returns the version labeled StageCurrent. Moving StageCurrent to another version
+// labels the version that had it StagePrevious, as Secrets Manager does.
+type VersionedStore interface {
+	SecretStore
+
+	// GetSecretVersion returns the value of a version by ID, by staging label, or by both
+	GetSecretVersion(name string, versionId string, stage string) (string, error)
+
+	// PutSecretVersion adds a version with the given labels, StageCurrent if none. Putting an
+	// existing version again with the same value succeeds, with another value returns ErrVersionExists.
+	PutSecretVersion(name string, versionId string, value string, stages []string) error
+
+	// UpdateVersionStage moves a label to moveToVersionId from removeFromVersionId; either may be empty
+	UpdateVersionStage(name string, stage string, moveToVersionId string, removeFromVersionId string) error
+
+	// VersionStages returns the labels of every version by version ID
+	VersionStages(name string) (map[string][]string, error)
+}
+
+// GetSecret implements SecretStore with GetSecretValue.
+func (s *SecretsManagerClientImpl) GetSecret(name string) (string, error) {
+	var response *secretsmanager.GetSecretValueOutput
+
+	err := s.CallWithRetry(func(impl *secretsmanager.SecretsManager) error {
+		var ferr error
+		response, ferr = impl.GetSecretValue(
+			&secretsmanager.GetSecretValueInput{
+				SecretId: aws.String(name),
+			},
+		)
+		return ferr
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return "", ErrSecretNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(response.SecretString), nil
+}
+
+// CreateSecret implements SecretStore with CreateSecret.
+func (s *SecretsManagerClientImpl) CreateSecret(name string, value string, description string) error {
+	err := s.CallWithRetry(func(impl *secretsmanager.SecretsManager) error {
+		_, ferr := impl.CreateSecret(
+			&secretsmanager.CreateSecretInput{
+				Name:         aws.String(name),
+				SecretString: aws.String(value),
+				Description:  aws.String(description),
+			},
+		)
+		return ferr
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceExistsException {
+		return ErrSecretExists
+	}
+
+	return err
+}
+
+// GetSecretVersion implements VersionedStore with GetSecretValue.
+func (s *SecretsManagerClientImpl) GetSecretVersion(name string, versionId string, stage string) (string, error) {
+	var response *secretsmanager.GetSecretValueOutput
+
+	input := &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	}
+	if versionId != "" {
+		input.VersionId = aws.String(versionId)
+	}
+	if stage != "" {
+		input.VersionStage = aws.String(stage)
+	}
+
+	err := s.CallWithRetry(func(impl *secretsmanager.SecretsManager) error {
+		var ferr error
+		response, ferr = impl.GetSecretValue(input)
+		return ferr
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return "", ErrSecretNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(response.SecretString), nil
+}
+
+// PutSecretVersion implements VersionedStore with PutSecretValue.
+func (s *SecretsManagerClientImpl) PutSecretVersion(name string, versionId string, value string, stages []string) error {
+	input := &secretsmanager.PutSecretValueInput{
+		SecretId:           aws.String(name),
+		ClientRequestToken: aws.String(versionId),
+		SecretString:       aws.String(value),
+	}
+	if len(stages) > 0 {
+		input.VersionStages = aws.StringSlice(stages)
+	}
+
+	err := s.CallWithRetry(func(impl *secretsmanager.SecretsManager) error {
+		_, ferr := impl.PutSecretValue(input)
+		return ferr
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceExistsException {
+		return ErrVersionExists
+	}
+
+	return err
+}
+
+// UpdateVersionStage implements VersionedStore with UpdateSecretVersionStage.
+func (s *SecretsManagerClientImpl) UpdateVersionStage(name string, stage string, moveToVersionId string, removeFromVersionId string) error {
+	input := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:     aws.String(name),
+		VersionStage: aws.String(stage),
+	}
+	if moveToVersionId != "" {
+		input.MoveToVersionId = aws.String(moveToVersionId)
+	}
+	if removeFromVersionId != "" {
+		input.RemoveFromVersionId = aws.String(removeFromVersionId)
+	}
+
+	return s.CallWithRetry(func(impl *secretsmanager.SecretsManager) error {
+		_, ferr := impl.UpdateSecretVersionStage(input)
+		return ferr
+	})
+}
+
+// VersionStages implements VersionedStore with DescribeSecret.
+func (s *SecretsManagerClientImpl) VersionStages(name string) (map[string][]string, error) {
+	var response *secretsmanager.DescribeSecretOutput
+
+	err := s.CallWithRetry(func(impl *secretsmanager.SecretsManager) error {
+		var ferr error
+		response, ferr = impl.DescribeSecret(
+			&secretsmanager.DescribeSecretInput{
+				SecretId: aws.String(name),
+			},
+		)
+		return ferr
+	})
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return nil, ErrSecretNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stages := map[string][]string{}
+	for id, labels := range response.VersionIdsToStages {
+		stages[id] = aws.StringValueSlice(labels)
+	}
+
+	return stages, nil
+}
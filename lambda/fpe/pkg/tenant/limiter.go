@@ -0,0 +1,55 @@
+package tenant
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket admitting a number of requests per minute, with bursts of up to that many.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter admitting perMinute requests per minute; nil, admitting all, if perMinute is zero.
+func NewLimiter(perMinute int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+
+	return &Limiter{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(perMinute),
+		tokens: float64(perMinute),
+	}
+}
+
+// Allow reports whether a request arriving at now is admitted, and takes a token if so.
+func (l *Limiter) Allow(now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() && now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	if now.After(l.last) {
+		l.last = now
+	}
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+
+	return true
+}
@@ -0,0 +1,172 @@
+// Package tenant defines the tenants sharing a deployment, with their quotas and audit streams.
+//
+// The registry is a document, in JSON or YAML, listing every tenant by ID. Requests of tenants
+// not listed are refused, so a forged or mistyped claim never gets keys generated for it.
+//
+//	tenants:
+//	  payments:
+//	    description: Payments team
+//	    quota: {requestsPerMinute: 600, maxBodyBytes: 65536}
+//	  marketing:
+//	    auditStream: marketing-audit
+//	    masterKeyId: arn:aws:kms:ap-northeast-2:111122223333:key/...
+//
+// Each tenant's keyset and policy are stored under secret names derived from the tenant ID,
+// see SecretName, so no registry entry can point a tenant at another tenant's keys.
+package tenant
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrUnknownTenant is returned for tenants not listed in the registry
+	ErrUnknownTenant = errors.New("unknown tenant")
+
+	idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// Quota limits the requests of a tenant; zero means unlimited.
+type Quota struct {
+	// Requests per minute served by one instance of the function
+	RequestsPerMinute int `json:"requestsPerMinute,omitempty"`
+
+	// Size of a request body
+	MaxBodyBytes int `json:"maxBodyBytes,omitempty"`
+}
+
+// Tenant is the configuration of one tenant.
+type Tenant struct {
+	Description string `json:"description,omitempty"`
+	Quota       Quota  `json:"quota,omitempty"`
+
+	// Stream the audit records of the tenant are written to; the tenant ID if empty
+	AuditStream string `json:"auditStream,omitempty"`
+
+	// Master key wrapping the tenant's data encryption key; the service's own if empty
+	MasterKeyId string `json:"masterKeyId,omitempty"`
+}
+
+// Registry lists the tenants by ID.
+type Registry struct {
+	Tenants map[string]Tenant `json:"tenants"`
+}
+
+// Parse reads a registry in JSON or YAML and validates it.
+func Parse(data []byte) (*Registry, error) {
+	trimmed := bytes.TrimSpace(data)
+
+	// YAML is converted to JSON first, so both formats share the JSON field names
+	if len(trimmed) > 0 && trimmed[0] != '{' {
+		var doc interface{}
+		if err := yaml.Unmarshal(trimmed, &doc); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if trimmed, err = json.Marshal(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	var r Registry
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&r); err != nil {
+		return nil, err
+	}
+
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// LoadFile reads a registry from a file.
+func LoadFile(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+// Validate checks the IDs and quotas of all tenants.
+func (r *Registry) Validate() error {
+	if len(r.Tenants) == 0 {
+		return errors.New("registry defines no tenants")
+	}
+
+	for _, id := range r.IDs() {
+		if !ValidID(id) {
+			return fmt.Errorf("invalid tenant ID %q", id)
+		}
+
+		t := r.Tenants[id]
+		if t.Quota.RequestsPerMinute < 0 || t.Quota.MaxBodyBytes < 0 {
+			return fmt.Errorf("tenant %s: quotas must not be negative", id)
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns the tenant with the ID id.
+func (r *Registry) Lookup(id string) (Tenant, error) {
+	t, ok := r.Tenants[id]
+	if !ok {
+		return Tenant{}, ErrUnknownTenant
+	}
+	return t, nil
+}
+
+// IDs returns the IDs of all tenants in sorted order.
+func (r *Registry) IDs() []string {
+	ids := make([]string, 0, len(r.Tenants))
+	for id := range r.Tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Stream returns the audit stream of the tenant with the ID id.
+func (t Tenant) Stream(id string) string {
+	if t.AuditStream != "" {
+		return t.AuditStream
+	}
+	return id
+}
+
+// ValidID reports whether id can name a tenant: letters, digits, "_" and "-", at most 64 of them.
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// SecretName returns the name of the tenant's secret of the kind named by base,
+// e.g. "/secret/fpe/dek/tenants/payments" for base "/secret/fpe/dek".
+func SecretName(base string, id string) string {
+	return base + "/tenants/" + id
+}
+
+// [2022-05-05] FromSecretName returns the tenant whose secret of the kind named by base is name,
+// the inverse of SecretName, or the empty string if name is not a tenant's secret.
+func FromSecretName(base string, name string) string {
+	id := strings.TrimPrefix(name, base+"/tenants/")
+	if id == name || !ValidID(id) {
+		return ""
+	}
+	return id
+}
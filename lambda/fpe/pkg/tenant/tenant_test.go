@@ -0,0 +1,87 @@
+package tenant
+
+import (
+	"testing"
+	"time"
+)
+
+const testRegistry = `
+tenants:
+  payments:
+    quota: {requestsPerMinute: 2, maxBodyBytes: 1024}
+  marketing:
+    auditStream: marketing-audit
+`
+
+func TestParse(t *testing.T) {
+	r, err := Parse([]byte(testRegistry))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if ids := r.IDs(); len(ids) != 2 || ids[0] != "marketing" || ids[1] != "payments" {
+		t.Fatalf("Unexpected tenants %v", ids)
+	}
+
+	payments, err := r.Lookup("payments")
+	if err != nil || payments.Quota.RequestsPerMinute != 2 || payments.Stream("payments") != "payments" {
+		t.Fatalf("Unexpected tenant %+v: %v", payments, err)
+	}
+
+	if marketing, _ := r.Lookup("marketing"); marketing.Stream("marketing") != "marketing-audit" {
+		t.Fatalf("Unexpected audit stream %q", marketing.Stream("marketing"))
+	}
+
+	if _, err := r.Lookup("other"); err != ErrUnknownTenant {
+		t.Fatalf("Expected ErrUnknownTenant, got %v", err)
+	}
+
+	for _, doc := range []string{
+		`{"tenants": {}}`,
+		`{"tenants": {"a/b": {}}}`,
+		`{"tenants": {"a": {"quota": {"requestsPerMinute": -1}}}}`,
+		`{"tenants": {"a": {"secretName": "/secret/fpe/dek"}}}`,
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("Expected an error for %s", doc)
+		}
+	}
+
+	if name := SecretName("/secret/fpe/dek", "payments"); name != "/secret/fpe/dek/tenants/payments" {
+		t.Fatalf("Unexpected secret name %q", name)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(2)
+	now := time.Now()
+
+	if !l.Allow(now) || !l.Allow(now) {
+		t.Fatalf("Expected a burst of 2 requests")
+	}
+	if l.Allow(now) {
+		t.Fatalf("Expected the third request to be refused")
+	}
+	if !l.Allow(now.Add(30 * time.Second)) {
+		t.Fatalf("Expected a token after 30 seconds")
+	}
+
+	unlimited := NewLimiter(0)
+	if !unlimited.Allow(now) {
+		t.Fatalf("A zero quota must not limit")
+	}
+}
+
+func TestFromSecretName(t *testing.T) {
+	const base = "/secret/fpe/dek"
+
+	if id := FromSecretName(base, SecretName(base, "payments")); id != "payments" {
+		t.Fatalf("Expected payments, got %q", id)
+	}
+
+	for _, name := range []string{base, "/secret/fpe/policy/tenants/payments", base + "/tenants/a/b"} {
+		if id := FromSecretName(base, name); id != "" {
+			t.Fatalf("Expected no tenant for %s, got %q", name, id)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package tokenization
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltTokensBucket       = []byte("tokens")
+	boltFingerprintsBucket = []byte("fingerprints")
+)
+
+// BoltStore keeps records in a local BoltDB file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (or creates) the BoltDB file at path.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltTokensBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltFingerprintsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) Put(ctx context.Context, r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		tokens := tx.Bucket(boltTokensBucket)
+		if tokens.Get([]byte(r.Token)) != nil {
+			return ErrTokenExists
+		}
+
+		if r.Fingerprint != "" {
+			fingerprints := tx.Bucket(boltFingerprintsBucket)
+			if fingerprints.Get([]byte(r.Fingerprint)) != nil {
+				return ErrFingerprintExists
+			}
+			if err := fingerprints.Put([]byte(r.Fingerprint), []byte(r.Token)); err != nil {
+				return err
+			}
+		}
+
+		return tokens.Put([]byte(r.Token), data)
+	})
+}
+
+func (s *BoltStore) GetByToken(ctx context.Context, token string) (Record, error) {
+	var r Record
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getBoltRecord(tx, []byte(token), &r)
+	})
+
+	return r, err
+}
+
+func (s *BoltStore) GetByFingerprint(ctx context.Context, fingerprint string) (Record, error) {
+	var r Record
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		token := tx.Bucket(boltFingerprintsBucket).Get([]byte(fingerprint))
+		if token == nil {
+			return ErrNotFound
+		}
+		return getBoltRecord(tx, token, &r)
+	})
+
+	return r, err
+}
+
+func getBoltRecord(tx *bolt.Tx, token []byte, r *Record) error {
+	data := tx.Bucket(boltTokensBucket).Get(token)
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, r)
+}
@@ -0,0 +1,143 @@
+package tokenization
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Single-table layout: token items are keyed "token#<token>", fingerprint index items "fp#<fingerprint>".
+const (
+	dynamoKey               = "pk"
+	dynamoTokenPrefix       = "token#"
+	dynamoFingerprintPrefix = "fp#"
+)
+
+// DynamoDBStore keeps records in a DynamoDB table with a string partition key named "pk".
+// The client is an interface so the store can be pointed at DynamoDB Local or a test double.
+type DynamoDBStore struct {
+	client dynamodbiface.DynamoDBAPI
+	table  string
+}
+
+func NewDynamoDBStore(client dynamodbiface.DynamoDBAPI, table string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, table: table}
+}
+
+func (s *DynamoDBStore) Put(ctx context.Context, r Record) error {
+	tokenItem := map[string]*dynamodb.AttributeValue{
+		dynamoKey:   {S: aws.String(dynamoTokenPrefix + r.Token)},
+		"token":     {S: aws.String(r.Token)},
+		"value":     {B: r.Value},
+		"createdAt": {S: aws.String(r.CreatedAt.UTC().Format(time.RFC3339Nano))},
+	}
+	if r.Fingerprint != "" {
+		tokenItem["fingerprint"] = &dynamodb.AttributeValue{S: aws.String(r.Fingerprint)}
+	}
+
+	condition := aws.String("attribute_not_exists(" + dynamoKey + ")")
+
+	if r.Fingerprint == "" {
+		_, err := s.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(s.table),
+			Item:                tokenItem,
+			ConditionExpression: condition,
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return ErrTokenExists
+		}
+		return err
+	}
+
+	// Token and fingerprint index must be written together
+	_, err := s.client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName:           aws.String(s.table),
+					Item:                tokenItem,
+					ConditionExpression: condition,
+				},
+			},
+			{
+				Put: &dynamodb.Put{
+					TableName: aws.String(s.table),
+					Item: map[string]*dynamodb.AttributeValue{
+						dynamoKey: {S: aws.String(dynamoFingerprintPrefix + r.Fingerprint)},
+						"token":   {S: aws.String(r.Token)},
+					},
+					ConditionExpression: condition,
+				},
+			},
+		},
+	})
+
+	if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		reasons := canceled.CancellationReasons
+		if len(reasons) > 0 && aws.StringValue(reasons[0].Code) == "ConditionalCheckFailed" {
+			return ErrTokenExists
+		}
+		if len(reasons) > 1 && aws.StringValue(reasons[1].Code) == "ConditionalCheckFailed" {
+			return ErrFingerprintExists
+		}
+	}
+
+	return err
+}
+
+func (s *DynamoDBStore) GetByToken(ctx context.Context, token string) (Record, error) {
+	item, err := s.get(ctx, dynamoTokenPrefix+token)
+	if err != nil {
+		return Record{}, err
+	}
+
+	r := Record{
+		Token:       aws.StringValue(item["token"].S),
+		Fingerprint: attributeString(item["fingerprint"]),
+	}
+	if v := item["value"]; v != nil {
+		r.Value = v.B
+	}
+	if created := attributeString(item["createdAt"]); created != "" {
+		r.CreatedAt, _ = time.Parse(time.RFC3339Nano, created)
+	}
+
+	return r, nil
+}
+
+func (s *DynamoDBStore) GetByFingerprint(ctx context.Context, fingerprint string) (Record, error) {
+	item, err := s.get(ctx, dynamoFingerprintPrefix+fingerprint)
+	if err != nil {
+		return Record{}, err
+	}
+
+	return s.GetByToken(ctx, attributeString(item["token"]))
+}
+
+func (s *DynamoDBStore) get(ctx context.Context, key string) (map[string]*dynamodb.AttributeValue, error) {
+	out, err := s.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            map[string]*dynamodb.AttributeValue{dynamoKey: {S: aws.String(key)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out.Item) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return out.Item, nil
+}
+
+func attributeString(v *dynamodb.AttributeValue) string {
+	if v == nil {
+		return ""
+	}
+	return aws.StringValue(v.S)
+}
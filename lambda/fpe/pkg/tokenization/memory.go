@@ -0,0 +1,64 @@
+package tokenization
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore keeps records in process memory; they are lost when the process exits.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	tokens       map[string]Record
+	fingerprints map[string]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens:       map[string]Record{},
+		fingerprints: map[string]string{},
+	}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[r.Token]; ok {
+		return ErrTokenExists
+	}
+
+	if r.Fingerprint != "" {
+		if _, ok := s.fingerprints[r.Fingerprint]; ok {
+			return ErrFingerprintExists
+		}
+		s.fingerprints[r.Fingerprint] = r.Token
+	}
+
+	s.tokens[r.Token] = r
+
+	return nil
+}
+
+func (s *MemoryStore) GetByToken(ctx context.Context, token string) (Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.tokens[token]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+
+	return r, nil
+}
+
+func (s *MemoryStore) GetByFingerprint(ctx context.Context, fingerprint string) (Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.fingerprints[fingerprint]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+
+	return s.tokens[token], nil
+}
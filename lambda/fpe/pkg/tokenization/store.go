@@ -0,0 +1,84 @@
+package tokenization
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned if no record exists for a token or fingerprint
+	ErrNotFound = errors.New("token not found")
+
+	// ErrTokenExists is returned by Put if the token is already taken
+	ErrTokenExists = errors.New("token already exists")
+
+	// ErrFingerprintExists is returned by Put if the value is already indexed under another token
+	ErrFingerprintExists = errors.New("fingerprint already exists")
+)
+
+// A Record maps a token to its protected value
+type Record struct {
+	Token string `json:"token"`
+
+	// Keyed digest of the value, only set for deterministic tokens
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// Value sealed under the vault key, never the plain value
+	Value []byte `json:"value"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// A TokenStore persists token records.
+// Put must be atomic: it either stores the token and (if set) the fingerprint index, or neither.
+type TokenStore interface {
+	Put(ctx context.Context, r Record) error
+	GetByToken(ctx context.Context, token string) (Record, error)
+	GetByFingerprint(ctx context.Context, fingerprint string) (Record, error)
+}
+
+// [2022-05-09] Namespaced returns the view of store holding the records of namespace only, e.g.
+// those of a tenant. Tokens and fingerprints are stored prefixed with "<namespace>/", so a token
+// of one namespace is not found in another; namespace must not contain "/". The empty namespace
+// is store itself, so records stored before namespaces keep resolving there.
+func Namespaced(store TokenStore, namespace string) TokenStore {
+	if namespace == "" {
+		return store
+	}
+	return &namespacedStore{store: store, prefix: namespace + "/"}
+}
+
+type namespacedStore struct {
+	store  TokenStore
+	prefix string
+}
+
+func (s *namespacedStore) Put(ctx context.Context, r Record) error {
+	r.Token = s.prefix + r.Token
+	if r.Fingerprint != "" {
+		r.Fingerprint = s.prefix + r.Fingerprint
+	}
+	return s.store.Put(ctx, r)
+}
+
+func (s *namespacedStore) GetByToken(ctx context.Context, token string) (Record, error) {
+	return s.strip(s.store.GetByToken(ctx, s.prefix+token))
+}
+
+func (s *namespacedStore) GetByFingerprint(ctx context.Context, fingerprint string) (Record, error) {
+	return s.strip(s.store.GetByFingerprint(ctx, s.prefix+fingerprint))
+}
+
+// strip removes the namespace from a record read from the underlying store.
+func (s *namespacedStore) strip(r Record, err error) (Record, error) {
+	if err != nil {
+		return Record{}, err
+	}
+
+	r.Token = strings.TrimPrefix(r.Token, s.prefix)
+	r.Fingerprint = strings.TrimPrefix(r.Fingerprint, s.prefix)
+
+	return r, nil
+}
@@ -0,0 +1,251 @@
+package tokenization
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// fakeDynamoDB is a local stand-in for the subset of DynamoDB the store uses
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{}}
+}
+
+func (f *fakeDynamoDB) PutItemWithContext(ctx aws.Context, in *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := aws.StringValue(in.Item[dynamoKey].S)
+	if _, ok := f.items[key]; ok && in.ConditionExpression != nil {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional check failed", nil)
+	}
+	f.items[key] = in.Item
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) TransactWriteItemsWithContext(ctx aws.Context, in *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reasons := make([]*dynamodb.CancellationReason, len(in.TransactItems))
+	canceled := false
+	for i, item := range in.TransactItems {
+		reasons[i] = &dynamodb.CancellationReason{Code: aws.String("None")}
+		if _, ok := f.items[aws.StringValue(item.Put.Item[dynamoKey].S)]; ok {
+			reasons[i].Code = aws.String("ConditionalCheckFailed")
+			canceled = true
+		}
+	}
+
+	if canceled {
+		return nil, &dynamodb.TransactionCanceledException{CancellationReasons: reasons}
+	}
+
+	for _, item := range in.TransactItems {
+		f.items[aws.StringValue(item.Put.Item[dynamoKey].S)] = item.Put.Item
+	}
+
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (f *fakeDynamoDB) GetItemWithContext(ctx aws.Context, in *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &dynamodb.GetItemOutput{Item: f.items[aws.StringValue(in.Key[dynamoKey].S)]}, nil
+}
+
+func testStores(t *testing.T) map[string]TokenStore {
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "tokens.db"))
+	if err != nil {
+		t.Fatalf("Unable to open bolt store: %v", err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+
+	return map[string]TokenStore{
+		"memory":   NewMemoryStore(),
+		"bolt":     bolt,
+		"dynamodb": NewDynamoDBStore(newFakeDynamoDB(), "tokens"),
+	}
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			r := Record{Token: "1234", Fingerprint: "fp1", Value: []byte("sealed")}
+			if err := store.Put(ctx, r); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			if err := store.Put(ctx, Record{Token: "1234", Value: []byte("x")}); err != ErrTokenExists {
+				t.Fatalf("Expected ErrTokenExists, got %v", err)
+			}
+
+			if err := store.Put(ctx, Record{Token: "5678", Fingerprint: "fp1", Value: []byte("x")}); err != ErrFingerprintExists {
+				t.Fatalf("Expected ErrFingerprintExists, got %v", err)
+			}
+
+			// The failed put must not have left the token behind
+			if _, err := store.GetByToken(ctx, "5678"); err != ErrNotFound {
+				t.Fatalf("Expected ErrNotFound, got %v", err)
+			}
+
+			got, err := store.GetByFingerprint(ctx, "fp1")
+			if err != nil {
+				t.Fatalf("GetByFingerprint: %v", err)
+			}
+
+			if got.Token != "1234" || string(got.Value) != "sealed" {
+				t.Fatalf("Unexpected record %+v", got)
+			}
+		})
+	}
+}
+
+func TestVault(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			v, err := NewVault(store, testKey, Options{})
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			value := "4111-1111-1111-1111"
+			token, err := v.Tokenize(ctx, value, false)
+			if err != nil {
+				t.Fatalf("Tokenize: %v", err)
+			}
+
+			if !regexp.MustCompile(`^\d{4}-\d{4}-\d{4}-\d{4}$`).MatchString(token) || token == value {
+				t.Fatalf("Unexpected token %q", token)
+			}
+
+			again, err := v.Tokenize(ctx, value, false)
+			if err != nil {
+				t.Fatalf("Tokenize: %v", err)
+			}
+
+			if again == token {
+				t.Fatalf("Random tokens repeated: %q", token)
+			}
+
+			detokenized, err := v.Detokenize(ctx, token)
+			if err != nil {
+				t.Fatalf("Detokenize: %v", err)
+			}
+
+			if detokenized != value {
+				t.Fatalf("Detokenize failed.\nExpected: %v\nGot: %v", value, detokenized)
+			}
+
+			if _, err := v.Detokenize(ctx, "0000-0000-0000-0000"); err != ErrNotFound {
+				t.Fatalf("Expected ErrNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVaultDeterministic(t *testing.T) {
+	ctx := context.Background()
+	v, _ := NewVault(NewMemoryStore(), testKey, Options{})
+
+	a, err := v.Tokenize(ctx, "alice@example.com", true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	b, err := v.Tokenize(ctx, "alice@example.com", true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if a != b {
+		t.Fatalf("Deterministic tokens differ: %q != %q", a, b)
+	}
+}
+
+// A two-digit value has only 99 candidate tokens; collisions must be retried and finally reported
+func TestVaultCollisions(t *testing.T) {
+	ctx := context.Background()
+	v, _ := NewVault(NewMemoryStore(), testKey, Options{MaxAttempts: 1000})
+
+	seen := map[string]bool{}
+	for i := 0; i < 99; i++ {
+		token, err := v.Tokenize(ctx, "00", false)
+		if err != nil {
+			t.Fatalf("Tokenize #%d: %v", i, err)
+		}
+		if seen[token] {
+			t.Fatalf("Token %q issued twice", token)
+		}
+		seen[token] = true
+	}
+
+	if _, err := v.Tokenize(ctx, "00", false); err != ErrTokenSpaceExhausted {
+		t.Fatalf("Expected ErrTokenSpaceExhausted, got %v", err)
+	}
+}
+
+func TestSealedAtRest(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	v, _ := NewVault(store, testKey, Options{})
+
+	token, _ := v.Tokenize(ctx, "4111111111111111", true)
+	r, _ := store.GetByToken(ctx, token)
+
+	if regexp.MustCompile("4111111111111111").Match(r.Value) {
+		t.Fatalf("Store holds the plain value")
+	}
+}
+
+func TestNamespacedStores(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			acme, _ := NewVault(Namespaced(store, "acme"), testKey, Options{})
+			other, _ := NewVault(Namespaced(store, "other"), []byte("fedcba9876543210fedcba9876543210"), Options{})
+
+			token, err := acme.Tokenize(ctx, "4111-1111-1111-1111", true)
+			if err != nil {
+				t.Fatalf("Tokenize: %v", err)
+			}
+
+			// A token of another namespace is as unknown as one never issued
+			if _, err := other.Detokenize(ctx, token); err != ErrNotFound {
+				t.Fatalf("Expected ErrNotFound, got %v", err)
+			}
+
+			if value, err := acme.Detokenize(ctx, token); err != nil || value != "4111-1111-1111-1111" {
+				t.Fatalf("Detokenize: %q %v", value, err)
+			}
+
+			if again, _ := acme.Tokenize(ctx, "4111-1111-1111-1111", true); again != token {
+				t.Fatalf("Deterministic token changed: %q != %q", again, token)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+// Package tokenization implements vault-style tokenization: tokens are random,
+// format-preserving strings with no mathematical relation to the value they stand for,
+// and the mapping lives in a pluggable TokenStore.
+//
+// Values are sealed (NaCl secretbox) before they reach the store, and deterministic
+// lookups go through a keyed fingerprint, so the store never holds plain values.
+package tokenization
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// DefaultMaxAttempts bounds the number of random tokens tried on collisions
+const DefaultMaxAttempts = 10
+
+var (
+	// ErrNothingToTokenize is returned if the value has no letters or digits to randomize
+	ErrNothingToTokenize = errors.New("value has no letters or digits to tokenize")
+
+	// ErrTokenSpaceExhausted is returned if every attempt collided with an existing token
+	ErrTokenSpaceExhausted = errors.New("could not find an unused token")
+
+	// ErrCorruptRecord is returned if a stored value cannot be unsealed
+	ErrCorruptRecord = errors.New("stored value cannot be unsealed")
+)
+
+// Options configure a Vault
+type Options struct {
+	// Number of tokens generated before giving up on collisions; DefaultMaxAttempts if not positive
+	MaxAttempts int
+}
+
+// A Vault issues and resolves tokens backed by a TokenStore
+type Vault struct {
+	store    TokenStore
+	sealKey  [32]byte
+	indexKey []byte
+	options  Options
+}
+
+// NewVault returns a vault over store. Sealing and fingerprint keys are derived from key.
+func NewVault(store TokenStore, key []byte, options Options) (*Vault, error) {
+	if len(key) < 16 {
+		return nil, errors.New("key must be at least 128 bits")
+	}
+
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultMaxAttempts
+	}
+
+	v := &Vault{
+		store:    store,
+		indexKey: subkey(key, "index"),
+		options:  options,
+	}
+	copy(v.sealKey[:], subkey(key, "seal"))
+
+	return v, nil
+}
+
+// Tokenize returns a random token with the same length and character classes as value.
+// If deterministic is set, a value that was tokenized deterministically before gets its existing token back.
+func (v *Vault) Tokenize(ctx context.Context, value string, deterministic bool) (string, error) {
+	var fingerprint string
+	if deterministic {
+		fingerprint = v.fingerprint(value)
+
+		r, err := v.store.GetByFingerprint(ctx, fingerprint)
+		if err == nil {
+			return r.Token, nil
+		}
+		if err != ErrNotFound {
+			return "", err
+		}
+	}
+
+	sealed, err := v.seal(value)
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 0; attempt < v.options.MaxAttempts; attempt++ {
+		token, err := randomToken(value)
+		if err != nil {
+			return "", err
+		}
+
+		// A token equal to its value would leak it
+		if token == value {
+			continue
+		}
+
+		err = v.store.Put(ctx, Record{
+			Token:       token,
+			Fingerprint: fingerprint,
+			Value:       sealed,
+			CreatedAt:   time.Now().UTC(),
+		})
+
+		switch err {
+		case nil:
+			return token, nil
+
+		case ErrTokenExists:
+			// Collision, try another token
+			continue
+
+		case ErrFingerprintExists:
+			// Another caller tokenized the same value concurrently; use the winner's token
+			r, err := v.store.GetByFingerprint(ctx, fingerprint)
+			if err != nil {
+				return "", err
+			}
+			return r.Token, nil
+
+		default:
+			return "", err
+		}
+	}
+
+	return "", ErrTokenSpaceExhausted
+}
+
+// Detokenize returns the value a token stands for.
+func (v *Vault) Detokenize(ctx context.Context, token string) (string, error) {
+	r, err := v.store.GetByToken(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	return v.open(r.Value)
+}
+
+func (v *Vault) fingerprint(value string) string {
+	mac := hmac.New(sha256.New, v.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// seal returns nonce || secretbox(value)
+func (v *Vault) seal(value string) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+
+	return secretbox.Seal(nonce[:], []byte(value), &nonce, &v.sealKey), nil
+}
+
+func (v *Vault) open(sealed []byte) (string, error) {
+	if len(sealed) < 24 {
+		return "", ErrCorruptRecord
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], sealed[:24])
+
+	plain, ok := secretbox.Open(nil, sealed[24:], &nonce, &v.sealKey)
+	if !ok {
+		return "", ErrCorruptRecord
+	}
+
+	return string(plain), nil
+}
+
+// randomToken replaces every digit and ASCII letter with a random one of the same class,
+// keeping separators and all other characters in place.
+func randomToken(value string) (string, error) {
+	out := []byte(value)
+	randomized := false
+
+	for i, c := range out {
+		var base byte
+		var n int64
+		switch {
+		case c >= '0' && c <= '9':
+			base, n = '0', 10
+		case c >= 'A' && c <= 'Z':
+			base, n = 'A', 26
+		case c >= 'a' && c <= 'z':
+			base, n = 'a', 26
+		default:
+			continue
+		}
+
+		r, err := rand.Int(rand.Reader, big.NewInt(n))
+		if err != nil {
+			return "", err
+		}
+		out[i] = base + byte(r.Int64())
+		randomized = true
+	}
+
+	if !randomized {
+		return "", ErrNothingToTokenize
+	}
+
+	return string(out), nil
+}
+
+func subkey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("tokenization/" + label))
+	return mac.Sum(nil)
+}
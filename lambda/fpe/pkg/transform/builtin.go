@@ -0,0 +1,524 @@
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/cyclewalk"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ff1"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/generalization"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/longfpe"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/masking"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/ope"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/pseudonym"
+	"github.com/shkim4u/protecting-data-with-fpe-pseudonymization/pkg/regexfpe"
+)
+
+// Built-in types; the dedicated handlers of the service (Encrypt, FormatEncrypt, Mask, ...) use them too
+const (
+	TypeFpe        = "fpe"
+	TypeFormat     = "format"
+	TypePattern    = "pattern"
+	TypeLong       = "long"
+	TypeOpe        = "ope"
+	TypeMask       = "mask"
+	TypeGeneralize = "generalize"
+	TypeHash       = "hash"
+)
+
+// Purposes of the derived keys the built-in types ask Keys for; the FF1 types ask for the key of their type name
+const (
+	PurposeOpe       = "ope"
+	PurposePseudonym = "pseudonym"
+)
+
+func init() {
+	MustRegister(Info{
+		Type:        TypeFpe,
+		Description: "FF1 over strings of the given radix",
+		Reversible:  true,
+		Parameters:  []Parameter{{Name: "radix", Type: "integer", Required: true, Description: "2 to 62"}},
+	}, fpeTransformer{})
+
+	MustRegister(Info{
+		Type:        TypeFormat,
+		Description: "FF1 restricted to a registered format by cycle-walking, e.g. luhn",
+		Reversible:  true,
+		Parameters:  []Parameter{{Name: "format", Type: "string", Required: true}},
+	}, formatTransformer{})
+
+	MustRegister(Info{
+		Type:        TypePattern,
+		Description: "FPE over the strings matched by a regular expression",
+		Reversible:  true,
+		Parameters:  []Parameter{{Name: "pattern", Type: "string", Required: true}},
+	}, patternTransformer{})
+
+	MustRegister(Info{
+		Type:        TypeLong,
+		Description: "Block-chained FF1 for values longer than a single FF1 input",
+		Reversible:  true,
+		Parameters: []Parameter{
+			{Name: "radix", Type: "integer", Required: true},
+			{Name: "blockSize", Type: "integer", Description: "64 by default"},
+		},
+	}, longTransformer{})
+
+	MustRegister(Info{
+		Type:        TypeOpe,
+		Description: "Order-preserving encryption; leaks order and approximate values",
+		Reversible:  true,
+		Parameters:  []Parameter{{Name: "domain", Type: "string", Description: "integer (default) or date"}},
+	}, opeTransformer{})
+
+	MustRegister(Info{
+		Type:        TypeMask,
+		Description: "Irreversible masking; a null mask yields the empty string",
+		Parameters: []Parameter{
+			{Name: "type", Type: "string", Required: true, Description: "partial, replace, null or constant"},
+			{Name: "keepFirst", Type: "integer"},
+			{Name: "keepLast", Type: "integer"},
+			{Name: "maskChar", Type: "string"},
+			{Name: "constant", Type: "string"},
+		},
+	}, maskTransformer{})
+
+	MustRegister(Info{
+		Type:        TypeGeneralize,
+		Description: "Irreversible generalization of quasi-identifiers",
+		Parameters: []Parameter{
+			{Name: "kind", Type: "string", Required: true, Description: "date, postal, bucket or round"},
+			{Name: "date", Type: "object"},
+			{Name: "postal", Type: "object"},
+			{Name: "bucket", Type: "object"},
+			{Name: "round", Type: "object"},
+		},
+	}, generalizeTransformer{})
+
+	MustRegister(Info{
+		Type:        TypeHash,
+		Description: "Keyed one-way pseudonym",
+		Parameters:  []Parameter{{Name: "encoding", Type: "string", Description: "hex (default), base32 or fpe"}},
+	}, hashTransformer{})
+}
+
+// inRadix reports whether s is a string of digits of radix as FF1 reads them (math/big)
+func inRadix(s string, radix int) error {
+	if _, ok := new(big.Int).SetString(s, radix); !ok || s[0] == '+' || s[0] == '-' {
+		return fmt.Errorf("%q is not a string of digits of radix %d", s, radix)
+	}
+	return nil
+}
+
+func validRadix(radix int) error {
+	if radix < 2 || radix > big.MaxBase {
+		return errors.New("radix must be between 2 and 62, inclusive")
+	}
+	return nil
+}
+
+// applied returns the result of applying a cipher or function to the input; once keys and
+// parameters are settled, its errors can only come from the input.
+func applied(output string, err error) (string, error) {
+	return output, InvalidInput(err)
+}
+
+// FpeParams are the parameters of TypeFpe
+type FpeParams struct {
+	Radix int `json:"radix"`
+}
+
+type fpeTransformer struct{}
+
+func (fpeTransformer) Parse(raw json.RawMessage) (Params, error) {
+	var p FpeParams
+	if err := DecodeParams(raw, &p); err != nil {
+		return nil, err
+	}
+	return p, validRadix(p.Radix)
+}
+
+func (fpeTransformer) Validate(params Params, input string) error {
+	return inRadix(input, params.(FpeParams).Radix)
+}
+
+func (t fpeTransformer) Protect(keys Keys, params Params, input string) (string, error) {
+	FF1, err := t.cipher(keys, TypeFpe, params.(FpeParams).Radix)
+	if err != nil {
+		return "", err
+	}
+	return applied(FF1.Encrypt(input))
+}
+
+func (t fpeTransformer) Reveal(keys Keys, params Params, input string) (string, error) {
+	if err := t.Validate(params, input); err != nil {
+		return "", InvalidInput(err)
+	}
+
+	FF1, err := t.cipher(keys, TypeFpe, params.(FpeParams).Radix)
+	if err != nil {
+		return "", err
+	}
+	return applied(FF1.Decrypt(input))
+}
+
+func (fpeTransformer) cipher(keys Keys, purpose string, radix int) (ff1.Cipher, error) {
+	key, err := keys.Key(purpose)
+	if err != nil {
+		return ff1.Cipher{}, err
+	}
+
+	tweak, err := keys.Tweak()
+	if err != nil {
+		return ff1.Cipher{}, err
+	}
+
+	return ff1.NewCipher(radix, len(tweak), key, tweak)
+}
+
+// FormatParams are the parameters of TypeFormat
+type FormatParams struct {
+	Format string `json:"format"`
+}
+
+type formatTransformer struct{}
+
+func (formatTransformer) Parse(raw json.RawMessage) (Params, error) {
+	var p FormatParams
+	if err := DecodeParams(raw, &p); err != nil {
+		return nil, err
+	}
+	if _, ok := cyclewalk.LookupFormat(p.Format); !ok {
+		return nil, fmt.Errorf("unknown format %q", p.Format)
+	}
+	return p, nil
+}
+
+func (formatTransformer) Validate(params Params, input string) error {
+	f, _ := cyclewalk.LookupFormat(params.(FormatParams).Format)
+	if !f.Predicate(input) {
+		return cyclewalk.ErrInputNotInDomain
+	}
+	return nil
+}
+
+func (t formatTransformer) Protect(keys Keys, params Params, input string) (string, error) {
+	c, err := t.cipher(keys, params.(FormatParams).Format)
+	if err != nil {
+		return "", err
+	}
+	return applied(c.Encrypt(input))
+}
+
+func (t formatTransformer) Reveal(keys Keys, params Params, input string) (string, error) {
+	c, err := t.cipher(keys, params.(FormatParams).Format)
+	if err != nil {
+		return "", err
+	}
+	return applied(c.Decrypt(input))
+}
+
+func (formatTransformer) cipher(keys Keys, format string) (cyclewalk.Cipher, error) {
+	f, _ := cyclewalk.LookupFormat(format)
+
+	FF1, err := fpeTransformer{}.cipher(keys, TypeFormat, f.Radix)
+	if err != nil {
+		return cyclewalk.Cipher{}, err
+	}
+
+	return cyclewalk.NewCipher(FF1, f.Predicate, f.MaxIterations)
+}
+
+// PatternParams are the parameters of TypePattern
+type PatternParams struct {
+	Pattern string `json:"pattern"`
+}
+
+type patternTransformer struct{}
+
+func (patternTransformer) Parse(raw json.RawMessage) (Params, error) {
+	var p PatternParams
+	if err := DecodeParams(raw, &p); err != nil {
+		return nil, err
+	}
+	if p.Pattern == "" {
+		return nil, errors.New("missing pattern")
+	}
+	return p, regexfpe.Validate(p.Pattern)
+}
+
+func (patternTransformer) Validate(params Params, input string) error {
+	re, err := regexp.Compile(`^(?:` + params.(PatternParams).Pattern + `)$`)
+	if err != nil {
+		return err
+	}
+	if !re.MatchString(input) {
+		return regexfpe.ErrNotInLanguage
+	}
+	return nil
+}
+
+func (t patternTransformer) Protect(keys Keys, params Params, input string) (string, error) {
+	c, err := t.cipher(keys, params.(PatternParams).Pattern)
+	if err != nil {
+		return "", err
+	}
+	return applied(c.Encrypt(input))
+}
+
+func (t patternTransformer) Reveal(keys Keys, params Params, input string) (string, error) {
+	c, err := t.cipher(keys, params.(PatternParams).Pattern)
+	if err != nil {
+		return "", err
+	}
+	return applied(c.Decrypt(input))
+}
+
+func (patternTransformer) cipher(keys Keys, pattern string) (*regexfpe.Cipher, error) {
+	key, err := keys.Key(TypePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	tweak, err := keys.Tweak()
+	if err != nil {
+		return nil, err
+	}
+
+	return regexfpe.NewCipher(pattern, key, tweak)
+}
+
+// LongParams are the parameters of TypeLong
+type LongParams struct {
+	Radix     int `json:"radix"`
+	BlockSize int `json:"blockSize,omitempty"`
+}
+
+type longTransformer struct{}
+
+func (longTransformer) Parse(raw json.RawMessage) (Params, error) {
+	var p LongParams
+	if err := DecodeParams(raw, &p); err != nil {
+		return nil, err
+	}
+	return p, validRadix(p.Radix)
+}
+
+func (longTransformer) Validate(params Params, input string) error {
+	return inRadix(input, params.(LongParams).Radix)
+}
+
+func (t longTransformer) Protect(keys Keys, params Params, input string) (string, error) {
+	c, err := t.cipher(keys, params.(LongParams))
+	if err != nil {
+		return "", err
+	}
+	return applied(c.Encrypt(input))
+}
+
+func (t longTransformer) Reveal(keys Keys, params Params, input string) (string, error) {
+	if err := t.Validate(params, input); err != nil {
+		return "", InvalidInput(err)
+	}
+
+	c, err := t.cipher(keys, params.(LongParams))
+	if err != nil {
+		return "", err
+	}
+	return applied(c.Decrypt(input))
+}
+
+func (longTransformer) cipher(keys Keys, p LongParams) (*longfpe.Cipher, error) {
+	key, err := keys.Key(TypeLong)
+	if err != nil {
+		return nil, err
+	}
+
+	tweak, err := keys.Tweak()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := longfpe.NewCipher(p.Radix, p.BlockSize, key, tweak)
+	if err == longfpe.ErrBlockSizeInvalid {
+		return nil, InvalidInput(err)
+	}
+	return c, err
+}
+
+// OpeParams are the parameters of TypeOpe
+type OpeParams struct {
+	Domain string `json:"domain,omitempty"`
+}
+
+type opeTransformer struct{}
+
+func (opeTransformer) Parse(raw json.RawMessage) (Params, error) {
+	p := OpeParams{Domain: "integer"}
+	if err := DecodeParams(raw, &p); err != nil {
+		return nil, err
+	}
+	if _, err := ope.LookupDomain(p.Domain, ope.DefaultDomainBits); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (opeTransformer) Validate(params Params, input string) error {
+	d, _ := ope.LookupDomain(params.(OpeParams).Domain, ope.DefaultDomainBits)
+	_, err := d.Encode(input)
+	return err
+}
+
+func (t opeTransformer) Protect(keys Keys, params Params, input string) (string, error) {
+	c, d, err := t.cipher(keys, params.(OpeParams))
+	if err != nil {
+		return "", err
+	}
+
+	m, err := d.Encode(input)
+	if err != nil {
+		return "", InvalidInput(err)
+	}
+
+	ct, err := c.Encrypt(m)
+	if err != nil {
+		return "", InvalidInput(err)
+	}
+
+	return strconv.FormatUint(ct, 10), nil
+}
+
+func (t opeTransformer) Reveal(keys Keys, params Params, input string) (string, error) {
+	c, d, err := t.cipher(keys, params.(OpeParams))
+	if err != nil {
+		return "", err
+	}
+
+	ct, err := strconv.ParseUint(input, 10, 64)
+	if err != nil {
+		return "", InvalidInput(err)
+	}
+
+	m, err := c.Decrypt(ct)
+	if err != nil {
+		return "", InvalidInput(err)
+	}
+
+	return d.Decode(m), nil
+}
+
+func (opeTransformer) cipher(keys Keys, p OpeParams) (*ope.Cipher, ope.Domain, error) {
+	key, err := keys.Key(PurposeOpe)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := ope.NewCipher(key, ope.DefaultDomainBits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d, err := ope.LookupDomain(p.Domain, c.DomainBits())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, d, nil
+}
+
+type maskTransformer struct{}
+
+func (maskTransformer) Parse(raw json.RawMessage) (Params, error) {
+	var spec masking.Spec
+	if err := DecodeParams(raw, &spec); err != nil {
+		return nil, err
+	}
+	return spec, spec.Validate()
+}
+
+func (maskTransformer) Validate(params Params, input string) error {
+	return nil
+}
+
+func (maskTransformer) Protect(keys Keys, params Params, input string) (string, error) {
+	return applied(params.(masking.Spec).Apply(input))
+}
+
+func (maskTransformer) Reveal(keys Keys, params Params, input string) (string, error) {
+	return "", ErrIrreversible
+}
+
+type generalizeTransformer struct{}
+
+func (generalizeTransformer) Parse(raw json.RawMessage) (Params, error) {
+	var config generalization.Config
+	if err := DecodeParams(raw, &config); err != nil {
+		return nil, err
+	}
+	return config, config.Validate()
+}
+
+func (generalizeTransformer) Validate(params Params, input string) error {
+	_, err := params.(generalization.Config).Apply(input)
+	return err
+}
+
+func (generalizeTransformer) Protect(keys Keys, params Params, input string) (string, error) {
+	return applied(params.(generalization.Config).Apply(input))
+}
+
+func (generalizeTransformer) Reveal(keys Keys, params Params, input string) (string, error) {
+	return "", ErrIrreversible
+}
+
+// HashParams are the parameters of TypeHash
+type HashParams struct {
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type hashTransformer struct{}
+
+func (hashTransformer) Parse(raw json.RawMessage) (Params, error) {
+	p := HashParams{Encoding: string(pseudonym.EncodingHex)}
+	if err := DecodeParams(raw, &p); err != nil {
+		return nil, err
+	}
+
+	switch pseudonym.Encoding(p.Encoding) {
+	case pseudonym.EncodingHex, pseudonym.EncodingBase32, pseudonym.EncodingFormatPreserving:
+	default:
+		return nil, pseudonym.ErrUnknownEncoding
+	}
+
+	return p, nil
+}
+
+func (hashTransformer) Validate(params Params, input string) error {
+	if input == "" {
+		return pseudonym.ErrNothingToPseudonymize
+	}
+	return nil
+}
+
+func (hashTransformer) Protect(keys Keys, params Params, input string) (string, error) {
+	key, err := keys.Key(PurposePseudonym)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := pseudonym.New(key)
+	if err != nil {
+		return "", err
+	}
+
+	return applied(p.Pseudonymize(input, pseudonym.Encoding(params.(HashParams).Encoding)))
+}
+
+func (hashTransformer) Reveal(keys Keys, params Params, input string) (string, error) {
+	return "", ErrIrreversible
+}
@@ -0,0 +1,172 @@
+// Package transform is the registry of data types the service can protect.
+//
+// A data type is implemented by a Transformer and registered under a name, usually from
+// an init function. Handlers look transformers up by the "type" field of a request, so a
+// new type needs neither routing nor response code:
+//
+//	func init() {
+//		transform.MustRegister(transform.Info{Type: "iban", Reversible: true}, ibanTransformer{})
+//	}
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrIrreversible is returned by Reveal of transformers that cannot be reversed
+	ErrIrreversible = errors.New("transform is irreversible")
+
+	// ErrTypeExists is returned if a type is registered twice
+	ErrTypeExists = errors.New("type is already registered")
+
+	// ErrInvalidInput matches, with errors.Is, the errors of Protect and Reveal caused by the
+	// input rather than by the keys or the service, see InvalidInput
+	ErrInvalidInput = errors.New("invalid input")
+
+	registryMu sync.RWMutex
+	registry   = map[string]entry{}
+)
+
+// Params are the parsed, type-specific parameters of a request
+type Params interface{}
+
+// Keys gives transformers access to key material without exposing where it comes from
+type Keys interface {
+	// Key returns the key derived from the data encryption key for purpose; transformers
+	// never get the data encryption key itself, so no two purposes share a key
+	Key(purpose string) ([]byte, error)
+
+	// Tweak returns the service-wide FF1 tweak
+	Tweak() ([]byte, error)
+}
+
+// A Transformer protects and reveals values of one data type
+type Transformer interface {
+	// Parse decodes the type-specific parameters of a request, usually with DecodeParams
+	Parse(raw json.RawMessage) (Params, error)
+
+	// Validate reports whether input can be protected with params
+	Validate(params Params, input string) error
+
+	// Protect transforms input into its protected form. Input rejected on the way, despite
+	// Validate, is reported with InvalidInput.
+	Protect(keys Keys, params Params, input string) (string, error)
+
+	// Reveal reverses Protect, or returns ErrIrreversible. Input that is not a protected value
+	// is reported with InvalidInput.
+	Reveal(keys Keys, params Params, input string) (string, error)
+}
+
+// InvalidInput marks err as caused by the input, so that it matches ErrInvalidInput while
+// keeping its message and the errors it wraps.
+func InvalidInput(err error) error {
+	if err == nil || errors.Is(err, ErrInvalidInput) {
+		return err
+	}
+	return inputError{err}
+}
+
+type inputError struct {
+	err error
+}
+
+func (e inputError) Error() string {
+	return e.err.Error()
+}
+
+func (e inputError) Unwrap() error {
+	return e.err
+}
+
+func (e inputError) Is(target error) bool {
+	return target == ErrInvalidInput
+}
+
+// Parameter describes one type-specific parameter
+type Parameter struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Info describes a registered type for introspection
+type Info struct {
+	Type        string      `json:"type"`
+	Description string      `json:"description,omitempty"`
+	Reversible  bool        `json:"reversible"`
+	Parameters  []Parameter `json:"parameters"`
+}
+
+type entry struct {
+	info        Info
+	transformer Transformer
+}
+
+// Register makes a transformer available under info.Type.
+func Register(info Info, t Transformer) error {
+	if info.Type == "" || t == nil {
+		return errors.New("type name and transformer must not be empty")
+	}
+
+	if info.Parameters == nil {
+		info.Parameters = []Parameter{}
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[info.Type]; ok {
+		return ErrTypeExists
+	}
+	registry[info.Type] = entry{info, t}
+
+	return nil
+}
+
+// MustRegister is like Register but panics on error; meant for init functions.
+func MustRegister(info Info, t Transformer) {
+	if err := Register(info, t); err != nil {
+		panic("transform: " + info.Type + ": " + err.Error())
+	}
+}
+
+// Lookup returns the transformer registered under name and its description.
+func Lookup(name string) (Transformer, Info, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	e, ok := registry[name]
+	return e.transformer, e.info, ok
+}
+
+// Types describes all registered types in sorted order.
+func Types() []Info {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	infos := make([]Info, 0, len(registry))
+	for _, e := range registry {
+		infos = append(infos, e.info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Type < infos[j].Type })
+
+	return infos
+}
+
+// DecodeParams strictly decodes raw into v; missing parameters leave v unchanged.
+func DecodeParams(raw json.RawMessage, v interface{}) error {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
@@ -0,0 +1,171 @@
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	testKey   = "2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94"
+	testTweak = "D8E7920AFA330A73"
+)
+
+type testKeys struct{}
+
+func (testKeys) Key(purpose string) ([]byte, error) {
+	key, _ := hex.DecodeString(testKey)
+	if purpose == "" {
+		return nil, errors.New("key purpose is required")
+	}
+	digest := sha256.Sum256(append(key, purpose...))
+	return digest[:], nil
+}
+
+func (testKeys) Tweak() ([]byte, error) {
+	return hex.DecodeString(testTweak)
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		typ    string
+		params string
+		input  string
+	}{
+		{"fpe", `{"radix": 10}`, "0123456789"},
+		{"format", `{"format": "luhn"}`, "4111111111111111"},
+		{"pattern", `{"pattern": "[A-Z]{2}\\d{6}"}`, "AB123456"},
+		{"long", `{"radix": 10, "blockSize": 8}`, strings.Repeat("0123456789", 3)},
+		{"ope", `{"domain": "date"}`, "2022-03-31"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.typ, func(t *testing.T) {
+			tr, info, ok := Lookup(test.typ)
+			if !ok || !info.Reversible {
+				t.Fatalf("Type %s is not registered as reversible", test.typ)
+			}
+
+			params, err := tr.Parse(json.RawMessage(test.params))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			if err := tr.Validate(params, test.input); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+
+			token, err := tr.Protect(testKeys{}, params, test.input)
+			if err != nil {
+				t.Fatalf("Protect: %v", err)
+			}
+
+			revealed, err := tr.Reveal(testKeys{}, params, token)
+			if err != nil {
+				t.Fatalf("Reveal: %v", err)
+			}
+
+			if revealed != test.input {
+				t.Fatalf("Round trip failed.\nExpected: %v\nGot: %v", test.input, revealed)
+			}
+		})
+	}
+}
+
+func TestIrreversible(t *testing.T) {
+	tests := map[string]string{
+		"mask":       `{"type": "partial", "keepLast": 4}`,
+		"generalize": `{"kind": "date", "date": {"granularity": "year"}}`,
+		"hash":       `{"encoding": "fpe"}`,
+	}
+
+	for typ, raw := range tests {
+		tr, info, _ := Lookup(typ)
+		if info.Reversible {
+			t.Fatalf("Type %s must not be reversible", typ)
+		}
+
+		params, err := tr.Parse(json.RawMessage(raw))
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", typ, err)
+		}
+
+		if _, err := tr.Protect(testKeys{}, params, "1990-01-01"); err != nil {
+			t.Fatalf("%s: Protect: %v", typ, err)
+		}
+
+		if _, err := tr.Reveal(testKeys{}, params, "x"); err != ErrIrreversible {
+			t.Fatalf("%s: expected ErrIrreversible, got %v", typ, err)
+		}
+	}
+}
+
+func TestParseRejectsUnknownParameters(t *testing.T) {
+	tr, _, _ := Lookup("fpe")
+	if _, err := tr.Parse(json.RawMessage(`{"radix": 10, "tweak": "00"}`)); err == nil {
+		t.Fatalf("Expected an error for an unknown parameter")
+	}
+
+	if _, err := tr.Parse(nil); err == nil {
+		t.Fatalf("Expected an error for a missing radix")
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	tests := []struct {
+		typ    string
+		params string
+		input  string
+	}{
+		{"fpe", `{"radix": 10}`, "01234x6789"},
+		{"fpe", `{"radix": 10}`, "-123456789"},
+		{"format", `{"format": "luhn"}`, "4111111111111112"},
+		{"pattern", `{"pattern": "[A-Z]{2}\\d{6}"}`, "AB12345"},
+		{"ope", `{}`, "not-a-token"},
+	}
+
+	for _, test := range tests {
+		tr, _, _ := Lookup(test.typ)
+		params, err := tr.Parse(json.RawMessage(test.params))
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", test.typ, err)
+		}
+
+		if _, err := tr.Reveal(testKeys{}, params, test.input); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("%s: expected ErrInvalidInput for %q, got %v", test.typ, test.input, err)
+		}
+	}
+}
+
+type upperTransformer struct{}
+
+func (upperTransformer) Parse(raw json.RawMessage) (Params, error)   { return nil, nil }
+func (upperTransformer) Validate(params Params, input string) error  { return nil }
+func (upperTransformer) Reveal(Keys, Params, string) (string, error) { return "", ErrIrreversible }
+func (upperTransformer) Protect(_ Keys, _ Params, s string) (string, error) {
+	return strings.ToUpper(s), nil
+}
+
+func TestRegister(t *testing.T) {
+	if err := Register(Info{Type: "test-upper"}, upperTransformer{}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	if err := Register(Info{Type: "test-upper"}, upperTransformer{}); err != ErrTypeExists {
+		t.Fatalf("Expected ErrTypeExists, got %v", err)
+	}
+
+	found := false
+	for i, info := range Types() {
+		if i > 0 && Types()[i-1].Type >= info.Type {
+			t.Fatalf("Types are not sorted")
+		}
+		found = found || info.Type == "test-upper"
+	}
+	if !found {
+		t.Fatalf("Registered type is not listed")
+	}
+}
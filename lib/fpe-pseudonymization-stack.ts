@@ -4,7 +4,9 @@ import * as lambda from '@aws-cdk/aws-lambda'
 import * as apigatewayv2 from '@aws-cdk/aws-apigatewayv2'
 import * as logs from '@aws-cdk/aws-logs'
 import * as kms from '@aws-cdk/aws-kms'
+import * as secretsmanager from '@aws-cdk/aws-secretsmanager'
 import * as cognito from '@aws-cdk/aws-cognito'
+import * as dynamodb from '@aws-cdk/aws-dynamodb'
 import * as path from 'path'
 import { spawnSync, SpawnSyncOptions } from 'child_process';
 import { CfnIntegrationResponse, CorsHttpMethod } from '@aws-cdk/aws-apigatewayv2';
@@ -47,6 +49,24 @@ export class FpePseudonymizationStack extends cdk.Stack {
 			enableKeyRotation: true,
 		});
 
+		/**
+		 * [2022-02-28]
+		 * Token vault table shared by all instances of the FPE function; the in-memory store would lose the
+		 * mappings with the instance.
+		 */
+		const tokenTable = new dynamodb.Table(
+			this,
+			'FpeTokenTable',
+			{
+				partitionKey: { name: 'pk', type: dynamodb.AttributeType.STRING },
+				billingMode: dynamodb.BillingMode.PAY_PER_REQUEST,
+				encryption: dynamodb.TableEncryption.CUSTOMER_MANAGED,
+				encryptionKey: fpeMasterKey,
+				pointInTimeRecovery: true,
+				removalPolicy: cdk.RemovalPolicy.RETAIN,
+			}
+		);
+
 		const asset = path.join(__dirname, "../lambda/fpe");
 		const environment = {
 			CGO_ENABLED: '0',
@@ -124,7 +144,14 @@ export class FpePseudonymizationStack extends cdk.Stack {
 					'FPE_MASTER_KEY_ARN': fpeMasterKey.keyArn,
 					'FPE_DEK_SECRET_NAME': '/secret/fpe/dek',
 					// Tweak value for FPE.
-					'FPE_TWEAK': 'D8E7920AFA330A73'
+					'FPE_TWEAK': 'D8E7920AFA330A73',
+					// Pseudonymization policy (JSON or YAML) naming the data classes.
+					'POLICY_SECRET_NAME': '/secret/fpe/policy',
+					// Persistent token vault.
+					'TOKEN_STORE': 'dynamodb',
+					'TOKEN_STORE_TABLE': tokenTable.tableName,
+					// [2022-05-02] KMS encryption context binding wrapped keys to this service and their secret; logged in CloudTrail.
+					'FPE_ENCRYPTION_CONTEXT': 'service=fpe-pseudonymization,secretName={secretName},tenant={tenant}'
 				}
 			}
 		);
@@ -150,6 +177,109 @@ export class FpePseudonymizationStack extends cdk.Stack {
 		fpeLambdaFunction.grantPrincipal.addToPrincipalPolicy(lambdaFunctionPermissionPolicy);
 		// Grant encrypt/decrypt to this Lambda.
 		fpeMasterKey.grantEncryptDecrypt(fpeLambdaFunction.grantPrincipal);
+		// Read and write the token vault.
+		tokenTable.grantReadWriteData(fpeLambdaFunction);
+
+		/**
+		 * [2022-04-18]
+		 * Secrets Manager rotation function of the data encryption keyset, built from cmd/rotation of the same module.
+		 */
+		const fpeRotationFunction = new lambda.Function(
+			this,
+			'FpeRotationFunction',
+			{
+				code: lambda.Code.fromAsset(
+					asset,
+					{
+						bundling: {
+							local: {
+								tryBundle(outputDir: string) {
+									try {
+										exec('go version', { stdio: ['ignore', process.stderr, 'inherit'] });
+									} catch {
+										return false;
+									}
+
+									exec(
+										`go build -mod=vendor -o ${path.join(outputDir, 'bootstrap')} ./cmd/rotation`,
+										{
+											env: { ...process.env, ...environment},
+											stdio: ['ignore', process.stderr, 'inherit'],
+											cwd: asset,
+										},
+									);
+
+									return true;
+								},
+							},
+							image: lambda.Runtime.GO_1_X.bundlingImage,
+							command: [
+								'bash',
+								'-c',
+								'go build -mod=vendor -o /asset-output/bootstrap ./cmd/rotation',
+							],
+							environment: environment
+						},
+					}
+				),
+				handler: 'bootstrap',
+				runtime: lambda.Runtime.GO_1_X,
+				timeout: cdk.Duration.seconds(30),
+				environment: {
+					'FPE_MASTER_KEY_ARN': fpeMasterKey.keyArn,
+					// Tweak value the new key is self-tested with.
+					'FPE_TWEAK': 'D8E7920AFA330A73',
+					// [2022-05-05] New keys are bound to the encryption context the FPE function expects; the tenant is read from the secret name.
+					'FPE_DEK_SECRET_NAME': '/secret/fpe/dek',
+					'FPE_ENCRYPTION_CONTEXT': 'service=fpe-pseudonymization,secretName={secretName},tenant={tenant}'
+				}
+			}
+		);
+		fpeRotationFunction.grantPrincipal.addToPrincipalPolicy(
+			new iam.PolicyStatement(
+				{
+					actions: [
+						'secretsmanager:DescribeSecret',
+						'secretsmanager:GetSecretValue',
+						'secretsmanager:PutSecretValue',
+						'secretsmanager:UpdateSecretVersionStage'
+					],
+					resources: ['*'],
+					effect: iam.Effect.ALLOW
+				}
+			)
+		);
+		fpeMasterKey.grantEncryptDecrypt(fpeRotationFunction.grantPrincipal);
+
+		/**
+		 * The keyset secrets are created here, holding the "uninitialized" placeholder, and rotated
+		 * right away: the first rotation generates their first key, see package keyset.
+		 * Tenants listed in the "tenants" context value each have a keyset secret of their own,
+		 * e.g. cdk deploy -c tenants=acme,globex or a list in cdk.json.
+		 */
+		const tenantsContext = this.node.tryGetContext('tenants') ?? [];
+		const tenants: string[] = typeof tenantsContext === 'string' ? tenantsContext.split(',').filter(t => t != '') : tenantsContext;
+		const dekSecretNames = ['/secret/fpe/dek', ...tenants.map(tenant => `/secret/fpe/dek/tenants/${tenant}`)];
+		dekSecretNames.forEach((secretName, i) => {
+			const id = i == 0 ? 'FpeDek' : `FpeDekTenant${tenants[i - 1]}`;
+			const dekSecret = new secretsmanager.Secret(
+				this,
+				`${id}Secret`,
+				{
+					secretName: secretName,
+					description: 'FPE data encryption keyset protected by KMS CMK.',
+					secretStringBeta1: secretsmanager.SecretStringValueBeta1.fromUnsafePlaintext('uninitialized'),
+					removalPolicy: RemovalPolicy.RETAIN,
+				}
+			);
+			dekSecret.addRotationSchedule(
+				`${id}Rotation`,
+				{
+					rotationLambda: fpeRotationFunction,
+					automaticallyAfter: cdk.Duration.days(90),
+				}
+			);
+		});
 
 		/**
 		 * [2021-12-07]
@@ -171,7 +301,11 @@ export class FpePseudonymizationStack extends cdk.Stack {
 					requireUppercase: true,
 					requireSymbols: true,
 				},
-				accountRecovery: cognito.AccountRecovery.EMAIL_ONLY
+				accountRecovery: cognito.AccountRecovery.EMAIL_ONLY,
+				// [2022-04-28] Tenant of the user, the "custom:tenant" claim of ID tokens; set by administrators only.
+				customAttributes: {
+					tenant: new cognito.StringAttribute({ minLen: 1, maxLen: 64, mutable: true }),
+				},
 			}
 		);
 
@@ -190,6 +324,12 @@ export class FpePseudonymizationStack extends cdk.Stack {
 				supportedIdentityProviders: [
 					cognito.UserPoolClientIdentityProvider.COGNITO,
 				],
+				// Users sign up and update themselves through this client, so it must not write the tenant.
+				readAttributes: new cognito.ClientAttributes()
+					.withStandardAttributes({ email: true, emailVerified: true, fullname: true, givenName: true, familyName: true })
+					.withCustomAttributes('tenant'),
+				writeAttributes: new cognito.ClientAttributes()
+					.withStandardAttributes({ email: true, fullname: true, givenName: true, familyName: true }),
 			}
 		);
 
@@ -302,9 +442,166 @@ export class FpePseudonymizationStack extends cdk.Stack {
 			}
 		);
 
+		api.addRoutes(
+			{
+				path: '/pseudonymize',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.POST],
+				authorizer: authorizer
+			}
+		);
+
+		api.addRoutes(
+			{
+				path: '/tokenize',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.POST],
+				authorizer: authorizer
+			}
+		);
+
+		api.addRoutes(
+			{
+				path: '/detokenize',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.POST],
+				authorizer: authorizer
+			}
+		);
+
+		api.addRoutes(
+			{
+				path: '/mask',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.POST],
+				authorizer: authorizer
+			}
+		);
+
+		api.addRoutes(
+			{
+				path: '/blind-index',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.POST],
+				authorizer: authorizer
+			}
+		);
+
+		api.addRoutes(
+			{
+				path: '/scan-and-protect',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.POST],
+				authorizer: authorizer
+			}
+		);
+
+		api.addRoutes(
+			{
+				path: '/scan-and-restore',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.POST],
+				authorizer: authorizer
+			}
+		);
+
+		api.addRoutes(
+			{
+				path: '/profile',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.POST],
+				authorizer: authorizer
+			}
+		);
+
+		api.addRoutes(
+			{
+				path: '/types',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.GET, apigatewayv2.HttpMethod.POST],
+				authorizer: authorizer
+			}
+		);
+
+		api.addRoutes(
+			{
+				path: '/health',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.GET],
+				authorizer: authorizer
+			}
+		);
+
+		api.addRoutes(
+			{
+				path: '/reencrypt',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.POST],
+				authorizer: authorizer
+			}
+		);
+
+		api.addRoutes(
+			{
+				path: '/translate',
+				integration: new LambdaProxyIntegration(
+					{
+						handler: fpeLambdaFunction
+					}
+				),
+				methods: [apigatewayv2.HttpMethod.POST],
+				authorizer: authorizer
+			}
+		);
+
 		new cdk.CfnOutput(this, 'FpeMasterKeyArn', {value: fpeMasterKey.keyArn,});
 		new cdk.CfnOutput(this, 'ApiUrlOutput', {value: api.url!});
 		new cdk.CfnOutput(this, 'UserPoolId', { value: userPool.userPoolId });
 		new cdk.CfnOutput(this, 'UserPoolClientId', { value: userPoolClient.userPoolClientId });
+		new cdk.CfnOutput(this, 'TokenTableName', { value: tokenTable.tableName });
   }
 }